@@ -0,0 +1,663 @@
+package gtl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ANFProgram is a program in A-normal form, an intermediate representation for optimizations and backends.
+// Every intermediate result is bound by let to a variable, so arguments of functions and constructors are
+// atoms, which are variables or constants. Lambdas are let-bound as well.
+// If, match and unpack are also let-bound, and their branches are expressions of their own.
+// Types are kept where they have runtime meaning, such as variants, but annotations of lambdas are dropped.
+type ANFProgram struct {
+	definitions []anfDefinition
+	main        *anfExpr
+}
+
+type anfDefinition struct {
+	name string
+	expr *anfExpr
+}
+
+// anfExpr is "let x1 = r1 in ... let xn = rn in a".
+type anfExpr struct {
+	bindings []anfBinding
+	result   anfAtom
+}
+
+type anfBinding struct {
+	name string
+	rhs  anfRHS
+}
+
+// anfAtom is a variable or a constant such as 0, "s", nil or a built-in function.
+type anfAtom struct {
+	name  string
+	value *Node // nil for a variable
+}
+
+// anfRHS is a right-hand side of let: an atom or a computation whose operands are atoms.
+type anfRHS interface {
+	node() *Node
+}
+
+type (
+	anfLambda struct {
+		params []string
+		body   *anfExpr
+	}
+	anfTypeAbstraction struct {
+		name  string
+		bound *Node
+		body  *anfExpr
+	}
+	// anfApply applies a function to arguments one by one.
+	anfApply struct {
+		fn   anfAtom
+		args []anfAtom
+	}
+	// anfPrimitive is a built-in function which is given all of its arguments.
+	anfPrimitive struct {
+		op   *Node
+		args []anfAtom
+	}
+	anfTypeApplication struct {
+		fn anfAtom
+		ty *Node
+	}
+	anfTuple struct {
+		elems []anfAtom
+	}
+	anfVariant struct {
+		label string
+		value anfAtom
+		ty    *Node
+	}
+	anfPack struct {
+		hidden *Node
+		value  anfAtom
+		ty     *Node
+	}
+	anfUnpack struct {
+		typeName string
+		name     string
+		pack     anfAtom
+		body     *anfExpr
+	}
+	anfIf struct {
+		cond                anfAtom
+		truePart, falsePart *anfExpr
+	}
+	anfMatch struct {
+		scrutinee anfAtom
+		patterns  []*Node
+		bodies    []*anfExpr
+		tree      *decisionTree
+	}
+)
+
+// ConvertANF converts a program into A-normal form. Subterms are evaluated from left to right like Eval.
+func ConvertANF(ast *AST) (*ANFProgram, error) {
+	c := &anfConverter{names: newNameSupply(ast)}
+	p := &ANFProgram{}
+	for _, d := range ast.Declarations {
+		if d.NodeType != Definition { // types have no runtime meaning
+			continue
+		}
+		e, err := c.expr(d.Children[0])
+		if err != nil {
+			return nil, fmt.Errorf("def %s: %v", d.Name, err)
+		}
+		p.definitions = append(p.definitions, anfDefinition{d.Name, e})
+	}
+	e, err := c.expr(ast.Child)
+	if err != nil {
+		return nil, err
+	}
+	p.main = e
+	return p, nil
+}
+
+type anfConverter struct {
+	names nameSupply
+}
+
+func (c *anfConverter) expr(n *Node) (*anfExpr, error) {
+	e := &anfExpr{}
+	a, err := c.atom(n, e)
+	if err != nil {
+		return nil, err
+	}
+	e.result = a
+	return e, nil
+}
+
+// bind adds a binding of rhs to e, and returns the variable.
+func (c *anfConverter) bind(e *anfExpr, rhs anfRHS) anfAtom {
+	name := c.names.fresh("t")
+	e.bindings = append(e.bindings, anfBinding{name, rhs})
+	return anfAtom{name: name}
+}
+
+// atom adds bindings which compute n to e, and returns an atom of its value.
+func (c *anfConverter) atom(n *Node, e *anfExpr) (anfAtom, error) {
+	switch n.NodeType {
+	case True, False, Zero, NodeNumber, StringLiteral, Nil:
+		return anfAtom{value: n}, nil
+	case Variable, FreeVariable:
+		return anfAtom{name: n.Name}, nil
+	case IsZero, Succ, Pred, Cons, IsNil, Head, Tail, Concat, StrLen, StrEq, BinaryOperator:
+		return c.apply(&Node{NodeType: n.NodeType, Name: n.Name}, n.Children, e)
+	case Lambda:
+		var params []string
+		for _, p := range n.Children[0].Children {
+			params = append(params, p.Name)
+		}
+		body, err := c.expr(n.Children[1].Children[0])
+		if err != nil {
+			return anfAtom{}, err
+		}
+		return c.bind(e, &anfLambda{params, body}), nil
+	case TypeAbstraction:
+		body, err := c.expr(n.Children[0])
+		if err != nil {
+			return anfAtom{}, err
+		}
+		return c.bind(e, &anfTypeAbstraction{n.Name, n.Children[1], body}), nil
+	case TypeApplication:
+		if isListPrimitive(n.Children[0]) { // nil[T] is nil at runtime
+			return anfAtom{value: n.Children[0]}, nil
+		}
+		f, err := c.atom(n.Children[0], e)
+		if err != nil {
+			return anfAtom{}, err
+		}
+		return c.bind(e, &anfTypeApplication{f, n.Children[1]}), nil
+	case Apply:
+		var args []*Node
+		f := n
+		for ; f.NodeType == Apply; f = f.Children[0] {
+			args = append([]*Node{f.Children[1]}, args...)
+		}
+		return c.apply(f, args, e)
+	case IF:
+		cond, err := c.atom(n.Children[0], e)
+		if err != nil {
+			return anfAtom{}, err
+		}
+		truePart, err := c.expr(n.Children[1])
+		if err != nil {
+			return anfAtom{}, err
+		}
+		falsePart, err := c.expr(n.Children[2])
+		if err != nil {
+			return anfAtom{}, err
+		}
+		return c.bind(e, &anfIf{cond, truePart, falsePart}), nil
+	case Tuple:
+		elems, err := c.atoms(n.Children, e)
+		if err != nil {
+			return anfAtom{}, err
+		}
+		return c.bind(e, &anfTuple{elems}), nil
+	case Variant:
+		v, err := c.atom(n.Children[0], e)
+		if err != nil {
+			return anfAtom{}, err
+		}
+		return c.bind(e, &anfVariant{n.Name, v, n.Children[1]}), nil
+	case Pack:
+		v, err := c.atom(n.Children[1], e)
+		if err != nil {
+			return anfAtom{}, err
+		}
+		return c.bind(e, &anfPack{n.Children[0], v, n.Children[2]}), nil
+	case Unpack:
+		p, err := c.atom(n.Children[1], e)
+		if err != nil {
+			return anfAtom{}, err
+		}
+		body, err := c.expr(n.Children[2])
+		if err != nil {
+			return anfAtom{}, err
+		}
+		return c.bind(e, &anfUnpack{n.Name, n.Children[0].Name, p, body}), nil
+	case Match:
+		v, err := c.atom(n.Children[0], e)
+		if err != nil {
+			return anfAtom{}, err
+		}
+		m := &anfMatch{scrutinee: v}
+		for _, mc := range n.Children[1:] {
+			body, err := c.expr(mc.Children[1])
+			if err != nil {
+				return anfAtom{}, err
+			}
+			m.patterns = append(m.patterns, mc.Children[0])
+			m.bodies = append(m.bodies, body)
+		}
+		m.tree, _ = compileMatch(n, nil, nil)
+		return c.bind(e, m), nil
+	}
+	return anfAtom{}, fmt.Errorf("%s is not supported by A-normal form", n)
+}
+
+func (c *anfConverter) atoms(ns []*Node, e *anfExpr) ([]anfAtom, error) {
+	ret := make([]anfAtom, len(ns))
+	for i, n := range ns {
+		a, err := c.atom(n, e)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = a
+	}
+	return ret, nil
+}
+
+// apply converts an application of f to args. a built-in function which is given all arguments is
+// a primitive computation, and otherwise it is a constant function.
+func (c *anfConverter) apply(f *Node, args []*Node, e *anfExpr) (anfAtom, error) {
+	var fn anfAtom
+	if p, ok := primitiveOf(f); ok {
+		arity := primitives[p].arity
+		if len(args) >= arity {
+			operands, err := c.atoms(args[:arity], e)
+			if err != nil {
+				return anfAtom{}, err
+			}
+			fn, args = c.bind(e, &anfPrimitive{f, operands}), args[arity:]
+		} else {
+			fn = anfAtom{value: f}
+		}
+	} else {
+		var err error
+		if fn, err = c.atom(f, e); err != nil {
+			return anfAtom{}, err
+		}
+	}
+	if len(args) == 0 {
+		return fn, nil
+	}
+	operands, err := c.atoms(args, e)
+	if err != nil {
+		return anfAtom{}, err
+	}
+	return c.bind(e, &anfApply{fn, operands}), nil
+}
+
+// String prints the program. let is followed by its body on the next line.
+func (p *ANFProgram) String() string {
+	var lines []string
+	for _, d := range p.definitions {
+		def := p.formatDefinition(d)
+		def[len(def)-1] += ";"
+		lines = append(lines, def...)
+	}
+	lines = append(lines, p.main.lines()...)
+	return strings.Join(lines, "\n")
+}
+
+func (p *ANFProgram) formatDefinition(d anfDefinition) []string {
+	lines := d.expr.lines()
+	lines[0] = fmt.Sprintf("def %s = %s", d.name, lines[0])
+	return lines
+}
+
+func indentLines(lines []string) []string {
+	ret := make([]string, len(lines))
+	for i, l := range lines {
+		ret[i] = "  " + l
+	}
+	return ret
+}
+
+// lines formats the expression. "let t = r in t" is printed as r in tail position.
+func (e *anfExpr) lines() []string {
+	bindings := e.bindings
+	if n := len(bindings); n != 0 && e.result.value == nil && bindings[n-1].name == e.result.name {
+		bindings = bindings[:n-1]
+	}
+	var ret []string
+	for _, b := range bindings {
+		rhs := anfRHSLines(b.rhs)
+		if len(rhs) == 1 {
+			ret = append(ret, fmt.Sprintf("let %s = %s in", b.name, rhs[0]))
+			continue
+		}
+		ret = append(ret, fmt.Sprintf("let %s = %s", b.name, rhs[0]))
+		ret = append(ret, rhs[1:]...)
+		ret = append(ret, "in")
+	}
+	if len(bindings) < len(e.bindings) {
+		return append(ret, anfRHSLines(e.bindings[len(bindings)].rhs)...)
+	}
+	return append(ret, e.result.String())
+}
+
+func (a anfAtom) String() string {
+	if a.value == nil {
+		return a.name
+	}
+	return a.value.String()
+}
+
+func joinAtoms(atoms []anfAtom, sep string) string {
+	var tmp []string
+	for _, a := range atoms {
+		tmp = append(tmp, a.String())
+	}
+	return strings.Join(tmp, sep)
+}
+
+// anfRHSLines formats a right-hand side, which spans several lines if it has expressions.
+func anfRHSLines(r anfRHS) []string {
+	switch r := r.(type) {
+	case anfAtom:
+		return []string{r.String()}
+	case *anfLambda:
+		return append([]string{"." + strings.Join(r.params, " .") + " ->"}, indentLines(r.body.lines())...)
+	case *anfTypeAbstraction:
+		return append([]string{fmt.Sprintf("\\%s ->", binderString(r.name, r.bound, true))}, indentLines(r.body.lines())...)
+	case *anfApply:
+		return []string{fmt.Sprintf("%s %s", r.fn, joinAtoms(r.args, " "))}
+	case *anfPrimitive:
+		if r.op.NodeType == BinaryOperator {
+			return []string{fmt.Sprintf("%s %s %s", r.args[0], r.op.Name, r.args[1])}
+		}
+		return []string{fmt.Sprintf("%s %s", r.op, joinAtoms(r.args, " "))}
+	case *anfTypeApplication:
+		return []string{fmt.Sprintf("%s [%s]", r.fn, r.ty)}
+	case *anfTuple:
+		return []string{fmt.Sprintf("{%s}", joinAtoms(r.elems, ", "))}
+	case *anfVariant:
+		return []string{fmt.Sprintf("<%s = %s> as %s", r.label, r.value, r.ty)}
+	case *anfPack:
+		return []string{fmt.Sprintf("{*%s, %s} as %s", r.hidden, r.value, r.ty)}
+	case *anfUnpack:
+		return append([]string{fmt.Sprintf("let {%s, %s} = %s in", r.typeName, r.name, r.pack)}, indentLines(r.body.lines())...)
+	case *anfIf:
+		ret := []string{fmt.Sprintf("if %s then", r.cond)}
+		ret = append(ret, indentLines(r.truePart.lines())...)
+		ret = append(ret, "else")
+		return append(ret, indentLines(r.falsePart.lines())...)
+	case *anfMatch:
+		ret := []string{fmt.Sprintf("match %s with", r.scrutinee)}
+		for i, p := range r.patterns {
+			ret = append(ret, fmt.Sprintf("| %s ->", p))
+			ret = append(ret, indentLines(r.bodies[i].lines())...)
+		}
+		return ret
+	}
+	panic(fmt.Sprintf("unknown right-hand side %T", r))
+}
+
+// node returns a term of the expression, where let x = r in t is (.x -> t) r.
+// a temporary which is used once is replaced with r, so the term of a converted lambda is
+// the lambda before the conversion, and temporaries do not appear in results of EvalANF.
+func (e *anfExpr) node() *Node {
+	ret := e.result.node()
+	for i := len(e.bindings) - 1; i >= 0; i-- {
+		b := e.bindings[i]
+		if occurrences(ret, b.name) == 1 {
+			ret = substTerm(ret, b.name, b.rhs.node())
+			continue
+		}
+		ret = applyTerms(untypedLambda([]string{b.name}, ret), b.rhs.node())
+	}
+	return ret
+}
+
+func (a anfAtom) node() *Node {
+	if a.value == nil {
+		return &Node{NodeType: Variable, Name: a.name}
+	}
+	return a.value
+}
+
+func atomNodes(atoms []anfAtom) []*Node {
+	ret := make([]*Node, len(atoms))
+	for i, a := range atoms {
+		ret[i] = a.node()
+	}
+	return ret
+}
+
+func (r *anfLambda) node() *Node {
+	return untypedLambda(r.params, r.body.node())
+}
+
+func (r *anfTypeAbstraction) node() *Node {
+	return &Node{NodeType: TypeAbstraction, Name: r.name, Children: []*Node{r.body.node(), r.bound}}
+}
+
+func (r *anfApply) node() *Node {
+	return applyTerms(r.fn.node(), atomNodes(r.args)...)
+}
+
+func (r *anfPrimitive) node() *Node {
+	return applyTerms(r.op, atomNodes(r.args)...)
+}
+
+func (r *anfTypeApplication) node() *Node {
+	return &Node{NodeType: TypeApplication, Children: []*Node{r.fn.node(), r.ty}}
+}
+
+func (r *anfTuple) node() *Node {
+	return &Node{NodeType: Tuple, Children: atomNodes(r.elems)}
+}
+
+func (r *anfVariant) node() *Node {
+	return &Node{NodeType: Variant, Name: r.label, Children: []*Node{r.value.node(), r.ty}}
+}
+
+func (r *anfPack) node() *Node {
+	return &Node{NodeType: Pack, Children: []*Node{r.hidden, r.value.node(), r.ty}}
+}
+
+func (r *anfUnpack) node() *Node {
+	param := &Node{NodeType: LambdaParam, Name: r.name}
+	return &Node{NodeType: Unpack, Name: r.typeName, Children: []*Node{param, r.pack.node(), r.body.node()}}
+}
+
+func (r *anfIf) node() *Node {
+	return &Node{NodeType: IF, Children: []*Node{r.cond.node(), r.truePart.node(), r.falsePart.node()}}
+}
+
+func (r *anfMatch) node() *Node {
+	ret := &Node{NodeType: Match, Children: []*Node{r.scrutinee.node()}}
+	for i, p := range r.patterns {
+		ret.Children = append(ret.Children, &Node{NodeType: MatchCase, Children: []*Node{p, r.bodies[i].node()}})
+	}
+	return ret
+}
+
+// EvalANF converts a program into A-normal form, and evaluates it with environments like EvalCEK.
+// Let-bound values are shared by the environment, and type variables are bound there as well,
+// so types of variants and packages are instantiated as Eval does.
+// An open program is read back like Eval, and an if whose condition is stuck is residualized with both
+// branches evaluated. But a match or a let whose value is stuck is an error, as in EvalVM.
+func EvalANF(ast *AST) (*Node, error) {
+	p, err := ConvertANF(ast)
+	if err != nil {
+		return nil, err
+	}
+	v, err := p.eval()
+	if err != nil {
+		return nil, err
+	}
+	return v.readback(), nil
+}
+
+// eval evaluates the program. definitions are global, so they can refer to each other recursively.
+func (p *ANFProgram) eval() (value, error) {
+	m := &anfMachine{globals: make(map[string]value)}
+	for _, d := range p.definitions {
+		v, err := m.eval(d.expr, nil)
+		if err != nil {
+			return nil, err
+		}
+		m.globals[d.name] = v
+	}
+	return m.eval(p.main, nil)
+}
+
+type anfMachine struct {
+	globals map[string]value
+}
+
+// anfClosure is a lambda or a type abstraction with the environment where it is defined.
+type anfClosure struct {
+	rhs anfRHS
+	env *machineEnv
+}
+
+func (c *anfClosure) readback() *Node {
+	return c.env.substitute(c.rhs.node())
+}
+
+// type variables are bound in the same environment as variables, with names which no variables have.
+func typeVariableKey(name string) string {
+	return "type " + name
+}
+
+// instantiate replaces type variables in ty which are bound in the environment.
+func (m *anfMachine) instantiate(ty *Node, env *machineEnv) *Node {
+	for name := range freeTypeVariables(ty) {
+		if v, ok := env.lookup(typeVariableKey(name)); ok {
+			ty = substType(ty, name, v.readback())
+		}
+	}
+	return ty
+}
+
+func (m *anfMachine) eval(e *anfExpr, env *machineEnv) (value, error) {
+	for _, b := range e.bindings {
+		v, err := m.evalRHS(b.rhs, env)
+		if err != nil {
+			return nil, err
+		}
+		env = env.extend(b.name, v)
+	}
+	return m.atom(e.result, env), nil
+}
+
+func (m *anfMachine) atom(a anfAtom, env *machineEnv) value {
+	if a.value != nil {
+		if a.value.NodeType == Cons && len(a.value.Children) == 0 {
+			return &data{node: a.value}
+		}
+		return atom{a.value}
+	}
+	if v, ok := env.lookup(a.name); ok {
+		return v
+	}
+	if v, ok := m.globals[a.name]; ok {
+		return v
+	}
+	return atom{&Node{NodeType: Variable, Name: a.name}}
+}
+
+func (m *anfMachine) atoms(atoms []anfAtom, env *machineEnv) []value {
+	ret := make([]value, len(atoms))
+	for i, a := range atoms {
+		ret[i] = m.atom(a, env)
+	}
+	return ret
+}
+
+func (m *anfMachine) evalRHS(r anfRHS, env *machineEnv) (value, error) {
+	switch r := r.(type) {
+	case anfAtom:
+		return m.atom(r, env), nil
+	case *anfLambda, *anfTypeAbstraction:
+		return &anfClosure{r, env}, nil
+	case *anfApply:
+		return m.apply(m.atom(r.fn, env), m.atoms(r.args, env))
+	case *anfPrimitive:
+		return m.apply(m.atom(anfAtom{value: r.op}, env), m.atoms(r.args, env))
+	case *anfTypeApplication:
+		f := m.atom(r.fn, env)
+		if c, ok := f.(*anfClosure); ok {
+			if t, ok := c.rhs.(*anfTypeAbstraction); ok {
+				return m.eval(t.body, c.env.extend(typeVariableKey(t.name), atom{m.instantiate(r.ty, env)}))
+			}
+		}
+		return atom{&Node{NodeType: TypeApplication, Children: []*Node{f.readback(), r.ty}}}, nil
+	case *anfTuple:
+		return &data{node: &Node{NodeType: Tuple}, fields: m.atoms(r.elems, env)}, nil
+	case *anfVariant:
+		node := &Node{NodeType: Variant, Name: r.label, Children: []*Node{nil, m.instantiate(r.ty, env)}}
+		return &data{node: node, fields: []value{m.atom(r.value, env)}}, nil
+	case *anfPack:
+		node := &Node{NodeType: Pack, Children: []*Node{m.instantiate(r.hidden, env), nil, m.instantiate(r.ty, env)}}
+		return &data{node: node, fields: []value{m.atom(r.value, env)}}, nil
+	case *anfUnpack:
+		p := m.atom(r.pack, env)
+		d, ok := p.(*data)
+		if !ok || d.node.NodeType != Pack {
+			return nil, fmt.Errorf("cannot unpack %s", p.readback())
+		}
+		env = env.extend(typeVariableKey(r.typeName), atom{d.node.Children[0]})
+		return m.eval(r.body, env.extend(r.name, d.fields[0]))
+	case *anfIf:
+		cond := m.atom(r.cond, env)
+		if a, ok := cond.(atom); ok && a.node.NodeType == True {
+			return m.eval(r.truePart, env)
+		}
+		if a, ok := cond.(atom); ok && a.node.NodeType == False {
+			return m.eval(r.falsePart, env)
+		}
+		ret := &Node{NodeType: IF, Children: []*Node{cond.readback(), nil, nil}}
+		for i, e := range []*anfExpr{r.truePart, r.falsePart} { // both branches of a stuck if are evaluated like Eval does
+			v, err := m.eval(e, env)
+			if err != nil {
+				return nil, err
+			}
+			ret.Children[i+1] = v.readback()
+		}
+		return atom{ret}, nil
+	case *anfMatch:
+		v := m.atom(r.scrutinee, env)
+		leaf, ok, err := selectCase(r.tree, v)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("cannot match %s", v.readback())
+		}
+		for _, b := range leaf.bindings {
+			env = env.extend(b.name, partOf(v, b.occurrence))
+		}
+		return m.eval(r.bodies[leaf.arm], env)
+	}
+	panic(fmt.Sprintf("unknown right-hand side %T", r))
+}
+
+// apply applies a function to arguments one by one.
+func (m *anfMachine) apply(fn value, args []value) (value, error) {
+	for _, arg := range args {
+		var err error
+		c, ok := fn.(*anfClosure)
+		if !ok {
+			if fn, err = applyBuiltin(fn, arg); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		l, ok := c.rhs.(*anfLambda)
+		if !ok {
+			fn = atom{&Node{NodeType: Apply, Children: []*Node{fn.readback(), arg.readback()}}}
+			continue
+		}
+		env := c.env.extend(l.params[0], arg)
+		if len(l.params) > 1 {
+			fn = &anfClosure{&anfLambda{l.params[1:], l.body}, env}
+			continue
+		}
+		if fn, err = m.eval(l.body, env); err != nil {
+			return nil, err
+		}
+	}
+	return fn, nil
+}
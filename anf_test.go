@@ -0,0 +1,103 @@
+package gtl
+
+import "testing"
+
+func TestEvalANF(t *testing.T) {
+	sources := append([]string{
+		`(\X -> .x:X -> <a = x> as <a: X>) [Nat] 0`,
+		"let {X, p} = {*Nat, {0, .x:Nat -> iszero x}} as {Some X, {X, X -> Bool}} in match p with | {x, f} -> f x",
+		"def sum = .n -> if n == 0 then 0 else n + sum (n - 1); sum 10000",
+		"(.x -> {x, x}) (1 + 2)",
+	}, evalSources...)
+	for i, src := range sources {
+		want, err := Eval(buildASTFromString(src))
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		got, err := EvalANF(buildASTFromString(src))
+		if err != nil {
+			if !hasStuckBranch(want) {
+				t.Errorf("case %d: %v", i, err)
+			}
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+	}
+}
+
+// open programs are read back without temporaries, and a stuck if is residualized like Eval.
+func TestEvalANF_open(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"(.x .y -> x y) iszero", ".y -> (iszero y)"},
+		{"(.x -> .y -> x) y", ".y' -> (y)"},
+		{"if x then 0 else succ 0", "if (x) then (0) else (succ (0))"},
+		{"(.f -> f x) (.b -> if b then 1 + 1 else head nil)", "if (x) then (2) else (head nil)"},
+	}
+	for i, v := range testcases {
+		got, err := EvalANF(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got.String() != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+func TestEvalANF_prelude(t *testing.T) {
+	for i, src := range []string{
+		"times (succ (succ 0)) (succ (succ (succ 0)))",
+		"equal (succ 0) (succ 0)",
+		"map (plus (succ 0)) [0, succ 0]",
+		"foldr plus 0 [succ 0, succ 0]",
+		"length (append [0] [0, 0])",
+	} {
+		ast := buildASTWithPrelude(Prelude, src)
+		want, err := Eval(ast)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		got, err := EvalANF(ast)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+	}
+}
+
+func TestConvertANF(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"0", "0"},
+		{"succ (succ 0)", "let t = succ 0 in\nsucc t"},
+		{"(.x -> x) (1 + 2 * 3)", "let t = .x ->\n  x\nin\nlet t1 = 2 * 3 in\nlet t2 = 1 + t1 in\nt t2"},
+		{"def f = .x .y -> {y, x}; f 0", "def f = .x .y ->\n  {y, x};\nf 0"},
+		{"if iszero 0 then cons 0 else head", "let t = iszero 0 in\nif t then\n  cons 0\nelse\n  head"},
+		{"{succ 0, 1 + 2, head}", "let t = succ 0 in\nlet t1 = 1 + 2 in\n{t, t1, head}"},
+		{"match <a = 0> as <a: Nat> with | <a = x> -> x", "let t = <a = 0> as <a: Nat> in\nmatch t with\n| <a = x> ->\n  x"},
+	}
+	for i, v := range testcases {
+		p, err := ConvertANF(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := p.String(); got != v.want {
+			t.Errorf("case %d: want\n%v\nbut got\n%v\n", i, v.want, got)
+		}
+	}
+	if _, err := ConvertANF(buildASTFromString("callcc (.k -> 0)")); err == nil {
+		t.Errorf("callcc should not be supported")
+	}
+}
@@ -0,0 +1,466 @@
+package gtl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// opcode is an operation of the virtual machine. the comment of each opcode describes its operand as arg.
+type opcode uint8
+
+const (
+	opConst        opcode = iota // push constants[arg]
+	opLoadLocal                  // push the local variable arg
+	opStoreLocal                 // pop a value into the local variable arg
+	opLoadCaptured               // push the captured variable arg of the current closure
+	opLoadGlobal                 // push the global variable arg
+	opClosure                    // pop the captured variables of functions[arg], and push a closure of it
+	opCall                       // pop arg arguments and a function, and apply the function to them
+	opTailCall                   // opCall which replaces the current frame
+	opReturn                     // pop a value and return it to the caller
+	opJump                       // jump to arg
+	opJumpIfFalse                // pop a boolean, and jump to arg if it is false. if it is stuck, both branches are evaluated
+	opPrimitive                  // pop the arguments of primitives[arg], and push the result
+	opInstantiate                // pop a type abstraction and call it. types are erased but those of nodes[arg] for a stuck term
+	opTuple                      // pop arg values, and push a tuple of them
+	opVariant                    // pop a value, and push a variant whose label and type are those of nodes[arg]
+	opPack                       // pop a value, and push a package whose types are those of nodes[arg]
+	opUnpack                     // pop a package, and push its value
+	opField                      // pop a value built with a constructor, and push its part arg
+	opTest                       // pop a value, and push whether it is built with constructors[arg]
+	opFail                       // stop because no case matches the local variable arg
+)
+
+var opcodeNames = [...]string{"const", "load_local", "store_local", "load_captured", "load_global", "closure", "call", "tail_call", "return", "jump", "jump_if_false", "primitive", "instantiate", "tuple", "variant", "pack", "unpack", "field", "test", "fail"}
+
+func (op opcode) String() string {
+	return opcodeNames[op]
+}
+
+// instruction is an opcode with its operand.
+type instruction struct {
+	op  opcode
+	arg int32
+}
+
+func (ins instruction) String() string {
+	switch ins.op {
+	case opReturn, opUnpack:
+		return ins.op.String()
+	}
+	return ins.op.String() + " " + strconv.Itoa(int(ins.arg))
+}
+
+// primitive is a built-in function, which is applied when it is given arity arguments.
+type primitive struct {
+	nodeType NodeType
+	name     string // the operator of BinaryOperator
+	arity    int
+}
+
+var primitives = []primitive{
+	{IsZero, "", 1}, {Succ, "", 1}, {Pred, "", 1},
+	{Cons, "", 2}, {IsNil, "", 1}, {Head, "", 1}, {Tail, "", 1},
+	{Concat, "", 2}, {StrLen, "", 1}, {StrEq, "", 2},
+	{BinaryOperator, "+", 2}, {BinaryOperator, "-", 2}, {BinaryOperator, "*", 2}, {BinaryOperator, "/", 2},
+	{BinaryOperator, "<", 2}, {BinaryOperator, "==", 2},
+}
+
+// primitiveOf returns the index of the built-in function of n in primitives.
+func primitiveOf(n *Node) (int, bool) {
+	for i, p := range primitives {
+		if p.nodeType == n.NodeType && p.name == n.Name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// function is the bytecode of a lambda, a type abstraction, a definition or the main term.
+// its local variables are its parameters followed by variables bound in its body.
+type function struct {
+	name     string
+	source   *Node    // the Lambda or TypeAbstraction, to read back closures
+	arity    int      // the number of parameters. type abstractions have no parameters
+	locals   int      // the number of local variables
+	captures []string // free variables which are bound in enclosing functions
+	code     []instruction
+}
+
+func (f *function) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s/%d", f.name, f.arity)
+	if len(f.captures) != 0 {
+		fmt.Fprintf(&b, " [%s]", strings.Join(f.captures, ", "))
+	}
+	b.WriteString(":\n")
+	for i, ins := range f.code {
+		fmt.Fprintf(&b, "%4d %s\n", i, ins)
+	}
+	return b.String()
+}
+
+// program is a compiled AST. instructions refer to its tables by indices.
+type program struct {
+	functions    []*function
+	constants    []vmValue
+	nodes        []*Node
+	constructors []constructor
+	globals      []string
+	definitions  []definition // evaluated in order before main
+	main         *function
+}
+
+type definition struct {
+	global int
+	fn     *function
+}
+
+func (p *program) String() string {
+	var b strings.Builder
+	for _, f := range p.functions {
+		b.WriteString(f.String())
+	}
+	return b.String()
+}
+
+// compiler compiles terms into the bytecode of the function in scope.
+type compiler struct {
+	program *program
+	globals map[string]int
+	scope   *scope
+}
+
+// scope is a function being compiled, whose enclosing functions are also being compiled.
+type scope struct {
+	fn     *function
+	parent *scope
+	locals []localVariable // the innermost binding is the last
+}
+
+type localVariable struct {
+	name string
+	slot int
+}
+
+// compileProgram compiles definitions and the main term of an AST.
+// every definition is a global variable, so definitions can refer to each other recursively.
+func compileProgram(ast *AST) (*program, error) {
+	c := &compiler{program: &program{}, globals: make(map[string]int)}
+	for _, d := range ast.Declarations {
+		if _, ok := c.globals[d.Name]; d.NodeType == Definition && !ok {
+			c.globals[d.Name] = len(c.program.globals)
+			c.program.globals = append(c.program.globals, d.Name)
+		}
+	}
+	for _, d := range ast.Declarations {
+		if d.NodeType != Definition { // types have no runtime meaning
+			continue
+		}
+		fn, err := c.compileFunction(d.Name, nil, nil, d.Children[0])
+		if err != nil {
+			return nil, err
+		}
+		c.program.definitions = append(c.program.definitions, definition{c.globals[d.Name], c.program.functions[fn]})
+	}
+	fn, err := c.compileFunction("main", nil, nil, ast.Child)
+	if err != nil {
+		return nil, err
+	}
+	c.program.main = c.program.functions[fn]
+	return c.program, nil
+}
+
+// compileFunction compiles a function whose body is evaluated when all parameters are given,
+// and returns its index in the functions of the program.
+func (c *compiler) compileFunction(name string, source *Node, params []string, body *Node) (int, error) {
+	fn := &function{name: name, source: source, arity: len(params)}
+	c.scope = &scope{fn: fn, parent: c.scope}
+	defer func() { c.scope = c.scope.parent }()
+	for _, p := range params {
+		c.bind(p)
+	}
+	if err := c.compile(body, true); err != nil {
+		return 0, err
+	}
+	c.emit(opReturn, 0)
+	c.program.functions = append(c.program.functions, fn)
+	return len(c.program.functions) - 1, nil
+}
+
+func (c *compiler) emit(op opcode, arg int) int {
+	fn := c.scope.fn
+	fn.code = append(fn.code, instruction{op, int32(arg)})
+	return len(fn.code) - 1
+}
+
+// patch makes a jump at i go to the next instruction.
+func (c *compiler) patch(i int) {
+	c.scope.fn.code[i].arg = int32(len(c.scope.fn.code))
+}
+
+// bind allocates a local variable and returns its slot.
+func (c *compiler) bind(name string) int {
+	fn := c.scope.fn
+	c.scope.locals = append(c.scope.locals, localVariable{name, fn.locals})
+	fn.locals++
+	return fn.locals - 1
+}
+
+// unbind removes the innermost n variables from the scope. their slots are not reused.
+func (c *compiler) unbind(n int) {
+	c.scope.locals = c.scope.locals[:len(c.scope.locals)-n]
+}
+
+// resolve returns an instruction which loads a variable bound in s or its enclosing functions.
+// a variable of an enclosing function is captured by every function between them.
+func (s *scope) resolve(name string) (opcode, int, bool) {
+	for i := len(s.locals) - 1; i >= 0; i-- {
+		if s.locals[i].name == name {
+			return opLoadLocal, s.locals[i].slot, true
+		}
+	}
+	for i, c := range s.fn.captures {
+		if c == name {
+			return opLoadCaptured, i, true
+		}
+	}
+	if s.parent == nil {
+		return 0, 0, false
+	}
+	if _, _, ok := s.parent.resolve(name); !ok {
+		return 0, 0, false
+	}
+	s.fn.captures = append(s.fn.captures, name)
+	return opLoadCaptured, len(s.fn.captures) - 1, true
+}
+
+func (c *compiler) constant(v vmValue) int {
+	c.program.constants = append(c.program.constants, v)
+	return len(c.program.constants) - 1
+}
+
+func (c *compiler) node(n *Node) int {
+	c.program.nodes = append(c.program.nodes, n)
+	return len(c.program.nodes) - 1
+}
+
+func (c *compiler) constructor(ctor constructor) int {
+	for i, d := range c.program.constructors {
+		if d == ctor {
+			return i
+		}
+	}
+	c.program.constructors = append(c.program.constructors, ctor)
+	return len(c.program.constructors) - 1
+}
+
+// compile emits instructions which push the value of n.
+// if n is in tail position, the function returns the value of n, so an application becomes a tail call.
+func (c *compiler) compile(n *Node, tail bool) error {
+	switch n.NodeType {
+	case True, False:
+		c.emit(opConst, c.constant(vmBoolOf(n.NodeType == True)))
+	case Zero:
+		c.emit(opConst, c.constant(vmValue{kind: vmNat}))
+	case NodeNumber:
+		i, err := strconv.ParseInt(n.Name, 10, 64)
+		if err != nil {
+			return err
+		}
+		c.emit(opConst, c.constant(vmValue{kind: vmInt, n: i}))
+	case StringLiteral:
+		c.emit(opConst, c.constant(vmValue{kind: vmString, ref: n.Name}))
+	case Nil:
+		c.emit(opConst, c.constant(vmValue{kind: vmNil}))
+	case IsZero, Succ, Pred, Cons, IsNil, Head, Tail, Concat, StrLen, StrEq, BinaryOperator:
+		p, ok := primitiveOf(n)
+		if !ok {
+			return fmt.Errorf("unknown operator %s", n.Name)
+		}
+		if len(n.Children) != 0 { // partially applied, such as cons 0
+			return c.compileCall(&Node{NodeType: n.NodeType, Name: n.Name}, n.Children, tail)
+		}
+		c.emit(opConst, c.constant(vmValue{kind: vmPartial, ref: &partialPrimitive{primitive: p}}))
+	case Variable, FreeVariable:
+		if op, i, ok := c.scope.resolve(n.Name); ok {
+			c.emit(op, i)
+		} else if g, ok := c.globals[n.Name]; ok {
+			c.emit(opLoadGlobal, g)
+		} else if n.NodeType == FreeVariable { // stuck like Eval
+			c.emit(opConst, c.constant(vmValue{kind: vmStuck, ref: n}))
+		} else {
+			return fmt.Errorf("unbound variable %s", n.Name)
+		}
+	case Lambda:
+		var params []string
+		for _, p := range n.Children[0].Children {
+			params = append(params, p.Name)
+		}
+		return c.compileClosure("lambda", n, params, n.Children[1].Children[0])
+	case TypeAbstraction:
+		return c.compileClosure("type abstraction", n, nil, n.Children[0])
+	case Apply:
+		var args []*Node
+		f := n
+		for ; f.NodeType == Apply; f = f.Children[0] {
+			args = append([]*Node{f.Children[1]}, args...)
+		}
+		return c.compileCall(f, args, tail)
+	case IF:
+		if err := c.compile(n.Children[0], false); err != nil {
+			return err
+		}
+		toElse := c.emit(opJumpIfFalse, 0)
+		if err := c.compile(n.Children[1], tail); err != nil {
+			return err
+		}
+		toEnd := c.emit(opJump, 0)
+		c.patch(toElse)
+		if err := c.compile(n.Children[2], tail); err != nil {
+			return err
+		}
+		c.patch(toEnd)
+	case TypeApplication:
+		if err := c.compile(n.Children[0], false); err != nil {
+			return err
+		}
+		c.emit(opInstantiate, c.node(n))
+	case Pack:
+		if err := c.compile(n.Children[1], false); err != nil {
+			return err
+		}
+		c.emit(opPack, c.node(n))
+	case Unpack:
+		if err := c.compile(n.Children[1], false); err != nil {
+			return err
+		}
+		c.emit(opUnpack, 0)
+		c.emit(opStoreLocal, c.bind(n.Children[0].Name))
+		defer c.unbind(1)
+		return c.compile(n.Children[2], tail)
+	case Tuple:
+		for _, e := range n.Children {
+			if err := c.compile(e, false); err != nil {
+				return err
+			}
+		}
+		c.emit(opTuple, len(n.Children))
+	case Variant:
+		if err := c.compile(n.Children[0], false); err != nil {
+			return err
+		}
+		c.emit(opVariant, c.node(n))
+	case Match:
+		return c.compileMatch(n, tail)
+	default:
+		return fmt.Errorf("cannot compile: %s", n.NodeType)
+	}
+	return nil
+}
+
+// compileClosure compiles a function, and emits instructions which make a closure of it.
+func (c *compiler) compileClosure(name string, source *Node, params []string, body *Node) error {
+	i, err := c.compileFunction(name, source, params, body)
+	if err != nil {
+		return err
+	}
+	for _, v := range c.program.functions[i].captures {
+		op, slot, _ := c.scope.resolve(v)
+		c.emit(op, slot)
+	}
+	c.emit(opClosure, i)
+	return nil
+}
+
+// compileCall compiles an application of f to args.
+// a built-in function which is given enough arguments is applied directly.
+func (c *compiler) compileCall(f *Node, args []*Node, tail bool) error {
+	if p, ok := primitiveOf(f); ok && len(f.Children) == 0 && len(args) >= primitives[p].arity {
+		arity := primitives[p].arity
+		for _, a := range args[:arity] {
+			if err := c.compile(a, false); err != nil {
+				return err
+			}
+		}
+		c.emit(opPrimitive, p)
+		args = args[arity:]
+		if len(args) == 0 {
+			return nil
+		}
+	} else if err := c.compile(f, false); err != nil {
+		return err
+	}
+	for _, a := range args {
+		if err := c.compile(a, false); err != nil {
+			return err
+		}
+	}
+	if tail {
+		c.emit(opTailCall, len(args))
+	} else {
+		c.emit(opCall, len(args))
+	}
+	return nil
+}
+
+// compileMatch compiles the decision tree of a match. the matched value is kept in a local variable,
+// and each test loads the part of it at the occurrence of the test.
+func (c *compiler) compileMatch(n *Node, tail bool) error {
+	if err := c.compile(n.Children[0], false); err != nil {
+		return err
+	}
+	slot := c.bind("") // no term refers to it
+	defer c.unbind(1)
+	c.emit(opStoreLocal, slot)
+	tree, _ := compileMatch(n, nil, nil)
+	var exits []int
+	if err := c.compileDecisionTree(n, tree, slot, tail, &exits); err != nil {
+		return err
+	}
+	for _, e := range exits {
+		c.patch(e)
+	}
+	return nil
+}
+
+func (c *compiler) compileDecisionTree(n *Node, tree *decisionTree, slot int, tail bool, exits *[]int) error {
+	if len(tree.cases) == 0 {
+		if tree.arm < 0 {
+			c.emit(opFail, slot)
+			return nil
+		}
+		for _, b := range tree.bindings {
+			c.loadPart(slot, b.occurrence)
+			c.emit(opStoreLocal, c.bind(b.name))
+		}
+		defer c.unbind(len(tree.bindings))
+		if err := c.compile(n.Children[tree.arm+1].Children[1], tail); err != nil {
+			return err
+		}
+		*exits = append(*exits, c.emit(opJump, 0))
+		return nil
+	}
+	for _, dc := range tree.cases {
+		c.loadPart(slot, tree.occurrence)
+		c.emit(opTest, c.constructor(dc.constructor))
+		next := c.emit(opJumpIfFalse, 0)
+		if err := c.compileDecisionTree(n, dc.tree, slot, tail, exits); err != nil {
+			return err
+		}
+		c.patch(next)
+	}
+	if tree.fallback == nil {
+		c.emit(opFail, slot)
+		return nil
+	}
+	return c.compileDecisionTree(n, tree.fallback, slot, tail, exits)
+}
+
+func (c *compiler) loadPart(slot int, occ occurrence) {
+	c.emit(opLoadLocal, slot)
+	for _, i := range occ {
+		c.emit(opField, i)
+	}
+}
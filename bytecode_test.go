@@ -0,0 +1,114 @@
+package gtl
+
+import "testing"
+
+func Test_compileProgram(t *testing.T) {
+	p, err := compileProgram(buildASTFromString("def k = .x -> .y -> x; k 0 true"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `lambda/1 [x]:
+   0 load_captured 0
+   1 return
+lambda/1:
+   0 load_local 0
+   1 closure 0
+   2 return
+k/0:
+   0 closure 1
+   1 return
+main/0:
+   0 load_global 0
+   1 const 0
+   2 const 1
+   3 tail_call 2
+   4 return
+`
+	if got := p.String(); got != want {
+		t.Errorf("want\n%v\nbut got\n%v", want, got)
+	}
+}
+
+func Test_compileProgram_primitive(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want []instruction
+	}{
+		{"succ 0", []instruction{{opConst, 0}, {opPrimitive, 1}, {opReturn, 0}}},
+		{"1 + 2", []instruction{{opConst, 0}, {opConst, 1}, {opPrimitive, 10}, {opReturn, 0}}},
+		{"(+) 1", []instruction{{opConst, 0}, {opConst, 1}, {opTailCall, 1}, {opReturn, 0}}},
+		{"head [.x -> x] 0", []instruction{{opClosure, 0}, {opConst, 0}, {opPrimitive, 3}, {opPrimitive, 5}, {opConst, 1}, {opTailCall, 1}, {opReturn, 0}}},
+	}
+	for i, v := range testcases {
+		p, err := compileProgram(buildASTFromString(v.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := p.main.code
+		if len(got) != len(v.want) {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+			continue
+		}
+		for j := range got {
+			if got[j] != v.want[j] {
+				t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+				break
+			}
+		}
+	}
+}
+
+func Test_compileProgram_match(t *testing.T) {
+	p, err := compileProgram(buildASTFromString("match {0, true} with | {succ n, _} -> n | {_, b} -> b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `main/0:
+   0 const 0
+   1 const 1
+   2 tuple 2
+   3 store_local 0
+   4 load_local 0
+   5 test 0
+   6 jump_if_false 22
+   7 load_local 0
+   8 field 0
+   9 test 1
+  10 jump_if_false 17
+  11 load_local 0
+  12 field 0
+  13 field 0
+  14 store_local 1
+  15 load_local 1
+  16 jump 23
+  17 load_local 0
+  18 field 1
+  19 store_local 2
+  20 load_local 2
+  21 jump 23
+  22 fail 0
+  23 return
+`
+	if got := p.String(); got != want {
+		t.Errorf("want\n%v\nbut got\n%v", want, got)
+	}
+}
+
+// a free variable is a constant of a stuck term
+func Test_compileProgram_freeVariable(t *testing.T) {
+	for i, src := range []string{"x", "(.y -> x y) 0"} {
+		p, err := compileProgram(buildASTFromString(src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		v, err := p.run()
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if v.kind != vmStuck {
+			t.Errorf("case %d: %v should be stuck", i, v.readback())
+		}
+	}
+}
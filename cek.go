@@ -0,0 +1,547 @@
+package gtl
+
+import (
+	"fmt"
+)
+
+// EvalCEK is an alternative to Eval, which is a CEK machine.
+// Unlike Eval, it does not substitute arguments into terms. A lambda is evaluated to a closure which captures
+// the environment where it is defined, and the machine keeps its continuation as an explicit stack of frames.
+// Top-level definitions are global, so they can refer to each other recursively.
+// Since the continuation is explicit, callcc captures it as a value, and abort discards it.
+// The result is read back into a term, which is the same as the result of Eval.
+func EvalCEK(ast *AST) (*Node, error) {
+	m := &machine{globals: make(map[string]value)}
+	for _, d := range ast.Declarations {
+		if d.NodeType != Definition { // types have no runtime meaning
+			continue
+		}
+		v, err := m.run(d.Children[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		m.globals[d.Name] = v
+	}
+	v, err := m.run(ast.Child, nil)
+	if err != nil {
+		return nil, err
+	}
+	return v.readback(), nil
+}
+
+// value is a runtime value of the machine.
+type value interface {
+	// readback returns a term of the value.
+	readback() *Node
+}
+
+// atom is a value which contains no closures, such as true, 0, "s" or built-in functions.
+// a stuck term such as an application of a free variable is also an atom.
+type atom struct {
+	node *Node
+}
+
+func (a atom) readback() *Node {
+	return a.node
+}
+
+// closure is a lambda or a type abstraction with the environment where it is defined.
+type closure struct {
+	term *Node
+	env  *machineEnv
+}
+
+func (c *closure) readback() *Node {
+	return c.env.substitute(c.term)
+}
+
+// data is a value built with a constructor, whose fields are values: a tuple, a variant, a package or a cons cell.
+// cons which is not applied to two arguments yet is also data.
+type data struct {
+	node   *Node // a term which has the constructor, and the types of a variant or a package
+	fields []value
+}
+
+func (d *data) readback() *Node {
+	fields := make([]*Node, len(d.fields))
+	for i, f := range d.fields {
+		fields[i] = f.readback()
+	}
+	switch d.node.NodeType {
+	case Variant:
+		return &Node{NodeType: Variant, Name: d.node.Name, Children: []*Node{fields[0], d.node.Children[1]}}
+	case Pack:
+		return &Node{NodeType: Pack, Children: []*Node{d.node.Children[0], fields[0], d.node.Children[2]}}
+	}
+	return &Node{NodeType: d.node.NodeType, Children: fields}
+}
+
+// continuationValue is a continuation captured by callcc, which is a copy of the frames above the base of run.
+type continuationValue struct {
+	frames []frame
+	base   int
+	depth  int
+}
+
+// readback returns .v -> abort E[v], where E is the evaluation context of the frames.
+func (k *continuationValue) readback() *Node {
+	name := "v"
+	for {
+		hole := &Node{NodeType: Variable, Name: name}
+		body := hole
+		for i := len(k.frames) - 1; i >= 0; i-- {
+			body = plugFrame(k.frames[i], body)
+		}
+		if !usesName(body, name, hole) {
+			abort := &Node{NodeType: Apply, Children: []*Node{&Node{NodeType: Abort}, body}}
+			return &Node{
+				NodeType: Lambda,
+				Children: []*Node{
+					&Node{NodeType: LambdaDef, Children: []*Node{&Node{NodeType: LambdaParam, Name: name}}},
+					&Node{NodeType: LambdaBody, Children: []*Node{abort}},
+				},
+			}
+		}
+		name += "'"
+	}
+}
+
+// plugFrame returns a term which evaluates t in the frame.
+func plugFrame(f frame, t *Node) *Node {
+	switch f := f.(type) {
+	case applyArgFrame:
+		return &Node{NodeType: Apply, Children: []*Node{t, f.env.substitute(f.arg)}}
+	case applyFrame:
+		return &Node{NodeType: Apply, Children: []*Node{f.fn.readback(), t}}
+	case ifFrame:
+		n := f.env.substitute(f.n)
+		return &Node{NodeType: IF, Children: []*Node{t, n.Children[1], n.Children[2]}}
+	case typeApplicationFrame:
+		return &Node{NodeType: TypeApplication, Children: []*Node{t, f.ty}}
+	case packFrame:
+		return &Node{NodeType: Pack, Children: []*Node{f.n.Children[0], t, f.n.Children[2]}}
+	case unpackFrame:
+		n := f.env.substitute(f.n)
+		return &Node{NodeType: Unpack, Name: n.Name, Children: []*Node{n.Children[0], t, n.Children[2]}}
+	case tupleFrame:
+		n := f.env.substitute(f.n)
+		elems := append([]*Node{}, n.Children...)
+		for i, v := range f.fields {
+			elems[i] = v.readback()
+		}
+		elems[len(f.fields)] = t
+		return &Node{NodeType: Tuple, Children: elems}
+	case variantFrame:
+		return &Node{NodeType: Variant, Name: f.n.Name, Children: []*Node{t, f.n.Children[1]}}
+	case matchFrame:
+		n := f.env.substitute(f.n)
+		return &Node{NodeType: Match, Children: append([]*Node{t}, n.Children[1:]...)}
+	}
+	panic(fmt.Sprintf("unknown frame %T", f))
+}
+
+// usesName reports whether n has a name other than the hole.
+func usesName(n *Node, name string, hole *Node) bool {
+	if n != hole && n.Name == name {
+		return true
+	}
+	for _, c := range n.Children {
+		if usesName(c, name, hole) {
+			return true
+		}
+	}
+	return false
+}
+
+// machineEnv is an immutable environment, which is shared by closures.
+type machineEnv struct {
+	name  string
+	value value
+	next  *machineEnv
+}
+
+func (e *machineEnv) extend(name string, v value) *machineEnv {
+	return &machineEnv{name: name, value: v, next: e}
+}
+
+func (e *machineEnv) lookup(name string) (value, bool) {
+	for ; e != nil; e = e.next {
+		if e.name == name {
+			return e.value, true
+		}
+	}
+	return nil, false
+}
+
+// substitute replaces variables in n which are bound in the environment with their values.
+func (e *machineEnv) substitute(n *Node) *Node {
+	seen := make(map[string]bool)
+	for ; e != nil; e = e.next {
+		if seen[e.name] {
+			continue
+		}
+		seen[e.name] = true
+		n = substTerm(n, e.name, e.value.readback())
+	}
+	return n
+}
+
+// frame is a part of the continuation, which receives a value.
+type frame interface{}
+
+type (
+	applyArgFrame struct { // evaluates the argument after the function
+		arg *Node
+		env *machineEnv
+	}
+	applyFrame struct { // applies a function to the value
+		fn value
+	}
+	ifFrame struct {
+		n   *Node
+		env *machineEnv
+	}
+	typeApplicationFrame struct {
+		ty *Node
+	}
+	packFrame struct {
+		n *Node
+	}
+	unpackFrame struct {
+		n   *Node
+		env *machineEnv
+	}
+	tupleFrame struct {
+		n      *Node
+		env    *machineEnv
+		fields []value
+	}
+	variantFrame struct {
+		n *Node
+	}
+	matchFrame struct {
+		n   *Node
+		env *machineEnv
+	}
+)
+
+type machine struct {
+	globals map[string]value
+	stack   []frame
+	base    int // the base of the innermost run, below which continuations cannot reach
+	depth   int // the number of nested runs
+}
+
+func (m *machine) push(f frame) {
+	m.stack = append(m.stack, f)
+}
+
+// run evaluates a term in an environment.
+// it is called recursively only for stuck if, whose branches are evaluated like Eval does.
+func (m *machine) run(n *Node, env *machineEnv) (value, error) {
+	base := len(m.stack)
+	defer func(outer int) { m.base, m.depth = outer, m.depth-1 }(m.base)
+	m.base, m.depth = base, m.depth+1
+	var v value
+	for {
+		if n != nil { // evaluates n
+			var err error
+			n, env, v, err = m.step(n, env)
+			if err != nil {
+				return nil, err
+			}
+			if n != nil {
+				continue
+			}
+		}
+		if len(m.stack) == base {
+			return v, nil
+		}
+		f := m.stack[len(m.stack)-1]
+		m.stack = m.stack[:len(m.stack)-1]
+		var err error
+		n, env, v, err = m.continueWith(f, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// step evaluates a term until it needs a value of a subterm.
+// it returns the next term to evaluate, or the value of the term if the next term is nil.
+func (m *machine) step(n *Node, env *machineEnv) (*Node, *machineEnv, value, error) {
+	switch n.NodeType {
+	case Lambda, TypeAbstraction:
+		return nil, nil, &closure{n, env}, nil
+	case Variable:
+		if v, ok := env.lookup(n.Name); ok {
+			return nil, nil, v, nil
+		}
+		if v, ok := m.globals[n.Name]; ok {
+			return nil, nil, v, nil
+		}
+		return nil, nil, atom{n}, nil
+	case Cons:
+		return nil, nil, &data{node: n}, nil
+	case True, False, Zero, FreeVariable, NodeNumber, IsZero, Succ, Pred, Nil, IsNil, Head, Tail, StringLiteral, Concat, StrLen, StrEq, BinaryOperator, CallCC, Abort:
+		return nil, nil, atom{n}, nil
+	case Apply:
+		m.push(applyArgFrame{n.Children[1], env})
+		return n.Children[0], env, nil, nil
+	case IF:
+		m.push(ifFrame{n, env})
+		return n.Children[0], env, nil, nil
+	case TypeApplication:
+		m.push(typeApplicationFrame{n.Children[1]})
+		return n.Children[0], env, nil, nil
+	case Pack:
+		m.push(packFrame{n})
+		return n.Children[1], env, nil, nil
+	case Unpack:
+		m.push(unpackFrame{n, env})
+		return n.Children[1], env, nil, nil
+	case Tuple:
+		if len(n.Children) == 0 { // {} is a value
+			return nil, nil, &data{node: n}, nil
+		}
+		m.push(tupleFrame{n: n, env: env})
+		return n.Children[0], env, nil, nil
+	case Variant:
+		m.push(variantFrame{n})
+		return n.Children[0], env, nil, nil
+	case Match:
+		m.push(matchFrame{n, env})
+		return n.Children[0], env, nil, nil
+	}
+	return nil, nil, nil, fmt.Errorf("cannot eval: %s", n.NodeType)
+}
+
+// continueWith gives a value to a frame.
+func (m *machine) continueWith(f frame, v value) (*Node, *machineEnv, value, error) {
+	switch f := f.(type) {
+	case applyArgFrame:
+		m.push(applyFrame{v})
+		return f.arg, f.env, nil, nil
+	case applyFrame:
+		return m.apply(f.fn, v)
+	case ifFrame:
+		if a, ok := v.(atom); ok && a.node.NodeType == True {
+			return f.n.Children[1], f.env, nil, nil
+		}
+		if a, ok := v.(atom); ok && a.node.NodeType == False {
+			return f.n.Children[2], f.env, nil, nil
+		}
+		truePart, err := m.run(f.n.Children[1], f.env)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		falsePart, err := m.run(f.n.Children[2], f.env)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return nil, nil, atom{&Node{NodeType: IF, Children: []*Node{v.readback(), truePart.readback(), falsePart.readback()}}}, nil
+	case typeApplicationFrame:
+		switch l := v.(type) {
+		case atom:
+			if isListPrimitive(l.node) || isControlPrimitive(l.node) { // nil[T] is nil at runtime
+				return nil, nil, v, nil
+			}
+		case *data:
+			if l.node.NodeType == Cons && len(l.fields) == 0 {
+				return nil, nil, v, nil
+			}
+		case *closure:
+			if l.term.NodeType == TypeAbstraction {
+				return substType(l.term.Children[0], l.term.Name, f.ty), l.env, nil, nil
+			}
+		}
+		return nil, nil, atom{&Node{NodeType: TypeApplication, Children: []*Node{v.readback(), f.ty}}}, nil
+	case packFrame:
+		return nil, nil, &data{node: f.n, fields: []value{v}}, nil
+	case unpackFrame:
+		param := f.n.Children[0]
+		if d, ok := v.(*data); ok && d.node.NodeType == Pack {
+			body := substType(f.n.Children[2], f.n.Name, d.node.Children[0])
+			return body, f.env.extend(param.Name, d.fields[0]), nil, nil
+		}
+		stuck := &Node{NodeType: Unpack, Name: f.n.Name, Children: []*Node{param, v.readback(), f.n.Children[2]}}
+		return nil, nil, atom{f.env.substitute(stuck)}, nil
+	case tupleFrame:
+		fields := append(append([]value{}, f.fields...), v)
+		if len(fields) == len(f.n.Children) {
+			return nil, nil, &data{node: f.n, fields: fields}, nil
+		}
+		m.push(tupleFrame{f.n, f.env, fields})
+		return f.n.Children[len(fields)], f.env, nil, nil
+	case variantFrame:
+		return nil, nil, &data{node: f.n, fields: []value{v}}, nil
+	case matchFrame:
+		return m.match(f.n, f.env, v)
+	}
+	panic(fmt.Sprintf("unknown frame %T", f))
+}
+
+// apply applies a function to an argument like evalApply.
+func (m *machine) apply(fn, arg value) (*Node, *machineEnv, value, error) {
+	switch l := fn.(type) {
+	case *closure:
+		if l.term.NodeType != Lambda {
+			break
+		}
+		def := l.term.Children[0]
+		body := l.term.Children[1].Children[0]
+		env := l.env.extend(def.Children[0].Name, arg)
+		if len(def.Children) == 1 {
+			return body, env, nil, nil
+		}
+		rest := &Node{
+			NodeType: Lambda,
+			Children: []*Node{
+				&Node{NodeType: LambdaDef, Children: def.Children[1:]},
+				l.term.Children[1],
+			},
+		}
+		return nil, nil, &closure{rest, env}, nil
+	case *continuationValue:
+		if l.base != m.base || l.depth != m.depth {
+			return nil, nil, nil, fmt.Errorf("cannot invoke a continuation out of the stuck term where it is captured")
+		}
+		m.stack = append(m.stack[:m.base], l.frames...)
+		return nil, nil, arg, nil
+	case atom:
+		switch l.node.NodeType {
+		case CallCC:
+			k := &continuationValue{frames: append([]frame{}, m.stack[m.base:]...), base: m.base, depth: m.depth}
+			return m.apply(arg, k)
+		case Abort:
+			m.stack = m.stack[:m.base]
+			return nil, nil, arg, nil
+		}
+	}
+	v, err := applyBuiltin(fn, arg)
+	return nil, nil, v, err
+}
+
+// applyBuiltin applies a value which is not a closure, such as a built-in function or cons.
+// if the value cannot be applied, the application is stuck.
+func applyBuiltin(fn, arg value) (value, error) {
+	switch l := fn.(type) {
+	case *data:
+		if l.node.NodeType == Cons && len(l.fields) < 2 {
+			return &data{node: l.node, fields: append(append([]value{}, l.fields...), arg)}, nil
+		}
+	case atom:
+		if !l.node.IsApplyable() {
+			break
+		}
+		if d, ok := arg.(*data); ok && d.node.NodeType == Cons && len(d.fields) == 2 {
+			switch l.node.NodeType {
+			case IsNil:
+				return atom{&Node{NodeType: False}}, nil
+			case Head:
+				return d.fields[0], nil
+			case Tail:
+				return d.fields[1], nil
+			}
+		}
+		ret, err := applyPrimitive(l.node, arg.readback())
+		if err != nil {
+			return nil, err
+		}
+		return atom{ret}, nil
+	}
+	return atom{&Node{NodeType: Apply, Children: []*Node{fn.readback(), arg.readback()}}}, nil
+}
+
+// match follows the decision tree of a match like evalMatch, and evaluates the body of the selected case
+// in the environment where its pattern variables are bound.
+func (m *machine) match(n *Node, env *machineEnv, v value) (*Node, *machineEnv, value, error) {
+	tree, _ := compileMatch(n, nil, nil)
+	leaf, ok, err := selectCase(tree, v)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !ok {
+		stuck := &Node{NodeType: Match, Children: append([]*Node{v.readback()}, n.Children[1:]...)}
+		return nil, nil, atom{env.substitute(stuck)}, nil
+	}
+	for _, b := range leaf.bindings {
+		env = env.extend(b.name, partOf(v, b.occurrence))
+	}
+	return n.Children[leaf.arm+1].Children[1], env, nil, nil
+}
+
+// selectCase follows a decision tree for a value, and returns the leaf of the selected case.
+// ok is false if the value is stuck, such as a free variable.
+func selectCase(tree *decisionTree, v value) (leaf *decisionTree, ok bool, err error) {
+	return selectCaseBy(tree, v, func(o occurrence) (value, error) {
+		return partOf(v, o), nil
+	})
+}
+
+// selectCaseBy is selectCase whose parts of the value are given by partAt, which may evaluate them.
+func selectCaseBy(tree *decisionTree, v value, partAt func(occurrence) (value, error)) (leaf *decisionTree, ok bool, err error) {
+	for len(tree.cases) != 0 {
+		part, err := partAt(tree.occurrence)
+		if err != nil {
+			return nil, false, err
+		}
+		if !isConstructorPart(part) {
+			return nil, false, nil
+		}
+		next := tree.fallback
+		for _, c := range tree.cases {
+			if constructorMatches(c.constructor, part) {
+				next = c.tree
+				break
+			}
+		}
+		if next == nil {
+			return nil, false, fmt.Errorf("no case matches %s", v.readback())
+		}
+		tree = next
+	}
+	if tree.arm < 0 {
+		return nil, false, fmt.Errorf("no case matches %s", v.readback())
+	}
+	return tree, true, nil
+}
+
+// partOf is valueAt for values of the machine.
+func partOf(v value, o occurrence) value {
+	for _, i := range o {
+		switch p := v.(type) {
+		case atom:
+			v = atom{p.node.Children[i]}
+		case *data:
+			v = p.fields[i]
+		}
+	}
+	return v
+}
+
+func isConstructorPart(v value) bool {
+	switch p := v.(type) {
+	case atom:
+		return isConstructorValue(p.node)
+	case *data:
+		return p.node.NodeType != Pack && (p.node.NodeType != Cons || len(p.fields) == 2)
+	}
+	return false
+}
+
+func constructorMatches(c constructor, v value) bool {
+	switch p := v.(type) {
+	case atom:
+		return c.matches(p.node)
+	case *data:
+		switch c.nodeType {
+		case Tuple:
+			return p.node.NodeType == Tuple && len(p.fields) == c.arity
+		case Variant:
+			return p.node.NodeType == Variant && p.node.Name == c.name
+		}
+		return p.node.NodeType == c.nodeType
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package gtl
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// evalSources are programs which both Eval and EvalCEK should evaluate to the same result.
+var evalSources = []string{
+	"true",
+	"if iszero (succ 0) then 0 else succ 0",
+	"(.a -> a) true",
+	"a true",
+	"(.a .b -> a b) iszero",
+	"(.a -> .b -> a) 0 true",
+	"(.f -> f true (f false true)) (.a .b -> a)",
+	"(.x .y .z -> {x, y, z}) 0 true",
+	"(.x .y -> .z -> {x, y, z}) 0 true false",
+	"(.x .x -> x) 0 true",
+	"(.x .y -> x) y 0",
+	"if x then 0 else (.y -> y) 0",
+	"pred (succ (succ 0))",
+	"succ x",
+	"succ 1",
+	"true 0",
+	"1 + x",
+	"iszero (1 - 1)",
+	"(.f -> f [Nat] 0) g",
+	`(\X -> .x:X -> x) [Bool] true`,
+	"f [Nat]",
+	"let {X, x} = {*Nat, succ 0} as {Some X, X} in iszero x",
+	"let {X, x} = p in x",
+	"(.y -> let {X, x} = p in y) 0",
+	"(.y -> {*Nat, y} as {Some X, X}) 0",
+	"[0, succ 0]",
+	"cons 0",
+	"(.f -> f nil) (cons 0)",
+	"head (tail [0, succ 0])",
+	"isnil [.x -> x]",
+	"head [.x -> x]",
+	"head nil",
+	"tail (tail [true])",
+	`concat "foo" "bar"`,
+	`strlen "abc"`,
+	`streq "a" "a"`,
+	"1 + 2 * 3",
+	"(+) 1",
+	"x + 1",
+	"def fact = .n -> if n < 2 then 1 else n * fact (n - 1); fact 20",
+	"def twice = .f .x -> f (f x); twice (twice succ) 0",
+	"def k = .x .y -> x; def k0 = k 0; {k0 true, k0 false}",
+	"infixr 5 ++; def (++) = .l .m -> if isnil l then m else cons (head l) (tail l ++ m); [0] ++ [1] ++ [2]",
+	"match {true, {0, 1}} with | {false, _} -> 0 | {true, {x, y}} -> y",
+	"match <b = 3> as <a: Nat, b: Int> with | <a = _> -> 0 | <b = n> -> n * 2",
+	"def sum = .l -> match l with | [] -> 0 | cons x rest -> x + sum rest; sum [1, 2, 3]",
+	"(.y -> match x with | 0 -> y | z -> z) 0",
+	"match [.x -> x] with | [f] -> f 0",
+	"{.x -> x, <a = (.y -> y)> as <a: Top>}",
+	"(.x -> .y -> {x, y}) (.z -> z)",
+	"(.x -> .y -> if y then x else y) 0",
+	"(.x .y -> x y) iszero",
+	"(.x -> .y -> x) y",
+	"if x then 0 else succ 0",
+	"{}",
+	"(.x -> {x, {}}) {}",
+	"(.f -> {f true, f x}) (.b -> if b then 1 + 1 else head nil)",
+	"def g = .n -> {n}; def f = .b -> {if b then g 0 else g 1, if b then 0 else g 1}; {f x, f true}",
+}
+
+func TestEvalCEK(t *testing.T) {
+	for i, src := range evalSources {
+		want, err := Eval(buildASTFromString(src))
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		got, err := EvalCEK(buildASTFromString(src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+	}
+}
+
+func TestEvalCEK_prelude(t *testing.T) {
+	for i, src := range []string{
+		"times (succ (succ 0)) (succ (succ (succ 0)))",
+		"equal (succ 0) (succ 0)",
+		"map (plus (succ 0)) [0, succ 0]",
+		"foldr plus 0 [succ 0, succ 0]",
+		"length (append [0] [0, 0])",
+		"compose not not",
+	} {
+		ast := buildASTWithPrelude(Prelude, src)
+		want, err := Eval(ast)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		got, err := EvalCEK(ast)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+	}
+}
+
+func TestEvalCEK_files(t *testing.T) {
+	files, err := filepath.Glob("sample/*.tl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := Eval(buildASTFromString(string(b)))
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		got, err := EvalCEK(buildASTFromString(string(b)))
+		if err != nil {
+			t.Errorf("%s: %v", f, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("%s: want %v but got %v\n", f, want, got)
+		}
+	}
+}
+
+// a closure keeps the environment where it is created, even if the same name is defined later.
+func TestEvalCEK_closure(t *testing.T) {
+	ast := buildASTFromString("def mk = .x -> .y -> x; def f = mk 0; def x = true; f x")
+	n, err := EvalCEK(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := Zero, n.NodeType; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+}
+
+func TestEvalCEK_error(t *testing.T) {
+	for i, src := range []string{"1 / (1 - 1)", "match succ 0 with | 0 -> 0"} {
+		if _, err := EvalCEK(buildASTFromString(src)); err == nil {
+			t.Errorf("case %d: %s should be an error", i, src)
+		}
+	}
+}
+
+// controlSources use callcc and abort, which need an evaluation context.
+var controlSources = []struct {
+	src  string
+	want string
+}{
+	{"callcc (.k -> 0)", "0"},
+	{"succ (callcc (.k -> succ (k 0)))", "succ (0)"},
+	{"{0, callcc (.k -> {k true, 1})}", "{0, true}"},
+	{"1 + abort 2", "2"},
+	{"callcc [Nat] (.k:(Nat -> Bot) -> if iszero 0 then k 0 else succ 0)", "0"},
+	{"def each = .f .l -> if isnil l then 0 else (.u -> each f (tail l)) (f (head l)); def find = .p .l -> callcc (.k -> each (.x -> if p x then k x else 0) l); find (.x -> 2 < x) [1, 5, 7]", "5"},
+	{"def r = callcc (.k -> 0) + 1; r + abort 2", "2"},
+	{"{0, callcc (.k -> k)}", "{0, .v -> (abort {0, v})}"},
+}
+
+func TestEvalCEK_control(t *testing.T) {
+	for i, v := range controlSources {
+		ast := buildASTWithPrelude(Prelude, v.src)
+		got, err := EvalCEK(ast)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got.String() != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+	if _, err := Eval(buildASTFromString("callcc (.k -> 0)")); err == nil {
+		t.Errorf("Eval should reject callcc")
+	}
+	if _, err := EvalCEK(buildASTFromString("callcc (.k -> if x then k 0 else 1)")); err == nil {
+		t.Errorf("a continuation should not be invoked in a stuck if")
+	}
+}
@@ -0,0 +1,70 @@
+package gtl
+
+import (
+	"fmt"
+)
+
+// ChurchPrelude is the prelude for pure lambda calculus in TaPL chapter 5.
+// Booleans and numbers are Church encodings, and realbool and realnat convert them into primitive ones to display.
+const ChurchPrelude = `
+def id = .x -> x;
+def compose = .f .g .x -> f (g x);
+
+def tru = .t .f -> t;
+def fls = .t .f -> f;
+def test = .l .m .n -> l m n;
+def and = .b .c -> b c fls;
+def or = .b .c -> b tru c;
+def not = .b -> b fls tru;
+
+def pair = .f .s .b -> b f s;
+def fst = .p -> p tru;
+def snd = .p -> p fls;
+
+def c0 = .s .z -> z;
+def scc = .n .s .z -> s (n s z);
+def c1 = scc c0;
+def c2 = scc c1;
+def c3 = scc c2;
+def plus = .m .n .s .z -> m s (n s z);
+def times = .m .n -> m (plus n) c0;
+def iszro = .m -> m (.x -> fls) tru;
+def prd = .m -> fst (m (.p -> pair (snd p) (plus c1 (snd p))) (pair c0 c0));
+def equal = .m .n -> and (iszro (m prd n)) (iszro (n prd m));
+
+def realbool = .b -> b true false;
+def realnat = .m -> m (.x -> succ x) 0;
+`
+
+// checkPureProgram returns an error if a program uses primitives which pure lambda calculus does not have.
+func checkPureProgram(decls []*Node, main *Node) error {
+	for _, d := range decls {
+		if d.NodeType != Definition {
+			continue
+		}
+		if err := checkPure(d.Children[0]); err != nil {
+			return fmt.Errorf("def %s: %v", d.Name, err)
+		}
+	}
+	if main == nil {
+		return nil
+	}
+	return checkPure(main)
+}
+
+func checkPure(n *Node) error {
+	switch n.NodeType {
+	case IF:
+		return fmt.Errorf("if is not allowed in pure lambda calculus")
+	case Match:
+		return fmt.Errorf("match is not allowed in pure lambda calculus")
+	case True, False, Zero, NodeNumber, Succ, Pred, IsZero, BinaryOperator, Tuple, Variant:
+		return fmt.Errorf("%s is not allowed in pure lambda calculus", n)
+	}
+	for _, c := range n.Children {
+		if err := checkPure(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package gtl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChurchPrelude(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"realbool tru", "true"},
+		{"realbool (test fls tru fls)", "false"},
+		{"realbool (and tru (not fls))", "true"},
+		{"realbool (or fls fls)", "false"},
+		{"realnat (fst (pair c1 c2))", "succ (0)"},
+		{"realnat (snd (pair c1 c2))", "succ (succ (0))"},
+		{"realnat c0", "0"},
+		{"realnat (plus c2 c1)", "succ (succ (succ (0)))"},
+		{"realnat (times c2 c3)", "succ (succ (succ (succ (succ (succ (0))))))"},
+		{"realnat (prd c3)", "succ (succ (0))"},
+		{"realnat (prd c0)", "0"},
+		{"realbool (iszro c0)", "true"},
+		{"realbool (iszro c1)", "false"},
+		{"realbool (equal (plus c1 c2) c3)", "true"},
+		{"realbool (equal c2 c3)", "false"},
+	}
+	for i, v := range testcases {
+		if got := evalWithPrelude(ChurchPrelude, v.src).String(); got != v.want {
+			t.Errorf("case %d: %s: want %v but got %v\n", i, v.src, v.want, got)
+		}
+	}
+}
+
+func Test_checkPureProgram(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string // empty if the program is pure
+	}{
+		{"(.x -> x) (.y -> y)", ""},
+		{"def tru = .t .f -> t; tru", ""},
+		{"true", "true is not allowed"},
+		{"(.x -> false) tru", "false is not allowed"},
+		{"if tru then c0 else c1", "if is not allowed"},
+		{"realnat 0", "0 is not allowed"},
+		{"def one = succ c0; one", "def one: succ is not allowed"},
+		{"iszero c0", "iszero is not allowed"},
+	}
+	for i, v := range testcases {
+		decls, main, err := parseSource("test", v.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = checkPureProgram(decls, main)
+		if v.want == "" {
+			if err != nil {
+				t.Errorf("case %d: %s: unexpected error %v", i, v.src, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), v.want) {
+			t.Errorf("case %d: %s: want an error containing %q but got %v", i, v.src, v.want, err)
+		}
+	}
+}
+
+func TestLoadFileWith_pure(t *testing.T) {
+	opts := LoadOptions{Prelude: ChurchPrelude, Pure: true}
+	ast, err := LoadFileWith("testdata/module/church.tl", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := Eval(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "succ (succ (succ (0)))", n.String(); got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+
+	if _, err := LoadFileWith("testdata/module/impure.tl", opts); err == nil {
+		t.Error("primitives should be rejected")
+	}
+}
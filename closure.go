@@ -0,0 +1,276 @@
+package gtl
+
+import (
+	"fmt"
+)
+
+// ConvertClosures makes closures explicit.
+// A lambda becomes a closure record {code, env}, where env is a tuple of the variables which the lambda
+// captures from enclosing lambdas, and code is a lambda `.env .x -> match env with | {y, z} -> t`, which
+// has no free variables other than definitions. An application `f a` unpacks the record and calls the code
+// with its environment. A lambda with several parameters is a lambda which returns a lambda, and a type
+// abstraction is a lambda which ignores its argument. Type annotations are dropped.
+// Then LiftLambdas moves every code to a definition, so the program is first-order.
+func ConvertClosures(ast *AST) (*AST, error) {
+	names := newNameSupply(ast)
+	c := &closureConverter{names: names, code: names.fresh("code"), env: names.fresh("env")}
+	ret := &AST{}
+	for _, d := range ast.Declarations {
+		if d.NodeType != Definition {
+			ret.Declarations = append(ret.Declarations, d)
+			continue
+		}
+		t, err := c.convert(d.Children[0], nil)
+		if err != nil {
+			return nil, fmt.Errorf("def %s: %v", d.Name, err)
+		}
+		ret.Declarations = append(ret.Declarations, &Node{NodeType: Definition, Name: d.Name, Children: []*Node{t}})
+	}
+	t, err := c.convert(ast.Child, nil)
+	if err != nil {
+		return nil, err
+	}
+	ret.Child = t
+	return ret, nil
+}
+
+type closureConverter struct {
+	names     nameSupply
+	code, env string // variables for the parts of a closure record
+}
+
+// convert returns the converted term of n. locals are variables bound in n's context except definitions.
+func (c *closureConverter) convert(n *Node, locals []string) (*Node, error) {
+	switch n.NodeType {
+	case True, False, Zero, NodeNumber, StringLiteral, Nil, Variable, FreeVariable:
+		return n, nil
+	case IsZero, Succ, Pred, Cons, IsNil, Head, Tail, Concat, StrLen, StrEq, BinaryOperator:
+		return c.convertApply(&Node{NodeType: n.NodeType, Name: n.Name}, n.Children, locals)
+	case Lambda:
+		var params []string
+		for _, p := range n.Children[0].Children {
+			params = append(params, p.Name)
+		}
+		return c.closure(params, n.Children[1].Children[0], locals)
+	case TypeAbstraction:
+		return c.closure([]string{c.names.fresh("_t")}, n.Children[0], locals)
+	case TypeApplication:
+		if isListPrimitive(n.Children[0]) { // nil[T] is nil at runtime
+			return n.Children[0], nil
+		}
+		f, err := c.convert(n.Children[0], locals)
+		if err != nil {
+			return nil, err
+		}
+		return c.call(f, &Node{NodeType: Tuple}), nil
+	case Apply:
+		var args []*Node
+		f := n
+		for ; f.NodeType == Apply; f = f.Children[0] {
+			args = append([]*Node{f.Children[1]}, args...)
+		}
+		return c.convertApply(f, args, locals)
+	case IF, Tuple:
+		ret := &Node{NodeType: n.NodeType, Children: make([]*Node, len(n.Children))}
+		for i, ch := range n.Children {
+			t, err := c.convert(ch, locals)
+			if err != nil {
+				return nil, err
+			}
+			ret.Children[i] = t
+		}
+		return ret, nil
+	case Pack:
+		t, err := c.convert(n.Children[1], locals)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{NodeType: Pack, Children: []*Node{n.Children[0], t, n.Children[2]}}, nil
+	case Variant:
+		t, err := c.convert(n.Children[0], locals)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{NodeType: Variant, Name: n.Name, Children: []*Node{t, n.Children[1]}}, nil
+	case Unpack:
+		bound, err := c.convert(n.Children[1], locals)
+		if err != nil {
+			return nil, err
+		}
+		body, err := c.convert(n.Children[2], append(locals, n.Children[0].Name))
+		if err != nil {
+			return nil, err
+		}
+		return &Node{NodeType: Unpack, Name: n.Name, Children: []*Node{n.Children[0], bound, body}}, nil
+	case Match:
+		t, err := c.convert(n.Children[0], locals)
+		if err != nil {
+			return nil, err
+		}
+		ret := &Node{NodeType: Match, Children: []*Node{t}}
+		for _, mc := range n.Children[1:] {
+			vars, err := patternVariables(mc.Children[0])
+			if err != nil {
+				return nil, err
+			}
+			body, err := c.convert(mc.Children[1], append(locals, vars...))
+			if err != nil {
+				return nil, err
+			}
+			ret.Children = append(ret.Children, &Node{NodeType: MatchCase, Children: []*Node{mc.Children[0], body}})
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("%s is not supported by closure conversion", n)
+}
+
+// closure returns a closure record of a lambda.
+func (c *closureConverter) closure(params []string, body *Node, locals []string) (*Node, error) {
+	if len(params) > 1 {
+		body = untypedLambda(params[1:], body)
+	}
+	var captured []string
+	for _, v := range freeVariables(untypedLambda(params[:1], body)) {
+		if containsString(locals, v) {
+			captured = append(captured, v)
+		}
+	}
+	t, err := c.convert(body, append(captured, params[0]))
+	if err != nil {
+		return nil, err
+	}
+	env := &Node{NodeType: Tuple}
+	if len(captured) != 0 {
+		pattern := &Node{NodeType: Tuple}
+		for _, v := range captured {
+			env.Children = append(env.Children, &Node{NodeType: Variable, Name: v})
+			pattern.Children = append(pattern.Children, &Node{NodeType: PatternVariable, Name: v})
+		}
+		t = &Node{NodeType: Match, Children: []*Node{
+			&Node{NodeType: Variable, Name: c.env},
+			&Node{NodeType: MatchCase, Children: []*Node{pattern, t}},
+		}}
+	}
+	return &Node{NodeType: Tuple, Children: []*Node{untypedLambda([]string{c.env, params[0]}, t), env}}, nil
+}
+
+// call returns an application of a closure record f to a.
+func (c *closureConverter) call(f, a *Node) *Node {
+	pattern := &Node{NodeType: Tuple, Children: []*Node{
+		&Node{NodeType: PatternVariable, Name: c.code},
+		&Node{NodeType: PatternVariable, Name: c.env},
+	}}
+	body := applyTerms(&Node{NodeType: Variable, Name: c.code}, &Node{NodeType: Variable, Name: c.env}, a)
+	return &Node{NodeType: Match, Children: []*Node{f, &Node{NodeType: MatchCase, Children: []*Node{pattern, body}}}}
+}
+
+// convertApply converts an application of f to args. a built-in function which is given all arguments
+// is applied directly, and otherwise it is eta-expanded into a closure.
+func (c *closureConverter) convertApply(f *Node, args []*Node, locals []string) (*Node, error) {
+	var err error
+	converted := make([]*Node, len(args))
+	for i, a := range args {
+		if converted[i], err = c.convert(a, locals); err != nil {
+			return nil, err
+		}
+	}
+	if p, ok := primitiveOf(f); ok {
+		arity := primitives[p].arity
+		if len(args) >= arity {
+			f, converted = applyTerms(f, converted[:arity]...), converted[arity:]
+		} else {
+			var params []string
+			var vars []*Node
+			for i := 0; i < arity; i++ {
+				params = append(params, c.names.fresh("x"))
+				vars = append(vars, &Node{NodeType: Variable, Name: params[i]})
+			}
+			if f, err = c.closure(params, applyTerms(f, vars...), nil); err != nil {
+				return nil, err
+			}
+		}
+	} else if f, err = c.convert(f, locals); err != nil {
+		return nil, err
+	}
+	for _, a := range converted {
+		f = c.call(f, a)
+	}
+	return f, nil
+}
+
+// LiftLambdas moves lambdas in terms to new definitions, so every lambda is the body of a definition.
+// A lambda which has free variables other than definitions takes them as extra parameters first,
+// and it is replaced with a partial application of the new definition.
+// So LiftLambdas after ConvertClosures makes a first-order program, whose lambdas are top-level codes.
+func LiftLambdas(ast *AST) (*AST, error) {
+	l := &lambdaLifter{names: newNameSupply(ast)}
+	ret := &AST{}
+	for _, d := range ast.Declarations {
+		if d.NodeType != Definition {
+			ret.Declarations = append(ret.Declarations, d)
+			continue
+		}
+		t := d.Children[0]
+		if t.NodeType == Lambda { // a definition of a function is already top-level
+			var params []string
+			for _, p := range t.Children[0].Children {
+				params = append(params, p.Name)
+			}
+			t = untypedLambda(params, l.lift(t.Children[1].Children[0], params))
+		} else {
+			t = l.lift(t, nil)
+		}
+		ret.Declarations = append(ret.Declarations, l.lifted...)
+		ret.Declarations = append(ret.Declarations, &Node{NodeType: Definition, Name: d.Name, Children: []*Node{t}})
+		l.lifted = nil
+	}
+	ret.Child = l.lift(ast.Child, nil)
+	ret.Declarations = append(ret.Declarations, l.lifted...)
+	return ret, nil
+}
+
+type lambdaLifter struct {
+	names  nameSupply
+	lifted []*Node // definitions of lambdas, which are added before the definition where they are found
+}
+
+// lift returns n whose lambdas are replaced with new definitions. locals are variables bound in n's context.
+func (l *lambdaLifter) lift(n *Node, locals []string) *Node {
+	switch n.NodeType {
+	case Lambda:
+		var params []string
+		for _, p := range n.Children[0].Children {
+			params = append(params, p.Name)
+		}
+		var captured []string
+		for _, v := range freeVariables(n) {
+			if containsString(locals, v) {
+				captured = append(captured, v)
+			}
+		}
+		body := l.lift(n.Children[1].Children[0], append(append([]string{}, captured...), params...))
+		name := l.names.fresh("lambda")
+		def := untypedLambda(append(append([]string{}, captured...), params...), body)
+		l.lifted = append(l.lifted, &Node{NodeType: Definition, Name: name, Children: []*Node{def}})
+		ret := &Node{NodeType: Variable, Name: name}
+		for _, v := range captured {
+			ret = applyTerms(ret, &Node{NodeType: Variable, Name: v})
+		}
+		return ret
+	case Unpack:
+		bound := l.lift(n.Children[1], locals)
+		body := l.lift(n.Children[2], append(locals, n.Children[0].Name))
+		return &Node{NodeType: Unpack, Name: n.Name, Children: []*Node{n.Children[0], bound, body}}
+	case MatchCase:
+		vars, _ := patternVariables(n.Children[0])
+		return &Node{NodeType: MatchCase, Children: []*Node{n.Children[0], l.lift(n.Children[1], append(locals, vars...))}}
+	}
+	if len(n.Children) == 0 {
+		return n
+	}
+	ret := &Node{NodeType: n.NodeType, Name: n.Name, Children: make([]*Node, len(n.Children))}
+	for i, c := range n.Children {
+		ret.Children[i] = l.lift(c, locals)
+	}
+	return ret
+}
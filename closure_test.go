@@ -0,0 +1,140 @@
+package gtl
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// checkFirstOrder returns an error if a lambda is not the body of a definition, or it has free variables
+// other than definitions and unbound variables of the program.
+func checkFirstOrder(ast *AST, unbound []string) error {
+	globals := unbound
+	for _, d := range ast.Declarations {
+		if d.NodeType == Definition {
+			globals = append(globals, d.Name)
+		}
+	}
+	terms := []*Node{ast.Child}
+	for _, d := range ast.Declarations {
+		if d.NodeType != Definition {
+			continue
+		}
+		t := d.Children[0]
+		if t.NodeType == Lambda {
+			for _, v := range freeVariables(t) {
+				if !containsString(globals, v) {
+					return fmt.Errorf("def %s has a free variable %s", d.Name, v)
+				}
+			}
+			t = t.Children[1].Children[0]
+		}
+		terms = append(terms, t)
+	}
+	for _, t := range terms {
+		if containsLambda(t) {
+			return fmt.Errorf("%s has a lambda", t)
+		}
+	}
+	return nil
+}
+
+func containsLambda(n *Node) bool {
+	if n.NodeType == Lambda {
+		return true
+	}
+	for _, c := range n.Children {
+		if containsLambda(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConvertClosures(t *testing.T) {
+	passes := []struct {
+		name string
+		pass func(*AST) (*AST, error)
+	}{
+		{"ConvertClosures", ConvertClosures},
+		{"LiftLambdas", LiftLambdas},
+		{"ConvertClosures and LiftLambdas", func(ast *AST) (*AST, error) {
+			ast, err := ConvertClosures(ast)
+			if err != nil {
+				return nil, err
+			}
+			return LiftLambdas(ast)
+		}},
+	}
+	sources := append([]string{
+		"def compose = .f .g .x -> f (g x); compose (.x -> {x, x}) (.y -> y + 1) 2",
+		"def add = .x -> .y -> x + y; def inc = add 1; {inc 1, add 2 3}",
+		"(.x -> match {x, 1} with | {a, b} -> (.c -> a + b + c) 10) 100",
+		"(.x -> let {X, y} = {*Nat, x} as {Some X, X} in (.z -> {y, z}) 0) 1",
+		"def map = .f .l -> if isnil l then nil else cons (f (head l)) (map f (tail l)); (.n -> map (.x -> x * n) [1, 2, 3]) 10",
+		`(\X -> .x:X -> .y:X -> x) [Nat] 0 1`,
+		"map (+) [1, 2]",
+	}, evalSources...)
+	for _, p := range passes {
+		for i, src := range sources {
+			want, err := Eval(buildASTWithPrelude(Prelude, src))
+			if err != nil {
+				t.Fatalf("case %d: %v", i, err)
+			}
+			ast, err := p.pass(buildASTWithPrelude(Prelude, src))
+			if err != nil {
+				t.Errorf("%s: case %d: %v", p.name, i, err)
+				continue
+			}
+			if p.name == "ConvertClosures and LiftLambdas" {
+				if err := checkFirstOrder(ast, freeVariables(buildASTFromString(src).Child)); err != nil {
+					t.Errorf("%s: case %d: %v", p.name, i, err)
+				}
+			}
+			if ast, err = reparse(ast); err != nil { // the output of tl-eval --emit closure or lift
+				t.Errorf("%s: case %d: %v", p.name, i, err)
+				continue
+			}
+			if isStuck(want) || containsFunction(want) { // functions are closure records after the conversion
+				continue
+			}
+			for _, eval := range []func(*AST) (*Node, error){Eval, EvalCEK, EvalVM} { // closures have {} as empty environments
+				got, err := eval(ast)
+				if err != nil {
+					t.Errorf("%s: case %d: %v", p.name, i, err)
+					continue
+				}
+				if got.String() != want.String() {
+					t.Errorf("%s: case %d: want %v but got %v\n", p.name, i, want, got)
+				}
+			}
+		}
+	}
+}
+
+func TestConvertClosures_output(t *testing.T) {
+	ast, err := ConvertClosures(buildASTFromString(".x -> .y -> x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "{.env .x -> ({.env .y -> (match env with | {x} -> (x)), {x}}), {}}", ast.Child.String(); got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	ast, err = LiftLambdas(buildASTFromString("def k = .x -> .y -> x; k 0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range ast.Declarations {
+		got = append(got, d.String())
+	}
+	if want := "def lambda = .x .y -> (x);,def k = .x -> (lambda x);"; strings.Join(got, ",") != want {
+		t.Errorf("want %v but got %v\n", want, strings.Join(got, ","))
+	}
+}
+
+func TestConvertClosures_error(t *testing.T) {
+	if _, err := ConvertClosures(buildASTFromString("callcc (.k -> 0)")); err == nil {
+		t.Errorf("callcc should not be supported")
+	}
+}
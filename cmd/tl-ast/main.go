@@ -43,6 +43,9 @@ func run(filename string) error {
 	if err != nil {
 		return err
 	}
+	for _, d := range ast.Declarations {
+		showNode(d, "")
+	}
 	showNode(ast.Child, "")
 	return nil
 }
@@ -1,50 +1,194 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/hkdnet/gtl"
 )
 
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Fprintf(os.Stderr, "USAGE: %s FILENAME", os.Args[0])
+	noPrelude := flag.Bool("no-prelude", false, "do not load the standard prelude")
+	pure := flag.Bool("pure", false, "reject primitives and load the prelude of Church encodings")
+	cek := flag.Bool("cek", false, "evaluate with the CEK machine")
+	vm := flag.Bool("vm", false, "compile into bytecode and evaluate with the virtual machine")
+	anf := flag.Bool("anf", false, "convert into A-normal form and evaluate it")
+	need := flag.Bool("need", false, "evaluate with call-by-need, and print the numbers of thunks to stderr")
+	opt := flag.Bool("opt", false, "optimize the program before evaluating or printing it")
+	emit := flag.String("emit", "", "print the program after a compiler `PASS` instead of evaluating it: opt, pe, cps, closure, lift or anf")
+	inputs := inputFlag{}
+	flag.Var(inputs, "input", "give `NAME=TERM`, the value of a free variable, to --emit pe. it can be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE: %s [OPTIONS] FILENAME\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	filename := flag.Arg(0)
+	if len(inputs) > 0 && *emit != "pe" {
+		usageError("--input can be used only with --emit pe")
 	}
-	filename := os.Args[1]
 
-	err := run(filename)
+	opts := loadOptions{LoadOptions: gtl.LoadOptions{Prelude: gtl.Prelude, Pure: *pure}, optimize: *opt}
+	if *pure {
+		opts.Prelude = gtl.ChurchPrelude
+	}
+	if *noPrelude {
+		opts.Prelude = ""
+	}
+	eval := gtl.Eval
+	var backends []string
+	for _, b := range []struct {
+		name string
+		set  bool
+		eval func(*gtl.AST) (*gtl.Node, error)
+	}{
+		{"--cek", *cek, gtl.EvalCEK},
+		{"--vm", *vm, gtl.EvalVM},
+		{"--anf", *anf, gtl.EvalANF},
+		{"--need", *need, evalNeed},
+	} {
+		if b.set {
+			backends = append(backends, b.name)
+			eval = b.eval
+		}
+	}
+	if len(backends) > 1 {
+		usageError(fmt.Sprintf("%s cannot be used together", strings.Join(backends, ", ")))
+	}
+	if len(backends) > 0 && *emit != "" {
+		usageError(fmt.Sprintf("%s cannot be used with --emit, which does not evaluate the program", backends[0]))
+	}
+	var err error
+	if *emit != "" {
+		err = emitProgram(filename, opts, *emit, inputs)
+	} else {
+		err = run(filename, opts, eval)
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
-func run(filename string) error {
-	b, err := ioutil.ReadFile(filename)
+// usageError reports a wrong combination of flags, and exits like flag does.
+func usageError(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+	flag.Usage()
+	os.Exit(2)
+}
+
+// inputFlag is the known inputs of partial evaluation, which are given by -input name=term.
+type inputFlag map[string]*gtl.Node
+
+func (f inputFlag) String() string {
+	var ret []string
+	for name, n := range f {
+		ret = append(ret, fmt.Sprintf("%s=%s", name, n))
+	}
+	return strings.Join(ret, " ")
+}
+
+func (f inputFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("an input should be NAME=TERM: %s", s)
+	}
+	tokens, err := gtl.Tokenize(s[i+1:])
+	if err != nil {
+		return err
+	}
+	ast, err := gtl.Parse(tokens)
 	if err != nil {
 		return err
 	}
-	source := string(b)
+	if len(ast.Declarations) > 0 {
+		return fmt.Errorf("an input should be a term: %s", s)
+	}
+	f[s[:i]] = ast.Child
+	return nil
+}
 
-	l := gtl.NewLexer(source)
-	var tokens []*gtl.Token
-	for l.HasNext() {
-		token, err := l.NextToken()
-		if err != nil {
-			return err
-		}
-		tokens = append(tokens, token)
+type loadOptions struct {
+	gtl.LoadOptions
+	optimize bool
+}
+
+func load(filename string, opts loadOptions) (*gtl.AST, error) {
+	ast, err := gtl.LoadFileWith(filename, opts.LoadOptions)
+	if err != nil {
+		return nil, err
 	}
-	ast, err := gtl.Parse(tokens)
+	if opts.optimize {
+		ast = gtl.Optimize(ast)
+	}
+	return ast, nil
+}
+
+func run(filename string, opts loadOptions, eval func(*gtl.AST) (*gtl.Node, error)) error {
+	ast, err := load(filename, opts)
 	if err != nil {
 		return err
 	}
-	result, err := gtl.Eval(ast)
+	result, err := eval(ast)
 	if err != nil {
 		return err
 	}
+	if result.NodeType == gtl.StringLiteral { // output of the program
+		fmt.Println(result.Name)
+		return nil
+	}
 	fmt.Println(result)
 	return nil
 }
+
+// evalNeed evaluates a program with call-by-need, and reports how many thunks are forced.
+func evalNeed(ast *gtl.AST) (*gtl.Node, error) {
+	n, stats, err := gtl.EvalNeedStats(ast)
+	fmt.Fprintf(os.Stderr, "thunks: %d created, %d forced\n", stats.Created, stats.Forced)
+	return n, err
+}
+
+// emitProgram prints the declarations and the main term of a program after a compiler pass.
+func emitProgram(filename string, opts loadOptions, pass string, inputs inputFlag) error {
+	ast, err := load(filename, opts)
+	if err != nil {
+		return err
+	}
+	switch pass {
+	case "opt":
+		ast = gtl.Optimize(ast)
+	case "pe": // free variables without --input are unknown inputs
+		ast, err = gtl.PartialEval(ast, inputs)
+	case "cps":
+		ast, err = gtl.ConvertCPS(ast)
+	case "closure": // the first-order program
+		if ast, err = gtl.ConvertClosures(ast); err == nil {
+			ast, err = gtl.LiftLambdas(ast)
+		}
+	case "lift":
+		ast, err = gtl.LiftLambdas(ast)
+	case "anf": // a separate representation from the AST
+		p, err := gtl.ConvertANF(ast)
+		if err != nil {
+			return err
+		}
+		fmt.Println(p)
+		return nil
+	default:
+		return fmt.Errorf("unknown pass %s", pass)
+	}
+	if err != nil {
+		return err
+	}
+	for _, d := range ast.Declarations {
+		fmt.Println(d)
+	}
+	fmt.Println(ast.Child)
+	return nil
+}
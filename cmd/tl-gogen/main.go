@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/hkdnet/gtl"
+)
+
+func main() {
+	pkg := flag.String("package", "main", "the package of the generated file")
+	output := flag.String("o", "", "write the generated file to `FILE` instead of the standard output")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE: %s [OPTIONS] FILENAME\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	filename := flag.Arg(0)
+
+	err := run(filename, gtl.GoOptions{Package: *pkg}, *output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run translates a program into Go.
+func run(filename string, opts gtl.GoOptions, output string) error {
+	ast, err := gtl.LoadFile(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := gtl.Typecheck(ast); err != nil {
+		return err
+	}
+	b, err := gtl.GenerateGo(ast, opts)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return ioutil.WriteFile(output, b, 0644)
+}
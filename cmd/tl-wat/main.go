@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/hkdnet/gtl"
+)
+
+func main() {
+	output := flag.String("o", "", "write the module to `FILE` instead of the standard output")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE: %s [OPTIONS] FILENAME\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	err := run(flag.Arg(0), *output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run translates a program into a module of the WebAssembly text format.
+func run(filename string, output string) error {
+	ast, err := gtl.LoadFile(filename)
+	if err != nil {
+		return err
+	}
+	ty, err := gtl.Typecheck(ast)
+	if err != nil {
+		return err
+	}
+	wat, err := gtl.GenerateWAT(ast)
+	if err != nil {
+		return err
+	}
+	wat = fmt.Sprintf(";; main returns a value of %s\n%s", ty, wat)
+	if output == "" {
+		fmt.Print(wat)
+		return nil
+	}
+	return ioutil.WriteFile(output, []byte(wat), 0644)
+}
@@ -0,0 +1,264 @@
+package gtl
+
+import (
+	"fmt"
+)
+
+// ConvertCPS converts a program into continuation-passing style.
+// Every function takes a continuation as its last argument, and calls it with its result instead of returning.
+// So every application is a tail call, and its function and arguments are values.
+// A multi-parameter lambda `.x .y -> t` becomes `.x .k -> k (.y .k' -> [t] k')`, and built-in functions are
+// eta-expanded when they are not applied to all arguments. Definitions and the main term are given the
+// identity continuation, so evaluating the result gives the same value for a program of a first-order type.
+// Control primitives become ordinary lambdas, since continuations are explicit:
+// callcc is `.f .k -> f (.v .k' -> k v) k`, and abort is `.v .k -> v`, which returns v without calling k.
+// The result is untyped, since type annotations of lambdas are dropped.
+func ConvertCPS(ast *AST) (*AST, error) {
+	c := &cpsConverter{names: newNameSupply(ast)}
+	ret := &AST{}
+	for _, d := range ast.Declarations {
+		if d.NodeType != Definition {
+			ret.Declarations = append(ret.Declarations, d)
+			continue
+		}
+		t, err := c.convert(d.Children[0], cpsIdentity)
+		if err != nil {
+			return nil, fmt.Errorf("def %s: %v", d.Name, err)
+		}
+		ret.Declarations = append(ret.Declarations, &Node{NodeType: Definition, Name: d.Name, Children: []*Node{t}})
+	}
+	t, err := c.convert(ast.Child, cpsIdentity)
+	if err != nil {
+		return nil, err
+	}
+	ret.Child = t
+	return ret, nil
+}
+
+// continuation is a continuation during the conversion. it is a term of the converted program such as
+// a variable k, or a function which builds the rest of the converted program from a value.
+// the latter is applied at conversion time, so it does not make administrative redexes.
+type continuation struct {
+	term *Node
+	meta func(v *Node) *Node
+}
+
+var cpsIdentity = continuation{meta: func(v *Node) *Node { return v }}
+
+func (k continuation) apply(v *Node) *Node {
+	if k.term != nil {
+		return applyTerms(k.term, v)
+	}
+	return k.meta(v)
+}
+
+type cpsConverter struct {
+	names nameSupply
+}
+
+// reify returns a term of a continuation.
+func (c *cpsConverter) reify(k continuation) *Node {
+	if k.term != nil {
+		return k.term
+	}
+	v := c.names.fresh("v")
+	return untypedLambda([]string{v}, k.meta(&Node{NodeType: Variable, Name: v}))
+}
+
+// share passes a term of a continuation to body, which uses it several times.
+// the continuation is bound to a variable unless it is already a variable.
+func (c *cpsConverter) share(k continuation, body func(k *Node) *Node) *Node {
+	if k.term != nil {
+		return body(k.term)
+	}
+	j := c.names.fresh("k")
+	return applyTerms(untypedLambda([]string{j}, body(&Node{NodeType: Variable, Name: j})), c.reify(k))
+}
+
+// convert returns the converted term of n, which passes the value of n to k.
+func (c *cpsConverter) convert(n *Node, k continuation) (*Node, error) {
+	switch n.NodeType {
+	case True, False, Zero, NodeNumber, StringLiteral, Nil, Variable, FreeVariable:
+		return k.apply(n), nil
+	case IsZero, Succ, Pred, Cons, IsNil, Head, Tail, Concat, StrLen, StrEq, BinaryOperator:
+		return c.convertApply(&Node{NodeType: n.NodeType, Name: n.Name}, n.Children, k)
+	case CallCC:
+		f, kv, v, kv1 := c.names.fresh("f"), c.names.fresh("k"), c.names.fresh("v"), c.names.fresh("k")
+		k0 := &Node{NodeType: Variable, Name: kv}
+		escape := untypedLambda([]string{v, kv1}, applyTerms(k0, &Node{NodeType: Variable, Name: v}))
+		return k.apply(untypedLambda([]string{f, kv}, applyTerms(&Node{NodeType: Variable, Name: f}, escape, k0))), nil
+	case Abort:
+		v := c.names.fresh("v")
+		return k.apply(untypedLambda([]string{v, c.names.fresh("k")}, &Node{NodeType: Variable, Name: v})), nil
+	case Lambda:
+		var params []string
+		for _, p := range n.Children[0].Children {
+			params = append(params, p.Name)
+		}
+		f, err := c.convertLambda(params, n.Children[1].Children[0])
+		if err != nil {
+			return nil, err
+		}
+		return k.apply(f), nil
+	case TypeAbstraction:
+		kv := c.names.fresh("k")
+		body, err := c.convert(n.Children[0], continuation{term: &Node{NodeType: Variable, Name: kv}})
+		if err != nil {
+			return nil, err
+		}
+		return k.apply(&Node{NodeType: TypeAbstraction, Name: n.Name, Children: []*Node{untypedLambda([]string{kv}, body), n.Children[1]}}), nil
+	case TypeApplication:
+		if isListPrimitive(n.Children[0]) || isControlPrimitive(n.Children[0]) { // nil[T] is nil at runtime
+			return c.convert(n.Children[0], k)
+		}
+		return c.convert(n.Children[0], continuation{meta: func(f *Node) *Node {
+			return applyTerms(&Node{NodeType: TypeApplication, Children: []*Node{f, n.Children[1]}}, c.reify(k))
+		}})
+	case Apply:
+		var args []*Node
+		f := n
+		for ; f.NodeType == Apply; f = f.Children[0] {
+			args = append([]*Node{f.Children[1]}, args...)
+		}
+		return c.convertApply(f, args, k)
+	case IF:
+		return c.convertValues(n.Children[:1], func(vs []*Node) (*Node, error) {
+			var ret *Node
+			var err error
+			ret = c.share(k, func(j *Node) *Node {
+				var branches [2]*Node
+				for i := range branches {
+					if branches[i], err = c.convert(n.Children[i+1], continuation{term: j}); err != nil {
+						return nil
+					}
+				}
+				return &Node{NodeType: IF, Children: []*Node{vs[0], branches[0], branches[1]}}
+			})
+			return ret, err
+		})
+	case Pack:
+		return c.convertValues(n.Children[1:2], func(vs []*Node) (*Node, error) {
+			return k.apply(&Node{NodeType: Pack, Children: []*Node{n.Children[0], vs[0], n.Children[2]}}), nil
+		})
+	case Unpack:
+		return c.convertValues(n.Children[1:2], func(vs []*Node) (*Node, error) {
+			body, err := c.convert(n.Children[2], k)
+			if err != nil {
+				return nil, err
+			}
+			return &Node{NodeType: Unpack, Name: n.Name, Children: []*Node{n.Children[0], vs[0], body}}, nil
+		})
+	case Tuple:
+		return c.convertValues(n.Children, func(vs []*Node) (*Node, error) {
+			return k.apply(&Node{NodeType: Tuple, Children: vs}), nil
+		})
+	case Variant:
+		return c.convertValues(n.Children[:1], func(vs []*Node) (*Node, error) {
+			return k.apply(&Node{NodeType: Variant, Name: n.Name, Children: []*Node{vs[0], n.Children[1]}}), nil
+		})
+	case Match:
+		return c.convertValues(n.Children[:1], func(vs []*Node) (*Node, error) {
+			var err error
+			ret := c.share(k, func(j *Node) *Node {
+				m := &Node{NodeType: Match, Children: []*Node{vs[0]}}
+				for _, mc := range n.Children[1:] {
+					var body *Node
+					if body, err = c.convert(mc.Children[1], continuation{term: j}); err != nil {
+						return nil
+					}
+					m.Children = append(m.Children, &Node{NodeType: MatchCase, Children: []*Node{mc.Children[0], body}})
+				}
+				return m
+			})
+			return ret, err
+		})
+	}
+	return nil, fmt.Errorf("cannot convert %s into CPS", n.NodeType)
+}
+
+// convertValues converts terms from left to right, and passes their values to body.
+func (c *cpsConverter) convertValues(ns []*Node, body func(vs []*Node) (*Node, error)) (*Node, error) {
+	var vs []*Node
+	var rec func(i int) (*Node, error)
+	rec = func(i int) (*Node, error) {
+		if i == len(ns) {
+			return body(vs)
+		}
+		var err error
+		ret, cerr := c.convert(ns[i], continuation{meta: func(v *Node) *Node {
+			vs = append(vs[:i], v)
+			var ret *Node
+			ret, err = rec(i + 1)
+			return ret
+		}})
+		if cerr != nil {
+			return nil, cerr
+		}
+		return ret, err
+	}
+	return rec(0)
+}
+
+// convertLambda returns a lambda which takes the first parameter and a continuation.
+func (c *cpsConverter) convertLambda(params []string, body *Node) (*Node, error) {
+	kv := c.names.fresh("k")
+	k := continuation{term: &Node{NodeType: Variable, Name: kv}}
+	var t *Node
+	var err error
+	if len(params) > 1 {
+		var f *Node
+		if f, err = c.convertLambda(params[1:], body); err == nil {
+			t = k.apply(f)
+		}
+	} else {
+		t, err = c.convert(body, k)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return untypedLambda([]string{params[0], kv}, t), nil
+}
+
+// convertApply converts an application of f to args. a built-in function which is given all arguments
+// is applied directly, and otherwise it is eta-expanded into a lambda in CPS.
+func (c *cpsConverter) convertApply(f *Node, args []*Node, k continuation) (*Node, error) {
+	p, ok := primitiveOf(f)
+	if !ok {
+		return c.convertValues(append([]*Node{f}, args...), func(vs []*Node) (*Node, error) {
+			return c.applyValues(vs[0], vs[1:], k), nil
+		})
+	}
+	arity := primitives[p].arity
+	if len(args) >= arity {
+		return c.convertValues(args, func(vs []*Node) (*Node, error) {
+			return c.applyValues(applyTerms(f, vs[:arity]...), vs[arity:], k), nil
+		})
+	}
+	var params []string
+	for i := 0; i < arity; i++ {
+		params = append(params, c.names.fresh("x"))
+	}
+	eta := f
+	for _, p := range params {
+		eta = applyTerms(eta, &Node{NodeType: Variable, Name: p})
+	}
+	eta, err := c.convertLambda(params, eta)
+	if err != nil {
+		return nil, err
+	}
+	return c.convertValues(args, func(vs []*Node) (*Node, error) {
+		return c.applyValues(eta, vs, k), nil
+	})
+}
+
+// applyValues applies a function in CPS to arguments one by one, and passes the result to k.
+func (c *cpsConverter) applyValues(f *Node, args []*Node, k continuation) *Node {
+	if len(args) == 0 {
+		return k.apply(f)
+	}
+	if len(args) == 1 {
+		return applyTerms(f, args[0], c.reify(k))
+	}
+	g := c.names.fresh("f")
+	return applyTerms(f, args[0], untypedLambda([]string{g}, c.applyValues(&Node{NodeType: Variable, Name: g}, args[1:], k)))
+}
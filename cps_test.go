@@ -0,0 +1,202 @@
+package gtl
+
+import "testing"
+
+// isCPSValue reports whether n is a value in CPS, which is evaluated without calling a function.
+func isCPSValue(n *Node) bool {
+	switch n.NodeType {
+	case Lambda:
+		return isCPSTerm(n.Children[1].Children[0])
+	case TypeAbstraction:
+		return isCPSValue(n.Children[0])
+	case Apply:
+		f := n
+		var args []*Node
+		for ; f.NodeType == Apply; f = f.Children[0] {
+			args = append(args, f.Children[1])
+		}
+		if p, ok := primitiveOf(f); !ok || len(args) != primitives[p].arity {
+			return false
+		}
+		for _, a := range args {
+			if !isCPSValue(a) {
+				return false
+			}
+		}
+		return true
+	case Tuple:
+		for _, c := range n.Children {
+			if !isCPSValue(c) {
+				return false
+			}
+		}
+		return true
+	case Variant:
+		return isCPSValue(n.Children[0])
+	case Pack:
+		return isCPSValue(n.Children[1])
+	case IF, Match, Unpack, TypeApplication:
+		return false
+	}
+	return true
+}
+
+// isCPSTerm reports whether n is a term in CPS, whose applications are tail calls with values.
+func isCPSTerm(n *Node) bool {
+	switch n.NodeType {
+	case IF:
+		return isCPSValue(n.Children[0]) && isCPSTerm(n.Children[1]) && isCPSTerm(n.Children[2])
+	case Match:
+		for _, mc := range n.Children[1:] {
+			if !isCPSTerm(mc.Children[1]) {
+				return false
+			}
+		}
+		return isCPSValue(n.Children[0])
+	case Unpack:
+		return isCPSValue(n.Children[1]) && isCPSTerm(n.Children[2])
+	case Apply:
+		if isCPSValue(n) {
+			return true
+		}
+		f := n
+		for ; f.NodeType == Apply; f = f.Children[0] {
+			if !isCPSValue(f.Children[1]) {
+				return false
+			}
+		}
+		if f.NodeType == TypeApplication {
+			f = f.Children[0]
+		}
+		return isCPSValue(f)
+	}
+	return isCPSValue(n)
+}
+
+func TestConvertCPS(t *testing.T) {
+	for i, src := range evalSources {
+		want, err := Eval(buildASTFromString(src))
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		ast, err := ConvertCPS(buildASTFromString(src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		for _, d := range ast.Declarations {
+			if !isCPSTerm(d.Children[0]) {
+				t.Errorf("case %d: def %s is not in CPS: %v", i, d.Name, d.Children[0])
+			}
+		}
+		if !isCPSTerm(ast.Child) {
+			t.Errorf("case %d: %v is not in CPS", i, ast.Child)
+		}
+		if ast, err = reparse(ast); err != nil { // the output of tl-eval --emit cps
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if isStuck(want) || containsFunction(want) { // functions take continuations after the conversion
+			continue
+		}
+		got, err := Eval(ast)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+	}
+}
+
+func containsFunction(n *Node) bool {
+	if n.NodeType == Lambda || n.NodeType == TypeAbstraction {
+		return true
+	}
+	if p, ok := primitiveOf(n); ok && len(n.Children) < primitives[p].arity {
+		return true
+	}
+	for _, c := range n.Children {
+		if containsFunction(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConvertCPS_prelude(t *testing.T) {
+	for i, src := range []string{
+		"times (succ (succ 0)) (succ (succ (succ 0)))",
+		"equal (succ 0) (succ 0)",
+		"map (plus (succ 0)) [0, succ 0]",
+		"foldr plus 0 [succ 0, succ 0]",
+		"length (append [0] [0, 0])",
+	} {
+		ast := buildASTWithPrelude(Prelude, src)
+		want, err := Eval(ast)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		cps, err := ConvertCPS(ast)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		got, err := Eval(cps)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+	}
+}
+
+func TestConvertCPS_control(t *testing.T) {
+	for i, v := range controlSources {
+		ast, err := ConvertCPS(buildASTWithPrelude(Prelude, v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		got, err := Eval(ast)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if containsFunction(got) { // a continuation is a lambda in CPS
+			continue
+		}
+		if got.String() != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+func TestConvertCPS_output(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"0", "0"},
+		{"succ (succ 0)", "succ (succ 0)"},
+		{"(.x -> x) 0", "(.x .k -> (k x)) 0 (.v -> (v))"},
+		{"(.x .y -> x) 0 true", "(.x .k -> (k (.y .k1 -> (k1 x)))) 0 (.f -> (f true (.v -> (v))))"},
+		{"def k = 0; (.x -> .k -> x) 0 true", "(.x .k1 -> (k1 (.k .k2 -> (k2 x)))) 0 (.f -> (f true (.v -> (v))))"},
+		{"if iszero 0 then 0 else 1 + 2", "(.k -> (if (iszero 0) then (k 0) else (k (1 + 2)))) (.v -> (v))"},
+		{"iszero ((.x -> x) 0)", "(.x .k -> (k x)) 0 (.v -> (iszero v))"},
+		{"succ", ".x .k -> (k (succ x))"},
+	}
+	for i, v := range testcases {
+		ast, err := ConvertCPS(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := ast.Child.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
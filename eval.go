@@ -2,6 +2,9 @@ package gtl
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"unicode/utf8"
 )
 
 type assginment struct {
@@ -36,9 +39,20 @@ func (ee *evalEnvironment) Unassign(name string) error {
 	return fmt.Errorf("missing unassignment target %s", name)
 }
 
-// Eval returns evaluated node
+// Eval returns evaluated node.
+// Definitions are evaluated and bound in order before the main expression.
 func Eval(ast *AST) (*Node, error) {
 	var env evalEnvironment
+	for _, d := range ast.Declarations {
+		if d.NodeType != Definition { // types have no runtime meaning
+			continue
+		}
+		v, err := eval(d.Children[0], &env)
+		if err != nil {
+			return nil, err
+		}
+		env.Assign(d.Name, v)
+	}
 	n, err := eval(ast.Child, &env)
 	if err != nil {
 		return nil, err
@@ -48,7 +62,7 @@ func Eval(ast *AST) (*Node, error) {
 
 func eval(n *Node, env *evalEnvironment) (*Node, error) {
 	switch n.NodeType {
-	case True, False, Zero, FreeVariable, Lambda, NodeNumber, IsZero:
+	case True, False, Zero, FreeVariable, Lambda, NodeNumber, IsZero, Succ, Pred, TypeAbstraction, Nil, Cons, IsNil, Head, Tail, StringLiteral, Concat, StrLen, StrEq, BinaryOperator, CallCC, Abort:
 		return n, nil
 	case IF:
 		return evalIf(n, env)
@@ -56,6 +70,18 @@ func eval(n *Node, env *evalEnvironment) (*Node, error) {
 		return evalApply(n, env)
 	case Variable:
 		return evalVariable(n, env)
+	case TypeApplication:
+		return evalTypeApplication(n, env)
+	case Pack:
+		return evalPack(n, env)
+	case Unpack:
+		return evalUnpack(n, env)
+	case Tuple:
+		return evalTuple(n, env)
+	case Variant:
+		return evalVariant(n, env)
+	case Match:
+		return evalMatch(n, env)
 	default:
 		return nil, fmt.Errorf("cannot eval: %s", n.NodeType)
 	}
@@ -96,11 +122,27 @@ func evalApply(n *Node, env *evalEnvironment) (*Node, error) {
 	if err != nil {
 		return nil, err
 	}
+	if isControlPrimitive(l) {
+		return nil, fmt.Errorf("%s needs the evaluation context, which only EvalCEK has", l)
+	}
 	if !l.IsApplyable() { // cannot eval apply
 		return &Node{NodeType: Apply, Children: []*Node{l, r}}, nil
 	}
+	if l.NodeType != Lambda {
+		return applyPrimitive(l, r)
+	}
+	ret, saturated := applyLambda(l, r)
+	if !saturated {
+		return ret, nil
+	}
+	return eval(ret, env)
+}
+
+// applyPrimitive applies a built-in function such as succ or head to a value.
+// if the value is not suitable for the function, the application is stuck.
+func applyPrimitive(l, r *Node) (*Node, error) {
 	if l.NodeType == IsZero {
-		if !r.IsValue() {
+		if !r.IsValue() || r.NodeType == NodeNumber { // a machine integer is not a Nat
 			return &Node{NodeType: Apply, Children: []*Node{l, r}}, nil
 		}
 		if r.NodeType == Zero {
@@ -108,33 +150,302 @@ func evalApply(n *Node, env *evalEnvironment) (*Node, error) {
 		}
 		return &Node{NodeType: False}, nil
 	}
+	if l.NodeType == Succ {
+		if !r.IsNumericalValue() {
+			return &Node{NodeType: Apply, Children: []*Node{l, r}}, nil
+		}
+		return &Node{NodeType: Succ, Children: []*Node{r}}, nil
+	}
+	if l.NodeType == Pred {
+		if !r.IsNumericalValue() {
+			return &Node{NodeType: Apply, Children: []*Node{l, r}}, nil
+		}
+		if r.NodeType == Zero {
+			return r, nil
+		}
+		return r.Children[0], nil
+	}
 
-	// l.NodeType == Lambda
-	if l.NodeType != Lambda {
-		panic("assert!")
+	if l.NodeType == Cons {
+		return &Node{NodeType: Cons, Children: append(append([]*Node{}, l.Children...), r)}, nil
+	}
+	if l.NodeType == IsNil || l.NodeType == Head || l.NodeType == Tail {
+		return evalListOperation(l, r), nil
+	}
+	if l.NodeType == Concat || l.NodeType == StrLen || l.NodeType == StrEq {
+		return evalStringOperation(l, r), nil
+	}
+	if l.NodeType == BinaryOperator {
+		return evalBinaryOperation(l, r)
 	}
+	panic("assert!")
+}
 
+// applyLambda substitutes an argument for the first parameter of a lambda.
+// A lambda with several parameters is curried, i.e. `.x .y -> t` is the same as `.x -> .y -> t`.
+// So if the lambda has more parameters, it returns the rest lambda `.y -> t[x := v]`, which is a value
+// whose body is not evaluated until the last argument is given. Otherwise it returns the substituted body and true.
+// Extra arguments such as `(.x -> .y -> x) a b` are applied to the result by outer Apply nodes.
+// Arguments are substituted rather than assigned in evalEnvironment, because a lambda which is returned
+// such as `const 0` of the prelude still refers to them after the application. evalEnvironment has only
+// top-level definitions, whose values are closed.
+func applyLambda(l, arg *Node) (*Node, bool) {
 	def := l.Children[0]
-	body := l.Children[1]
-	assigned := def.Children[0]
-	env.Assign(assigned.Name, r)
-	body, err = eval(body.Children[0], env)
-	if err != nil {
-		return nil, err
-	}
-	env.Unassign(assigned.Name)
+	body := l.Children[1].Children[0]
+	param := def.Children[0]
 	if len(def.Children) == 1 {
-		return body, nil
+		return substTerm(body, param.Name, arg), true
 	}
-	def.Children = def.Children[1:] // tail
-	ret := &Node{
+	rest := &Node{
 		NodeType: Lambda,
 		Children: []*Node{
-			def,
+			&Node{NodeType: LambdaDef, Children: def.Children[1:]},
 			&Node{NodeType: LambdaBody, Children: []*Node{body}},
 		},
 	}
-	return ret, nil
+	return substTerm(rest, param.Name, arg), false
+}
+
+// evalListOperation applies isnil, head or tail to a list.
+// head and tail of nil are stuck.
+func evalListOperation(op, list *Node) *Node {
+	if !list.IsListValue() {
+		return &Node{NodeType: Apply, Children: []*Node{op, list}}
+	}
+	switch {
+	case op.NodeType == IsNil && list.NodeType == Nil:
+		return &Node{NodeType: True}
+	case op.NodeType == IsNil:
+		return &Node{NodeType: False}
+	case op.NodeType == Head && list.NodeType == Cons:
+		return list.Children[0]
+	case op.NodeType == Tail && list.NodeType == Cons:
+		return list.Children[1]
+	}
+	return &Node{NodeType: Apply, Children: []*Node{op, list}}
+}
+
+// evalStringOperation applies concat, strlen or streq to a string.
+// concat and streq take two strings, so they keep the first one until the second one is given.
+func evalStringOperation(op, s *Node) *Node {
+	if s.NodeType != StringLiteral {
+		return &Node{NodeType: Apply, Children: []*Node{op, s}}
+	}
+	switch {
+	case op.NodeType == StrLen:
+		return natOf(utf8.RuneCountInString(s.Name))
+	case len(op.Children) == 0:
+		return &Node{NodeType: op.NodeType, Children: []*Node{s}}
+	case op.NodeType == Concat:
+		return &Node{NodeType: StringLiteral, Name: op.Children[0].Name + s.Name}
+	case op.Children[0].Name == s.Name:
+		return &Node{NodeType: True}
+	}
+	return &Node{NodeType: False}
+}
+
+// evalBinaryOperation applies an arithmetic operator to integers.
+// Nat values are also regarded as integers.
+func evalBinaryOperation(op, r *Node) (*Node, error) {
+	b, ok := intOf(r)
+	if !ok {
+		return &Node{NodeType: Apply, Children: []*Node{op, r}}, nil
+	}
+	if len(op.Children) == 0 {
+		return &Node{NodeType: BinaryOperator, Name: op.Name, Children: []*Node{r}}, nil
+	}
+	a, _ := intOf(op.Children[0])
+	ret, comparison, err := calculate(op.Name, a, b)
+	if err != nil {
+		return nil, err
+	}
+	if comparison {
+		return boolOf(ret != 0), nil
+	}
+	return &Node{NodeType: NodeNumber, Name: strconv.FormatInt(ret, 10)}, nil
+}
+
+// calculate applies an arithmetic operator to integers. the result of a comparison is 1 for true and 0 for false.
+// a result which does not fit in int64 is an error. note that the code generated by tl-gogen and tl-wat
+// uses native int64 arithmetic, which wraps around instead.
+func calculate(op string, a, b int64) (ret int64, comparison bool, err error) {
+	overflow := false
+	switch op {
+	case "+":
+		ret, overflow = a+b, (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b)
+	case "-":
+		ret, overflow = a-b, (b < 0 && a > math.MaxInt64+b) || (b > 0 && a < math.MinInt64+b)
+	case "*":
+		ret = a * b
+		overflow = a != 0 && (ret/a != b || (a == -1 && b == math.MinInt64))
+	case "/":
+		if b == 0 {
+			return 0, false, fmt.Errorf("division by zero: %d / %d", a, b)
+		}
+		ret, overflow = a/b, a == math.MinInt64 && b == -1
+	case "<":
+		ret = 0
+		if a < b {
+			ret = 1
+		}
+		return ret, true, nil
+	case "==":
+		ret = 0
+		if a == b {
+			ret = 1
+		}
+		return ret, true, nil
+	default:
+		return 0, false, fmt.Errorf("unknown operator %s", op)
+	}
+	if overflow {
+		return 0, false, fmt.Errorf("integer overflow: %d %s %d", a, op, b)
+	}
+	return ret, false, nil
+}
+
+// intOf returns the integer of a machine integer or a Nat value.
+func intOf(n *Node) (int64, bool) {
+	if n.NodeType == NodeNumber {
+		i, err := strconv.ParseInt(n.Name, 10, 64)
+		return i, err == nil
+	}
+	var ret int64
+	for ; n.NodeType == Succ && len(n.Children) == 1; n = n.Children[0] {
+		ret++
+	}
+	return ret, n.NodeType == Zero
+}
+
+func boolOf(b bool) *Node {
+	if b {
+		return &Node{NodeType: True}
+	}
+	return &Node{NodeType: False}
+}
+
+// natOf returns the numerical value of n, i.e. succ applied to 0 n times.
+func natOf(n int) *Node {
+	ret := &Node{NodeType: Zero}
+	for i := 0; i < n; i++ {
+		ret = &Node{NodeType: Succ, Children: []*Node{ret}}
+	}
+	return ret
+}
+
+// substTerm replaces free occurrences of the variable name in n with v.
+// bound variables are renamed if they would capture free variables of v, as substType does for types.
+// v is usually a closed value, so its free variables are found only when n has a binder.
+func substTerm(n *Node, name string, v *Node) *Node {
+	var fv map[string]bool
+	captures := func(binder string, body *Node) bool {
+		if fv == nil {
+			fv = make(map[string]bool)
+			for _, x := range freeVariables(v) {
+				fv[x] = true
+			}
+		}
+		return fv[binder] && occurrences(body, name) > 0
+	}
+	var subst func(n *Node) *Node
+	subst = func(n *Node) *Node {
+		switch n.NodeType {
+		case Variable:
+			if n.Name == name {
+				return v
+			}
+			return n
+		case Lambda:
+			def, body := n.Children[0], n.Children[1].Children[0]
+			params := make([]*Node, len(def.Children))
+			for i, p := range def.Children {
+				if p.Name == name {
+					return n
+				}
+				params[i] = p
+				if captures(p.Name, body) {
+					params[i], body = renameTermBinder(p, body, fv, def)
+				}
+			}
+			return &Node{
+				NodeType: Lambda,
+				Name:     n.Name,
+				Children: []*Node{
+					&Node{NodeType: LambdaDef, Name: def.Name, Children: params},
+					&Node{NodeType: n.Children[1].NodeType, Name: n.Children[1].Name, Children: []*Node{subst(body)}},
+				},
+			}
+		case Unpack: // the variable is bound only in the body
+			param, bound, body := n.Children[0], subst(n.Children[1]), n.Children[2]
+			if param.Name != name {
+				if captures(param.Name, body) {
+					param, body = renameTermBinder(param, body, fv, nil)
+				}
+				body = subst(body)
+			}
+			return &Node{NodeType: Unpack, Name: n.Name, Children: []*Node{param, bound, body}}
+		case MatchCase:
+			pattern, body := n.Children[0], n.Children[1]
+			vars, _ := patternVariables(pattern)
+			if containsString(vars, name) {
+				return n
+			}
+			for _, x := range vars {
+				if captures(x, body) {
+					p, b := renameTermBinder(&Node{NodeType: PatternVariable, Name: x}, body, fv, pattern)
+					pattern, body = renamePatternVariable(pattern, x, p.Name), b
+				}
+			}
+			return &Node{NodeType: MatchCase, Name: n.Name, Children: []*Node{pattern, subst(body)}}
+		}
+		if len(n.Children) == 0 {
+			return n
+		}
+		ret := &Node{NodeType: n.NodeType, Name: n.Name, Children: make([]*Node, len(n.Children))}
+		for i, c := range n.Children {
+			ret.Children[i] = subst(c)
+		}
+		return ret
+	}
+	return subst(n)
+}
+
+// renameTermBinder renames the variable bound by param in body to a name which is not in used,
+// and is neither free in body nor bound by siblings, such as the other parameters of the same lambda.
+func renameTermBinder(param, body *Node, used map[string]bool, siblings *Node) (*Node, *Node) {
+	avoid := make(map[string]bool)
+	for k := range used {
+		avoid[k] = true
+	}
+	for _, x := range freeVariables(body) {
+		avoid[x] = true
+	}
+	if siblings != nil {
+		for _, x := range boundVariables(siblings) {
+			avoid[x] = true
+		}
+	}
+	fresh := freshName(param.Name, avoid)
+	renamed := &Node{NodeType: param.NodeType, Name: fresh, Children: param.Children}
+	return renamed, substTerm(body, param.Name, &Node{NodeType: Variable, Name: fresh})
+}
+
+// renamePatternVariable renames the variable old in a pattern.
+func renamePatternVariable(p *Node, old, fresh string) *Node {
+	if p.NodeType == PatternVariable {
+		if p.Name == old {
+			return &Node{NodeType: PatternVariable, Name: fresh}
+		}
+		return p
+	}
+	if len(p.Children) == 0 {
+		return p
+	}
+	ret := &Node{NodeType: p.NodeType, Name: p.Name, Children: make([]*Node, len(p.Children))}
+	for i, c := range p.Children {
+		ret.Children[i] = renamePatternVariable(c, old, fresh)
+	}
+	return ret
 }
 
 func evalVariable(n *Node, env *evalEnvironment) (*Node, error) {
@@ -146,3 +457,117 @@ func evalVariable(n *Node, env *evalEnvironment) (*Node, error) {
 	}
 	return val, nil
 }
+
+// types have no runtime meaning, so (\X -> t) [T] is reduced to t where X is replaced with T
+func evalTypeApplication(n *Node, env *evalEnvironment) (*Node, error) {
+	l, err := eval(n.Children[0], env)
+	if err != nil {
+		return nil, err
+	}
+	ty := n.Children[1]
+	if isListPrimitive(l) || isControlPrimitive(l) { // nil[T] is nil at runtime
+		return l, nil
+	}
+	if l.NodeType != TypeAbstraction {
+		return &Node{NodeType: TypeApplication, Children: []*Node{l, ty}}, nil
+	}
+	return eval(substType(l.Children[0], l.Name, ty), env)
+}
+
+func evalPack(n *Node, env *evalEnvironment) (*Node, error) {
+	term, err := eval(n.Children[1], env)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{NodeType: Pack, Children: []*Node{n.Children[0], term, n.Children[2]}}, nil
+}
+
+func evalUnpack(n *Node, env *evalEnvironment) (*Node, error) {
+	param := n.Children[0]
+	bound, err := eval(n.Children[1], env)
+	if err != nil {
+		return nil, err
+	}
+	if bound.NodeType != Pack {
+		return &Node{NodeType: Unpack, Name: n.Name, Children: []*Node{param, bound, n.Children[2]}}, nil
+	}
+	body := substType(n.Children[2], n.Name, bound.Children[0])
+	return eval(substTerm(body, param.Name, bound.Children[1]), env)
+}
+
+func evalTuple(n *Node, env *evalEnvironment) (*Node, error) {
+	ret := &Node{NodeType: Tuple, Children: make([]*Node, len(n.Children))}
+	for i, c := range n.Children {
+		v, err := eval(c, env)
+		if err != nil {
+			return nil, err
+		}
+		ret.Children[i] = v
+	}
+	return ret, nil
+}
+
+func evalVariant(n *Node, env *evalEnvironment) (*Node, error) {
+	v, err := eval(n.Children[0], env)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{NodeType: Variant, Name: n.Name, Children: []*Node{v, n.Children[1]}}, nil
+}
+
+// evalMatch follows the decision tree of a match, and evaluates the body of the selected case
+// where its pattern variables are replaced with the parts of the value.
+// a match on a value which is not built with constructors is stuck.
+func evalMatch(n *Node, env *evalEnvironment) (*Node, error) {
+	v, err := eval(n.Children[0], env)
+	if err != nil {
+		return nil, err
+	}
+	tree, _ := compileMatch(n, nil, nil)
+	for len(tree.cases) != 0 {
+		part := valueAt(v, tree.occurrence)
+		if !isConstructorValue(part) {
+			return &Node{NodeType: Match, Children: append([]*Node{v}, n.Children[1:]...)}, nil
+		}
+		next := tree.fallback
+		for _, c := range tree.cases {
+			if c.constructor.matches(part) {
+				next = c.tree
+				break
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("no case matches %s", v)
+		}
+		tree = next
+	}
+	if tree.arm < 0 {
+		return nil, fmt.Errorf("no case matches %s", v)
+	}
+	body := n.Children[tree.arm+1].Children[1]
+	for _, b := range tree.bindings {
+		body = substTerm(body, b.name, valueAt(v, b.occurrence))
+	}
+	return eval(body, env)
+}
+
+func containsString(a []string, s string) bool {
+	for _, t := range a {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+func isListPrimitive(n *Node) bool {
+	switch n.NodeType {
+	case Nil, Cons, IsNil, Head, Tail:
+		return len(n.Children) == 0
+	}
+	return false
+}
+
+func isControlPrimitive(n *Node) bool {
+	return n.NodeType == CallCC || n.NodeType == Abort
+}
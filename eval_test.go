@@ -124,4 +124,286 @@ func Test_evalApply(t *testing.T) {
 			t.Errorf("want %v but got %v\n", want, got)
 		}
 	})
+	// an argument is substituted, so inner lambdas keep it
+	assertEval("(.a -> .b -> a) 0 true", func(n *Node) {
+		if want, got := Zero, n.NodeType; got != want {
+			t.Errorf("want %v but got %v\n", want, got)
+		}
+	})
+	// a partially applied lambda can be applied again
+	assertEval("(.f -> f true (f false true)) (.a .b -> a)", func(n *Node) {
+		if want, got := True, n.NodeType; got != want {
+			t.Errorf("want %v but got %v\n", want, got)
+		}
+	})
+}
+
+func Test_evalApply_currying(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		// under-application returns the rest lambda
+		{"(.x .y .z -> {x, y, z}) 0", ".y .z -> ({0, y, z})"},
+		{"(.x .y .z -> {x, y, z}) 0 true", ".z -> ({0, true, z})"},
+		{"(.x .y .z -> {x, y, z}) 0 true false", "{0, true, false}"},
+		// over-application applies the result to the rest arguments
+		{"(.x -> .y -> {x, y}) 0 true", "{0, true}"},
+		{"(.x .y -> .z -> {x, y, z}) 0 true false", "{0, true, false}"},
+		{"(.f .x -> f) (.y -> succ y) 0 0", "succ (0)"},
+		// the rest lambda keeps given arguments
+		{"def k = .x .y -> x; def k0 = k 0; {k0 true, k0 false}", "{0, 0}"},
+		{"def twice = .f .x -> f (f x); twice (twice succ) 0", "succ (succ (succ (succ (0))))"},
+		// a later parameter shadows an earlier one
+		{"(.x .x -> x) 0 true", "true"},
+		{"(.x .y -> x) y 0", "y"},
+	}
+	for i, v := range testcases {
+		n, err := Eval(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := n.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+func Test_evalTypeApplication(t *testing.T) {
+	assertEval(`(\X -> .x:X -> x) [Bool] true`, func(n *Node) {
+		if want, got := True, n.NodeType; got != want {
+			t.Errorf("want %v but got %v\n", want, got)
+		}
+	})
+	assertEval(`(\X -> .x:X -> x) [Bool]`, func(n *Node) {
+		if want, got := ".x:Bool -> (x)", n.String(); got != want {
+			t.Errorf("want %v but got %v\n", want, got)
+		}
+	})
+	assertEval(`f [Bool]`, func(n *Node) {
+		if want, got := TypeApplication, n.NodeType; got != want {
+			t.Errorf("want %v but got %v\n", want, got)
+		}
+	})
+}
+
+func Test_evalSuccPred(t *testing.T) {
+	assertEval("succ (succ 0)", func(n *Node) {
+		if want, got := "succ (succ (0))", n.String(); got != want {
+			t.Errorf("want %v but got %v\n", want, got)
+		}
+	})
+	assertEval("pred (succ 0)", func(n *Node) {
+		if want, got := Zero, n.NodeType; got != want {
+			t.Errorf("want %v but got %v\n", want, got)
+		}
+	})
+	assertEval("pred 0", func(n *Node) {
+		if want, got := Zero, n.NodeType; got != want {
+			t.Errorf("want %v but got %v\n", want, got)
+		}
+	})
+	assertEval("iszero (succ 0)", func(n *Node) {
+		if want, got := False, n.NodeType; got != want {
+			t.Errorf("want %v but got %v\n", want, got)
+		}
+	})
+}
+
+func Test_evalList(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"[0, succ 0]", "[0, succ (0)]"},
+		{"cons [Nat] 0 (nil [Nat])", "[0]"},
+		{"[[true], []]", "[[true], nil]"},
+		{"isnil [Nat] (nil [Nat])", "true"},
+		{"isnil [0]", "false"},
+		{"head [true, false]", "true"},
+		{"tail [true, false]", "[false]"},
+		{"head (tail [0, succ 0])", "succ (0)"},
+		{"head nil", "head nil"},
+		{"tail x", "tail x"},
+		{"cons 0 x", "cons 0 x"},
+		{"def length = .l -> if isnil l then 0 else succ (length (tail l)); length [true, true]", "succ (succ (0))"},
+	}
+	for i, v := range testcases {
+		n, err := Eval(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := n.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+func Test_evalString(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{`"a\"b\n"`, `"a\"b\n"`},
+		{`concat "foo" "bar"`, `"foobar"`},
+		{`concat "foo"`, `concat "foo"`},
+		{`(.f -> concat (f "a") (f "b")) (concat "x")`, `"xaxb"`},
+		{`strlen "日本語"`, "succ (succ (succ (0)))"},
+		{`strlen ""`, "0"},
+		{`streq "a" "a"`, "true"},
+		{`streq "a" "b"`, "false"},
+		{`concat x "a"`, "concat x \"a\""},
+	}
+	for i, v := range testcases {
+		n, err := Eval(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := n.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+func Test_evalBinaryOperation(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"10 - 3 - 2", "5"},
+		{"1 - 2", "-1"},
+		{"7 / 2", "3"},
+		{"1 < 2", "true"},
+		{"2 * 3 == 5", "false"},
+		{"succ (succ 0) * 21", "42"},
+		{"0 + 0", "0i"},
+		{"1 - 1 == 0i", "true"},
+		{"0i + 1", "1"},
+		{"iszero (1 - 1)", "iszero 0i"},
+		{"pred (2 - 2)", "pred 0i"},
+		{"9223372036854775806 + 1", "9223372036854775807"},
+		{"(.x -> x * x) 12", "144"},
+		{"def fact = .n -> if n < 2 then 1 else n * fact (n - 1); fact 20", "2432902008176640000"},
+		{"x + 1", "(x + 1)"},
+		{"1 + x", "(1 +) x"},
+	}
+	for i, v := range testcases {
+		n, err := Eval(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := n.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+	if _, err := Eval(buildASTFromString("1 / (1 - 1)")); err == nil {
+		t.Error("division by zero should be an error")
+	}
+	for i, src := range []string{
+		"9223372036854775807 + 1",
+		"0i - 9223372036854775807 - 2",
+		"4294967296 * 4294967296",
+		"(0i - 9223372036854775807 - 1) / (0i - 1)",
+	} {
+		if _, err := Eval(buildASTFromString(src)); err == nil {
+			t.Errorf("case %d: overflow should be an error", i)
+		}
+	}
+}
+
+func Test_evalUnpack(t *testing.T) {
+	assertEval("let {X, x} = {*Nat, succ 0} as {Some X, X} in iszero x", func(n *Node) {
+		if want, got := False, n.NodeType; got != want {
+			t.Errorf("want %v but got %v\n", want, got)
+		}
+	})
+	assertEval("let {X, x} = p in x", func(n *Node) {
+		if want, got := Unpack, n.NodeType; got != want {
+			t.Errorf("want %v but got %v\n", want, got)
+		}
+	})
+}
+
+func Test_substTerm(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"{x, y}", "{0, y}"},
+		{".y -> x", ".y -> (0)"},
+		{".x -> x", ".x -> (x)"},
+		{".y .x -> x", ".y .x -> (x)"},
+		{"let {X, x} = x in x", "let {X, x} = 0 in x"},
+		{"let {X, y} = x in x", "let {X, y} = 0 in 0"},
+		{"match x with | {x, _} -> x | _ -> x", "match 0 with | {x, _} -> (x) | _ -> (0)"},
+	}
+	for i, v := range testcases {
+		n := buildASTFromString("(.x -> " + v.src + ")").Child.Children[1].Children[0]
+		if got := substTerm(n, "x", &Node{NodeType: Zero}).String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+// a bound variable is renamed if it would capture a free variable of the substituted term
+func Test_substTerm_capture(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{".y -> x", ".y' -> (y)"},
+		{".y -> y", ".y -> (y)"},
+		{".y .y' -> {x, y, y'}", ".y'' .y' -> ({y, y'', y'})"},
+		{"let {X, y} = x in {x, y}", "let {X, y'} = y in {y, y'}"},
+		{"match 0 with | {y, z} -> {x, y, z}", "match 0 with | {y', z} -> ({y, y', z})"},
+	}
+	for i, v := range testcases {
+		n := buildASTFromString("(.x -> " + v.src + ")").Child.Children[1].Children[0]
+		if got := substTerm(n, "x", buildASTFromString("y").Child).String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+	if got, err := Eval(buildASTFromString("(.x -> .y -> x) y")); err != nil {
+		t.Error(err)
+	} else if want := ".y' -> (y)"; got.String() != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+}
+
+// a function which is returned by a definition keeps its arguments
+func TestEval_closures(t *testing.T) {
+	ast := buildASTFromString(`
+def const = .x .y -> x;
+def zero = const 0;
+def add = .m .n -> m + n;
+{zero true, zero false, add 1 2, add 3 4}
+`)
+	n, err := Eval(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "{0, 0, 3, 7}", n.String(); got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+}
+
+func TestEval_declarations(t *testing.T) {
+	ast := buildASTFromString(`
+def not = .b -> if b then false else true;
+def iseven = .n -> if iszero n then true else not (iseven (pred n));
+type Unused = Nat;
+iseven (succ (succ (succ 0)))
+`)
+	n, err := Eval(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := False, n.NodeType; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
 }
@@ -0,0 +1,565 @@
+package gtl
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// GoOptions are options of GenerateGo.
+type GoOptions struct {
+	// Package is the package of the generated file. A main package prints the result of the program
+	// as tl-eval does, and other packages have a function Main which returns it.
+	Package string
+}
+
+// GenerateGo translates a program into a standalone Go file. The program should be well-typed.
+// Types are erased, and values are represented by values of Go: Nat as uint64, Int as int64, Bool as bool,
+// String as string, and lambdas as func(interface{}) interface{}, which are curried as in gtl.
+// Definitions are package-level variables, which are initialized in order.
+func GenerateGo(ast *AST, opts GoOptions) ([]byte, error) {
+	g := &goGenerator{globals: make(map[string]bool)}
+	var names []string
+	for _, d := range ast.Declarations {
+		if d.NodeType == Definition && !g.globals[d.Name] {
+			g.globals[d.Name] = true
+			names = append(names, d.Name)
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by tl-gogen; DO NOT EDIT.\n\npackage %s\n", opts.Package)
+	b.WriteString(goRuntime)
+	if len(names) != 0 {
+		b.WriteString("\nvar (\n")
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s value\n", goIdentifier("d_", name))
+		}
+		b.WriteString(")\n\nfunc init() {\n")
+		for _, d := range ast.Declarations {
+			if d.NodeType != Definition { // types have no runtime meaning
+				continue
+			}
+			e, err := g.expr(d.Children[0])
+			if err != nil {
+				return nil, fmt.Errorf("def %s: %v", d.Name, err)
+			}
+			fmt.Fprintf(&b, "%s = %s\n", goIdentifier("d_", d.Name), e)
+		}
+		b.WriteString("}\n")
+	}
+	main, err := g.expr(ast.Child)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Package == "main" {
+		fmt.Fprintf(&b, "\nfunc main() {\nvar v value = %s\nif s, ok := v.(string); ok {\nfmt.Println(s)\nreturn\n}\nfmt.Println(show(v))\n}\n", main)
+	} else {
+		fmt.Fprintf(&b, "\n// Main returns the result of the program.\nfunc Main() interface{} {\nreturn %s\n}\n", main)
+	}
+	return format.Source(b.Bytes())
+}
+
+// goIdentifier returns an identifier of Go for a name with a prefix.
+// characters which cannot be in identifiers, and _ which is the escape character, are replaced with their code points.
+func goIdentifier(prefix, name string) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	for _, r := range name {
+		if r != '_' && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+		} else {
+			fmt.Fprintf(&b, "_%x_", r)
+		}
+	}
+	return b.String()
+}
+
+// goPrimitives are the functions of primitives in the runtime.
+var goPrimitives = []string{"isZero", "succ", "pred", "newCons", "isNil", "head", "tail", "concat", "strLen", "strEq", "add", "sub", "mul", "div", "lt", "eq"}
+
+// goGenerator translates terms into expressions of Go, whose type is value.
+type goGenerator struct {
+	globals map[string]bool
+	locals  []string // the innermost binding is the last
+}
+
+func (g *goGenerator) variable(name string) (string, error) {
+	for i := len(g.locals) - 1; i >= 0; i-- {
+		if g.locals[i] == name {
+			return goIdentifier("v_", name), nil
+		}
+	}
+	if g.globals[name] {
+		return goIdentifier("d_", name), nil
+	}
+	return "", fmt.Errorf("unbound variable %s", name)
+}
+
+// function returns a func literal which binds params in body.
+func (g *goGenerator) function(params []string, body *Node) (string, error) {
+	g.locals = append(g.locals, params...)
+	defer func() { g.locals = g.locals[:len(g.locals)-len(params)] }()
+	e, err := g.expr(body)
+	if err != nil {
+		return "", err
+	}
+	var ids []string
+	for _, p := range params {
+		ids = append(ids, goIdentifier("v_", p))
+	}
+	return fmt.Sprintf("func(%s value) value {\nreturn %s\n}", strings.Join(ids, ", "), e), nil
+}
+
+// lambda returns nested func literals of a lambda, which is curried.
+func (g *goGenerator) lambda(params []string, body *Node) (string, error) {
+	if len(params) == 1 {
+		return g.function(params, body)
+	}
+	g.locals = append(g.locals, params[0])
+	defer func() { g.locals = g.locals[:len(g.locals)-1] }()
+	e, err := g.lambda(params[1:], body)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("func(%s value) value {\nreturn %s\n}", goIdentifier("v_", params[0]), e), nil
+}
+
+func (g *goGenerator) exprs(ns []*Node) ([]string, error) {
+	var ret []string
+	for _, n := range ns {
+		e, err := g.expr(n)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
+
+func (g *goGenerator) expr(n *Node) (string, error) {
+	switch n.NodeType {
+	case True:
+		return "true", nil
+	case False:
+		return "false", nil
+	case Zero:
+		return "uint64(0)", nil
+	case NodeNumber:
+		if _, err := strconv.ParseInt(n.Name, 10, 64); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("int64(%s)", n.Name), nil
+	case StringLiteral:
+		return strconv.Quote(n.Name), nil
+	case Nil:
+		return "nilList", nil
+	case IsZero, Succ, Pred, Cons, IsNil, Head, Tail, Concat, StrLen, StrEq, BinaryOperator:
+		p, ok := primitiveOf(n)
+		if !ok {
+			return "", fmt.Errorf("unknown operator %s", n.Name)
+		}
+		if len(n.Children) != 0 { // partially applied, such as cons 0
+			return g.call(&Node{NodeType: n.NodeType, Name: n.Name}, n.Children)
+		}
+		if primitives[p].arity == 2 {
+			return fmt.Sprintf("curry(%s)", goPrimitives[p]), nil
+		}
+		return fmt.Sprintf("function(%s)", goPrimitives[p]), nil
+	case Variable, FreeVariable:
+		return g.variable(n.Name)
+	case Lambda:
+		var params []string
+		for _, p := range n.Children[0].Children {
+			params = append(params, p.Name)
+		}
+		return g.lambda(params, n.Children[1].Children[0])
+	case TypeAbstraction:
+		body, err := g.expr(n.Children[0])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("typeFunction(func() value {\nreturn %s\n})", body), nil
+	case TypeApplication:
+		e, err := g.expr(n.Children[0])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("instantiate(%s)", e), nil
+	case Apply:
+		var args []*Node
+		f := n
+		for ; f.NodeType == Apply; f = f.Children[0] {
+			args = append([]*Node{f.Children[1]}, args...)
+		}
+		return g.call(f, args)
+	case IF:
+		es, err := g.exprs(n.Children)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("func() value {\nif (%s).(bool) {\nreturn %s\n}\nreturn %s\n}()", es[0], es[1], es[2]), nil
+	case Pack:
+		e, err := g.expr(n.Children[1])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("&pack{hidden: %q, value: %s, ty: %q}", n.Children[0], e, n.Children[2]), nil
+	case Unpack:
+		bound, err := g.expr(n.Children[1])
+		if err != nil {
+			return "", err
+		}
+		f, err := g.function([]string{n.Children[0].Name}, n.Children[2])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s(unpack(%s))", f, bound), nil
+	case Tuple:
+		es, err := g.exprs(n.Children)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("[]value{%s}", strings.Join(es, ", ")), nil
+	case Variant:
+		e, err := g.expr(n.Children[0])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("&variant{label: %q, value: %s, ty: %q}", n.Name, e, n.Children[1]), nil
+	case Match:
+		e, err := g.expr(n.Children[0])
+		if err != nil {
+			return "", err
+		}
+		tree, _ := compileMatch(n, nil, nil)
+		body, err := g.decisionTree(n, tree)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("func(m value) value {\n%s}(%s)", body, e), nil
+	}
+	return "", fmt.Errorf("cannot generate Go: %s", n.NodeType)
+}
+
+// call applies f to args. a built-in function which is given enough arguments is called directly.
+func (g *goGenerator) call(f *Node, args []*Node) (string, error) {
+	es, err := g.exprs(args)
+	if err != nil {
+		return "", err
+	}
+	var fn string
+	if p, ok := primitiveOf(f); ok && len(f.Children) == 0 && len(es) >= primitives[p].arity {
+		arity := primitives[p].arity
+		fn = fmt.Sprintf("%s(%s)", goPrimitives[p], strings.Join(es[:arity], ", "))
+		es = es[arity:]
+		if len(es) == 0 {
+			return fn, nil
+		}
+	} else if fn, err = g.expr(f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("call(%s, %s)", fn, strings.Join(es, ", ")), nil
+}
+
+// decisionTree returns statements which return the value of the selected case. the matched value is m.
+func (g *goGenerator) decisionTree(n *Node, tree *decisionTree) (string, error) {
+	if len(tree.cases) == 0 {
+		if tree.arm < 0 {
+			return "panic(\"no case matches \" + show(m))\n", nil
+		}
+		body := n.Children[tree.arm+1].Children[1]
+		if len(tree.bindings) == 0 {
+			e, err := g.expr(body)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("return %s\n", e), nil
+		}
+		var params, parts []string
+		for _, b := range tree.bindings {
+			params = append(params, b.name)
+			parts = append(parts, goPart(b.occurrence))
+		}
+		f, err := g.function(params, body)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("return %s(%s)\n", f, strings.Join(parts, ", ")), nil
+	}
+	var b strings.Builder
+	for _, c := range tree.cases {
+		sub, err := g.decisionTree(n, c.tree)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "if %s {\n%s}\n", goTest(c.constructor, goPart(tree.occurrence)), sub)
+	}
+	if tree.fallback == nil {
+		b.WriteString("panic(\"no case matches \" + show(m))\n")
+		return b.String(), nil
+	}
+	sub, err := g.decisionTree(n, tree.fallback)
+	if err != nil {
+		return "", err
+	}
+	b.WriteString(sub)
+	return b.String(), nil
+}
+
+// goPart returns an expression of the part of m at an occurrence.
+func goPart(occ occurrence) string {
+	if len(occ) == 0 {
+		return "m"
+	}
+	var path []string
+	for _, i := range occ {
+		path = append(path, strconv.Itoa(i))
+	}
+	return fmt.Sprintf("field(m, %s)", strings.Join(path, ", "))
+}
+
+// goTest returns a condition which holds if v is built with c.
+func goTest(c constructor, v string) string {
+	switch c.nodeType {
+	case Zero:
+		return fmt.Sprintf("isInt(%s, 0)", v)
+	case NodeNumber:
+		return fmt.Sprintf("isInt(%s, %s)", v, c.name)
+	case Succ:
+		return fmt.Sprintf("isSucc(%s)", v)
+	case True:
+		return fmt.Sprintf("%s.(bool)", v)
+	case False:
+		return fmt.Sprintf("!%s.(bool)", v)
+	case Nil:
+		return fmt.Sprintf("%s.(*cons) == nil", v)
+	case Cons:
+		return fmt.Sprintf("%s.(*cons) != nil", v)
+	case Tuple:
+		return fmt.Sprintf("len(%s.([]value)) == %d", v, c.arity)
+	}
+	return fmt.Sprintf("%s.(*variant).label == %q", v, c.name)
+}
+
+// goRuntime is the part of generated files which does not depend on programs.
+const goRuntime = `
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type value = interface{}
+
+type function = func(value) value
+
+// typeFunction is a type abstraction, whose body is evaluated when it is applied to a type.
+type typeFunction func() value
+
+type cons struct {
+	head, tail value
+}
+
+var nilList value = (*cons)(nil)
+
+type variant struct {
+	label string
+	value value
+	ty    string
+}
+
+type pack struct {
+	hidden string
+	value  value
+	ty     string
+}
+
+func call(f value, args ...value) value {
+	for _, a := range args {
+		f = f.(function)(a)
+	}
+	return f
+}
+
+func curry(f func(a, b value) value) value {
+	return function(func(a value) value {
+		return function(func(b value) value {
+			return f(a, b)
+		})
+	})
+}
+
+// instantiate applies a type abstraction to a type. nil, cons, isnil, head and tail are polymorphic as they are.
+func instantiate(v value) value {
+	if f, ok := v.(typeFunction); ok {
+		return f()
+	}
+	return v
+}
+
+func unpack(v value) value {
+	return v.(*pack).value
+}
+
+// field returns the part of a value built with a constructor at a path.
+func field(v value, path ...int) value {
+	for _, i := range path {
+		switch w := v.(type) {
+		case uint64:
+			v = w - 1
+		case *cons:
+			if i == 0 {
+				v = w.head
+			} else {
+				v = w.tail
+			}
+		case []value:
+			v = w[i]
+		case *variant:
+			v = w.value
+		}
+	}
+	return v
+}
+
+func toInt(v value) int64 {
+	if n, ok := v.(uint64); ok {
+		return int64(n)
+	}
+	return v.(int64)
+}
+
+func isInt(v value, i int64) bool {
+	switch n := v.(type) {
+	case uint64:
+		return int64(n) == i
+	case int64:
+		return n == i
+	}
+	return false
+}
+
+func isSucc(v value) bool {
+	n, ok := v.(uint64)
+	return ok && n > 0
+}
+
+func isZero(v value) value {
+	n, ok := v.(uint64)
+	return ok && n == 0
+}
+
+func succ(v value) value {
+	return v.(uint64) + 1
+}
+
+func pred(v value) value {
+	if n := v.(uint64); n > 0 {
+		return n - 1
+	}
+	return uint64(0)
+}
+
+func newCons(h, t value) value {
+	return &cons{h, t}
+}
+
+func isNil(v value) value {
+	return v.(*cons) == nil
+}
+
+func head(v value) value {
+	if v.(*cons) == nil {
+		panic("head of nil")
+	}
+	return v.(*cons).head
+}
+
+func tail(v value) value {
+	if v.(*cons) == nil {
+		panic("tail of nil")
+	}
+	return v.(*cons).tail
+}
+
+func concat(a, b value) value {
+	return a.(string) + b.(string)
+}
+
+func strLen(v value) value {
+	return uint64(utf8.RuneCountInString(v.(string)))
+}
+
+func strEq(a, b value) value {
+	return a.(string) == b.(string)
+}
+
+func add(a, b value) value {
+	return toInt(a) + toInt(b)
+}
+
+func sub(a, b value) value {
+	return toInt(a) - toInt(b)
+}
+
+func mul(a, b value) value {
+	return toInt(a) * toInt(b)
+}
+
+func div(a, b value) value {
+	if toInt(b) == 0 {
+		panic(fmt.Sprintf("division by zero: %d / %d", toInt(a), toInt(b)))
+	}
+	return toInt(a) / toInt(b)
+}
+
+func lt(a, b value) value {
+	return toInt(a) < toInt(b)
+}
+
+func eq(a, b value) value {
+	return toInt(a) == toInt(b)
+}
+
+var stringEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\t", "\\t", "\r", "\\r")
+
+// show returns a value in the syntax of gtl. functions cannot be shown.
+func show(v value) string {
+	switch w := v.(type) {
+	case bool:
+		return strconv.FormatBool(w)
+	case uint64:
+		return strings.Repeat("succ (", int(w)) + "0" + strings.Repeat(")", int(w))
+	case int64:
+		return strconv.FormatInt(w, 10)
+	case string:
+		return "\"" + stringEscaper.Replace(w) + "\""
+	case *cons:
+		if w == nil {
+			return "nil"
+		}
+		var elems []string
+		for ; w != nil; w = w.tail.(*cons) {
+			elems = append(elems, show(w.head))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case []value:
+		var elems []string
+		for _, e := range w {
+			elems = append(elems, show(e))
+		}
+		return "{" + strings.Join(elems, ", ") + "}"
+	case *variant:
+		return fmt.Sprintf("<%s = %s> as %s", w.label, show(w.value), w.ty)
+	case *pack:
+		return fmt.Sprintf("{*%s, %s} as %s", w.hidden, show(w.value), w.ty)
+	case typeFunction:
+		return "<type abstraction>"
+	}
+	return "<function>"
+}
+`
@@ -0,0 +1,120 @@
+package gtl
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// goSources are well-typed programs whose generated Go should print the same result as Eval.
+var goSources = []string{
+	"true",
+	"if iszero (succ 0) then 0 else succ 0",
+	"(.x:Nat .y:Nat -> x) (succ 0) 0",
+	"(.f:(Nat -> Nat) .x:Nat -> f (f x)) (.x:Nat -> succ x) 0",
+	"pred (succ (succ 0))",
+	`(\X -> .x:X -> x) [Bool] true`,
+	"let {X, p} = {*Nat, {succ 0, .x:Nat -> iszero x}} as {Some X, {X, X -> Bool}} in match p with | {x, f} -> f x",
+	"{*Nat, succ 0} as {Some X, X}",
+	"[0, succ 0]",
+	"head [Nat] (tail [Nat] [0, succ 0])",
+	"isnil [Nat] (nil [Nat])",
+	`concat "foo\n" "bar"`,
+	`{strlen "日本", streq "a" "b", "\"q\""}`,
+	"1 + 2 * 3 - 10 / 3",
+	"succ 0 + 1 < 3",
+	"def fact : Int -> Int = .n:Int -> if n < 2 then 1 else n * fact (n - 1); fact 20",
+	"def twice = .f:(Nat -> Nat) .x:Nat -> f (f x); twice (twice succ) 0",
+	"infixl 6 <>; def (<>) = .a:String .b:String -> concat a b; \"a\" <> \"b\" <> \"c\"",
+	"match {true, {0, 1}} with | {false, _} -> 0 | {true, {x, y}} -> y",
+	"match <b = 3> as <a: Nat, b: Int> with | <a = _> -> 0 | <b = n> -> n * 2",
+	"<b = succ 0> as <a: Bool, b: Nat>",
+	"def sum : List Int -> Int = .l:(List Int) -> match l with | [] -> 0 | cons x rest -> x + sum rest; sum [1, 2, 3]",
+	"def plus : Nat -> Nat -> Nat = .m:Nat .n:Nat -> match m with | 0 -> n | succ k -> succ (plus k n); plus (succ (succ 0)) (succ 0)",
+}
+
+func TestGenerateGo(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping compilation of generated Go in short mode")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is not found")
+	}
+	dir, err := ioutil.TempDir("", "gtl-gogen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i, src := range goSources {
+		ast := buildASTFromString(src)
+		if _, err := Typecheck(ast); err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		n, err := Eval(ast)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		want := n.String()
+		if n.NodeType == StringLiteral {
+			want = n.Name
+		}
+		b, err := GenerateGo(ast, GoOptions{Package: "main"})
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		file := filepath.Join(dir, "main.go")
+		if err := ioutil.WriteFile(file, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command(gobin, "run", file).CombinedOutput()
+		if err != nil {
+			t.Errorf("case %d: %v\n%s", i, err, out)
+			continue
+		}
+		if got := strings.TrimSuffix(string(out), "\n"); got != want {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+	}
+}
+
+func TestGenerateGo_package(t *testing.T) {
+	b, err := GenerateGo(buildASTFromString("def id = .x:Nat -> x; id 0"), GoOptions{Package: "logic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "logic.go", b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "logic", f.Name.Name; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	if f.Scope.Lookup("Main") == nil || f.Scope.Lookup("main") != nil {
+		t.Errorf("want Main but not main:\n%s", b)
+	}
+}
+
+func Test_goIdentifier(t *testing.T) {
+	testcases := []struct {
+		name string
+		want string
+	}{
+		{"x", "v_x"},
+		{"x'", "v_x_27_"},
+		{"B::and", "v_B_3a__3a_and"},
+		{"a_b", "v_a_5f_b"},
+		{"++", "v__2b__2b_"},
+	}
+	for i, v := range testcases {
+		if got := goIdentifier("v_", v.name); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package gtl
+
+import (
+	"fmt"
+)
+
+// kindOf returns the kind of a type, or an error if the type is ill-kinded.
+func (te *typeEnvironment) kindOf(ty *Node) (*Node, error) {
+	star := &Node{NodeType: KindStar}
+	switch ty.NodeType {
+	case TypeBool, TypeNat, TypeString, TypeInt, TypeBot:
+		return star, nil
+	case TypeTop:
+		if len(ty.Children) == 1 {
+			return ty.Children[0], nil
+		}
+		return star, nil
+	case TypeVariable:
+		bound := te.LookupTypeVariable(ty.Name)
+		if bound == nil {
+			return nil, fmt.Errorf("unbound type variable %s", ty.Name)
+		}
+		return te.kindOf(bound)
+	case TypeArrow, TypeList, TypeTuple:
+		for _, c := range ty.Children {
+			if err := te.checkKind(c, star); err != nil {
+				return nil, err
+			}
+		}
+		return star, nil
+	case TypeVariant:
+		for _, f := range ty.Children {
+			if err := te.checkKind(f.Children[0], star); err != nil {
+				return nil, err
+			}
+		}
+		return star, nil
+	case TypeAll, TypeSome:
+		if _, err := te.kindOf(ty.Children[1]); err != nil {
+			return nil, err
+		}
+		name, body := te.renameShadowingTypeVariable(ty.Name, ty.Children[0])
+		te.AddTypeVariable(name, ty.Children[1])
+		err := te.checkKind(body, star)
+		te.RemoveTypeVariable(name)
+		if err != nil {
+			return nil, err
+		}
+		return star, nil
+	case OperatorAbstraction:
+		param := ty.Children[1]
+		name, body := te.renameShadowingTypeVariable(ty.Name, ty.Children[0])
+		te.AddTypeVariable(name, topOfKind(param))
+		k, err := te.kindOf(body)
+		te.RemoveTypeVariable(name)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{NodeType: KindArrow, Children: []*Node{param, k}}, nil
+	case OperatorApplication:
+		f, err := te.kindOf(ty.Children[0])
+		if err != nil {
+			return nil, err
+		}
+		if f.NodeType != KindArrow {
+			return nil, fmt.Errorf("%s is not a type operator but has kind %s", ty.Children[0], f)
+		}
+		if err := te.checkKind(ty.Children[1], f.Children[0]); err != nil {
+			return nil, err
+		}
+		return f.Children[1], nil
+	default:
+		return nil, fmt.Errorf("%s is not a type", ty.NodeType)
+	}
+}
+
+// checkKind returns an error if a type does not have the kind.
+func (te *typeEnvironment) checkKind(ty *Node, kind *Node) error {
+	k, err := te.kindOf(ty)
+	if err != nil {
+		return err
+	}
+	if !typeEqual(k, kind) {
+		return fmt.Errorf("%s should have kind %s but has %s", ty, kind, k)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package gtl
+
+import (
+	"testing"
+)
+
+func Test_kindOf(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"Nat", "*"},
+		{"Nat -> Bool", "*"},
+		{`\X -> X`, "* => *"},
+		{`\X -> \Y -> All R. (X -> Y -> R) -> R`, "* => * => *"},
+		{`\F:* => * -> F Nat`, "(* => *) => *"},
+		{`(\X -> \Y -> X) Nat`, "* => *"},
+		{`All F:* => *. F Nat -> F Bool`, "*"},
+	}
+	for i, v := range testcases {
+		var env typeEnvironment
+		k, err := env.kindOf(buildTypeFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := k.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+func Test_kindOf_error(t *testing.T) {
+	testcases := []string{
+		"X",
+		"Nat Bool",
+		`(\X -> X) -> Nat`,
+		`(\F:* => * -> F) Nat`,
+		`All X. \Y -> Y`,
+	}
+	for i, v := range testcases {
+		var env typeEnvironment
+		if _, err := env.kindOf(buildTypeFromString(v)); err == nil {
+			t.Errorf("case %d: %s should be ill-kinded", i, v)
+		}
+	}
+}
+
+func Test_normalizeType(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{`(\X -> X -> X) Nat`, "Nat -> Nat"},
+		{`(\X -> \Y -> All R. (X -> Y -> R) -> R) Nat Bool`, "All R. (Nat -> Bool -> R) -> R"},
+		{`(\F:* => * -> F Nat) (\X -> X -> X)`, "Nat -> Nat"},
+		{`All F:* => *. F Nat`, "All F:* => *. F Nat"},
+		{`(\X -> \Y -> X) Y`, `\Y' -> Y`},
+	}
+	for i, v := range testcases {
+		if got := normalizeType(buildTypeFromString(v.src)).String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
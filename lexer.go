@@ -2,6 +2,7 @@ package gtl
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 )
 
@@ -34,6 +35,40 @@ func init() {
 	keywordMap["then"] = KeywordThen
 	keywordMap["else"] = KeywordElse
 	keywordMap["iszero"] = KeywordIsZero
+	keywordMap["All"] = KeywordAll
+	keywordMap["Some"] = KeywordSome
+	keywordMap["as"] = KeywordAs
+	keywordMap["let"] = KeywordLet
+	keywordMap["in"] = KeywordIn
+	keywordMap["succ"] = KeywordSucc
+	keywordMap["pred"] = KeywordPred
+	keywordMap["def"] = KeywordDef
+	keywordMap["type"] = KeywordType
+	keywordMap["import"] = KeywordImport
+	keywordMap["export"] = KeywordExport
+	keywordMap["nil"] = KeywordNil
+	keywordMap["cons"] = KeywordCons
+	keywordMap["isnil"] = KeywordIsNil
+	keywordMap["head"] = KeywordHead
+	keywordMap["tail"] = KeywordTail
+	keywordMap["concat"] = KeywordConcat
+	keywordMap["strlen"] = KeywordStrLen
+	keywordMap["streq"] = KeywordStrEq
+	keywordMap["callcc"] = KeywordCallCC
+	keywordMap["abort"] = KeywordAbort
+	keywordMap["infixl"] = KeywordInfixl
+	keywordMap["infixr"] = KeywordInfixr
+	keywordMap["infix"] = KeywordInfix
+	keywordMap["match"] = KeywordMatch
+	keywordMap["with"] = KeywordWith
+}
+
+var escapes = map[byte]byte{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'"':  '"',
+	'\\': '\\',
 }
 
 // NewLexer returns a new lexer from source string
@@ -64,25 +99,37 @@ func (l *Lexer) NextToken() (*Token, error) {
 	case isWhitespace(c):
 		l.cur++
 		return l.NextToken()
-	case strings.Contains("abcdefghijklmnopqrstuvwxyz", c):
-		for kw, tt := range keywordMap {
-			if size := len(kw); len(l.source) >= idx+size && l.source[idx:idx+size] == kw {
-				l.cur += size
-				return &Token{tt, kw}, nil
-			}
-		}
-		mode = Word
+	case isWordStart(c):
 		for ; idx < len(l.source); idx++ {
-			c := l.source[idx : idx+1]
-			if isWhitespace(c) {
+			if !isWordPart(l.source[idx : idx+1]) {
 				break
 			}
-			if c == "(" || c == ")" || c == "." {
-				break
+		}
+		// qualified name such as Mod::name
+		if rest := l.source[idx:]; strings.HasPrefix(rest, "::") && len(rest) > 2 && isWordStart(rest[2:3]) {
+			for idx += 2; idx < len(l.source); idx++ {
+				if !isWordPart(l.source[idx : idx+1]) {
+					break
+				}
 			}
 		}
+		// qualified operator such as Mod::++
+		if rest := l.source[idx:]; strings.HasPrefix(rest, "::") && len(rest) > 2 && isQualifiedOperatorPart(rest[2:3]) {
+			for idx += 2; idx < len(l.source); idx++ {
+				if !isQualifiedOperatorPart(l.source[idx : idx+1]) {
+					break
+				}
+			}
+			l.cur = idx
+			return &Token{Operator, l.source[beg:idx]}, nil
+		}
 		l.cur = idx
-		return &Token{mode, l.source[beg:idx]}, nil
+		text := l.source[beg:idx]
+		if tt, ok := keywordMap[text]; ok {
+			return &Token{tt, text}, nil
+		}
+		mode = Word
+		return &Token{mode, text}, nil
 	case c == "(":
 		mode = LParen
 		l.cur++
@@ -103,20 +150,123 @@ func (l *Lexer) NextToken() (*Token, error) {
 		mode = Dot
 		l.cur++
 		return &Token{mode, l.source[beg : beg+1]}, nil
-	case c == "0":
-		mode = Number
+	case isDigit(c):
+		for ; idx < len(l.source); idx++ {
+			if !isDigit(l.source[idx : idx+1]) {
+				break
+			}
+		}
+		// the suffix i makes an Int such as 0i, which is distinguished from 0 of Nat
+		if idx < len(l.source) && l.source[idx] == 'i' && (idx+1 == len(l.source) || !isWordPart(l.source[idx+1:idx+2])) {
+			idx++
+		}
+		l.cur = idx
+		return &Token{Number, l.source[beg:idx]}, nil
+	case c == "\\":
+		mode = Backslash
+		l.cur++
+		return &Token{mode, l.source[beg : beg+1]}, nil
+	case c == "[":
+		mode = LBracket
+		l.cur++
+		return &Token{mode, l.source[beg : beg+1]}, nil
+	case c == "]":
+		mode = RBracket
+		l.cur++
+		return &Token{mode, l.source[beg : beg+1]}, nil
+	case c == ":":
+		mode = Colon
+		l.cur++
+		return &Token{mode, l.source[beg : beg+1]}, nil
+	case c == "*":
+		mode = Star
 		l.cur++
 		return &Token{mode, l.source[beg : beg+1]}, nil
-	case c == "-":
-		if l.source[idx+1:idx+2] == ">" {
-			l.cur += 2
-			return &Token{Arrow, l.source[beg : beg+2]}, nil
+	case c == ",":
+		mode = Comma
+		l.cur++
+		return &Token{mode, l.source[beg : beg+1]}, nil
+	case c == "\"":
+		return l.lexString(idx)
+	case c == ";":
+		mode = Semicolon
+		l.cur++
+		return &Token{mode, l.source[beg : beg+1]}, nil
+	case strings.HasPrefix(l.source[idx:], "<:"):
+		l.cur += 2
+		return &Token{Subtype, l.source[beg : beg+2]}, nil
+	case isOperatorPart(c):
+		for ; idx < len(l.source); idx++ {
+			if !isOperatorPart(l.source[idx : idx+1]) {
+				break
+			}
 		}
+		l.cur = idx
+		text := l.source[beg:idx]
+		switch text {
+		case "->":
+			return &Token{Arrow, text}, nil
+		case "=>":
+			return &Token{FatArrow, text}, nil
+		case "=":
+			return &Token{Equal, text}, nil
+		case "|":
+			return &Token{Bar, text}, nil
+		}
+		return &Token{Operator, text}, nil
 	}
 
 	return nil, ErrUnknownToken
 }
 
+func isWordStart(s string) bool {
+	return strings.Contains("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_", s)
+}
+
+// isOperatorPart returns whether s can be a part of an operator such as "+" and "++".
+// "*" is not, because it is also the kind of proper types.
+func isOperatorPart(s string) bool {
+	return strings.Contains("+-/<>=!&|^%?~@$", s)
+}
+
+// isQualifiedOperatorPart is isOperatorPart which accepts * as well, because Mod::* is not a type.
+func isQualifiedOperatorPart(s string) bool {
+	return s == "*" || isOperatorPart(s)
+}
+
+func isDigit(s string) bool {
+	return strings.Contains("0123456789", s)
+}
+
+func isWordPart(s string) bool {
+	return isWordStart(s) || strings.Contains("0123456789'", s)
+}
+
 func isWhitespace(s string) bool {
 	return s == "\t" || s == "\n" || s == "\r" || s == "\f" || s == " "
 }
+
+// lexString reads a string literal which starts at idx, and unescapes its content.
+func (l *Lexer) lexString(idx int) (*Token, error) {
+	var b strings.Builder
+	for i := idx + 1; i < len(l.source); i++ {
+		switch c := l.source[i]; c {
+		case '"':
+			l.cur = i + 1
+			return &Token{String, b.String()}, nil
+		case '\\':
+			if i+1 == len(l.source) {
+				return nil, errors.New("unterminated string literal")
+			}
+			e, ok := escapes[l.source[i+1]]
+			if !ok {
+				return nil, fmt.Errorf("unknown escape sequence \\%c in string literal", l.source[i+1])
+			}
+			b.WriteByte(e)
+			i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return nil, errors.New("unterminated string literal")
+}
@@ -39,6 +39,70 @@ func TestNextToken(t *testing.T) {
 		{"if", &Token{KeywordIf, "if"}, 2},
 		{"then", &Token{KeywordThen, "then"}, 4},
 		{"else", &Token{KeywordElse, "else"}, 4},
+		{"iffy", &Token{Word, "iffy"}, 4},
+		{"x->", &Token{Word, "x"}, 1},
+		{"X", &Token{Word, "X"}, 1},
+		{"x'", &Token{Word, "x'"}, 2},
+		{"All", &Token{KeywordAll, "All"}, 3},
+		{"\\", &Token{Backslash, "\\"}, 1},
+		{"[", &Token{LBracket, "["}, 1},
+		{"]", &Token{RBracket, "]"}, 1},
+		{":", &Token{Colon, ":"}, 1},
+		{"*", &Token{Star, "*"}, 1},
+		{",", &Token{Comma, ","}, 1},
+		{"=", &Token{Equal, "="}, 1},
+		{"=>", &Token{FatArrow, "=>"}, 2},
+		{"Some", &Token{KeywordSome, "Some"}, 4},
+		{"as", &Token{KeywordAs, "as"}, 2},
+		{"let", &Token{KeywordLet, "let"}, 3},
+		{"in", &Token{KeywordIn, "in"}, 2},
+		{"inc", &Token{Word, "inc"}, 3},
+		{"succ", &Token{KeywordSucc, "succ"}, 4},
+		{"pred", &Token{KeywordPred, "pred"}, 4},
+		{"<:", &Token{Subtype, "<:"}, 2},
+		{";", &Token{Semicolon, ";"}, 1},
+		{"def", &Token{KeywordDef, "def"}, 3},
+		{"type", &Token{KeywordType, "type"}, 4},
+		{"import", &Token{KeywordImport, "import"}, 6},
+		{"export", &Token{KeywordExport, "export"}, 6},
+		{"\"a.tl\"", &Token{String, "a.tl"}, 6},
+		{"B::and", &Token{Word, "B::and"}, 6},
+		{"B:: and", &Token{Word, "B"}, 1},
+		{"B::++ x", &Token{Operator, "B::++"}, 5},
+		{"B::*", &Token{Operator, "B::*"}, 4},
+		{"nil", &Token{KeywordNil, "nil"}, 3},
+		{"cons", &Token{KeywordCons, "cons"}, 4},
+		{"isnil", &Token{KeywordIsNil, "isnil"}, 5},
+		{"head", &Token{KeywordHead, "head"}, 4},
+		{"tail", &Token{KeywordTail, "tail"}, 4},
+		{"heads", &Token{Word, "heads"}, 5},
+		{`"a\"b\\c\nd\te"`, &Token{String, "a\"b\\c\nd\te"}, 15},
+		{`"日本" x`, &Token{String, "日本"}, 8},
+		{"concat", &Token{KeywordConcat, "concat"}, 6},
+		{"strlen", &Token{KeywordStrLen, "strlen"}, 6},
+		{"streq", &Token{KeywordStrEq, "streq"}, 5},
+		{"callcc", &Token{KeywordCallCC, "callcc"}, 6},
+		{"abort", &Token{KeywordAbort, "abort"}, 5},
+		{"42", &Token{Number, "42"}, 2},
+		{"0i", &Token{Number, "0i"}, 2},
+		{"0in", &Token{Number, "0"}, 1},
+		{"0)", &Token{Number, "0"}, 1},
+		{"+", &Token{Operator, "+"}, 1},
+		{"-1", &Token{Operator, "-"}, 1},
+		{"/", &Token{Operator, "/"}, 1},
+		{"< 1", &Token{Operator, "<"}, 1},
+		{"==", &Token{Operator, "=="}, 2},
+		{"++ x", &Token{Operator, "++"}, 2},
+		{"<:", &Token{Subtype, "<:"}, 2},
+		{"=>*", &Token{FatArrow, "=>"}, 2},
+		{"*=>", &Token{Star, "*"}, 1},
+		{"infixl", &Token{KeywordInfixl, "infixl"}, 6},
+		{"infixr", &Token{KeywordInfixr, "infixr"}, 6},
+		{"infix", &Token{KeywordInfix, "infix"}, 5},
+		{"match", &Token{KeywordMatch, "match"}, 5},
+		{"with", &Token{KeywordWith, "with"}, 4},
+		{"| 0", &Token{Bar, "|"}, 1},
+		{"||", &Token{Operator, "||"}, 2},
 	}
 	for i, v := range testcases {
 		l := NewLexer(v.src)
@@ -63,4 +127,11 @@ func TestNextToken(t *testing.T) {
 			t.Errorf("err should be ErrUnknownToken but got %v", err)
 		}
 	}
+	// invalid strings
+	for _, src := range []string{`"a.tl`, `"a\"`, `"a\`, `"\q"`} {
+		l := NewLexer(src)
+		if _, err := l.NextToken(); err == nil {
+			t.Errorf("next token should return with an error for %s", src)
+		}
+	}
 }
@@ -0,0 +1,373 @@
+package gtl
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// occurrence is a path from the matched value to a part of it. each index selects a child of a value.
+type occurrence []int
+
+func (o occurrence) child(i int) occurrence {
+	return append(append(occurrence{}, o...), i)
+}
+
+func (o occurrence) equal(other occurrence) bool {
+	if len(o) != len(other) {
+		return false
+	}
+	for i := range o {
+		if o[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// valueAt returns the part of a value at an occurrence.
+func valueAt(v *Node, o occurrence) *Node {
+	for _, i := range o {
+		v = v.Children[i]
+	}
+	return v
+}
+
+// constructor is the head of a pattern which is not a variable.
+type constructor struct {
+	nodeType NodeType // Zero, Succ, True, False, NodeNumber, Nil, Cons, Tuple or Variant
+	name     string   // the label of Variant, or the decimal representation of NodeNumber
+	arity    int
+}
+
+func constructorOf(p *Node) constructor {
+	switch p.NodeType {
+	case NodeNumber:
+		return constructor{nodeType: NodeNumber, name: p.Name}
+	case Variant:
+		return constructor{nodeType: Variant, name: p.Name, arity: 1}
+	}
+	return constructor{nodeType: p.NodeType, arity: len(p.Children)}
+}
+
+// isConstructorValue returns whether the head of a value can be compared with constructors.
+func isConstructorValue(v *Node) bool {
+	switch v.NodeType {
+	case True, False, Zero, NodeNumber, Nil, Tuple:
+		return true
+	case Succ:
+		return v.IsNumericalValue()
+	case Variant, Cons:
+		return len(v.Children) == 2
+	}
+	return false
+}
+
+// matches returns whether a value which satisfies isConstructorValue is built with c.
+// 0 and integer literals match both Nat values and machine integers.
+func (c constructor) matches(v *Node) bool {
+	switch c.nodeType {
+	case Zero:
+		i, ok := intOf(v)
+		return ok && i == 0
+	case NodeNumber:
+		i, ok := intOf(v)
+		return ok && strconv.FormatInt(i, 10) == c.name
+	case Succ:
+		return v.NodeType == Succ
+	case Tuple:
+		return v.NodeType == Tuple && len(v.Children) == c.arity
+	case Variant:
+		return v.NodeType == Variant && v.Name == c.name
+	}
+	return v.NodeType == c.nodeType
+}
+
+// pattern returns a pattern of c whose arguments are args.
+func (c constructor) pattern(args []*Node) *Node {
+	return &Node{NodeType: c.nodeType, Name: c.name, Children: args}
+}
+
+// patternVariables returns names bound by a pattern, or an error if a name is bound twice.
+func patternVariables(p *Node) ([]string, error) {
+	var ret []string
+	if p.NodeType == PatternVariable {
+		if p.Name == "_" {
+			return nil, nil
+		}
+		return []string{p.Name}, nil
+	}
+	for _, c := range p.Children {
+		vars, err := patternVariables(c)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range vars {
+			for _, w := range ret {
+				if v == w {
+					return nil, fmt.Errorf("variable %s is bound twice in pattern %s", v, p)
+				}
+			}
+			ret = append(ret, v)
+		}
+	}
+	return ret, nil
+}
+
+type binding struct {
+	name       string
+	occurrence occurrence
+}
+
+// decisionTree is a compiled match, which tests each part of a value at most once.
+// A leaf has no cases, and its arm is the index of the selected case or -1 if no case matches.
+type decisionTree struct {
+	arm      int
+	bindings []binding // for the selected case
+
+	occurrence occurrence
+	cases      []decisionCase
+	fallback   *decisionTree // for constructors which are not in cases. nil if cases cover every value
+}
+
+type decisionCase struct {
+	constructor constructor
+	tree        *decisionTree
+}
+
+// clause is a row of a pattern matrix. its patterns are tested against the occurrences of a matchCompiler.
+type clause struct {
+	patterns []*Node
+	arm      int
+	bindings []binding
+}
+
+// assumption is a constructor which a part of the value is known to be built with, on a path of a decision tree.
+type assumption struct {
+	occurrence  occurrence
+	constructor constructor
+}
+
+// matchCompiler compiles a match into a decision tree.
+// if env is nil, types are unknown as in evaluation, so variants are never regarded as covered.
+type matchCompiler struct {
+	env *typeEnvironment
+
+	missing []*Node      // values which no case matches, as patterns
+	used    map[int]bool // indices of cases which some values select
+}
+
+// compileMatch returns a decision tree of a Match node whose matched value has type ty, which may be nil.
+func compileMatch(n *Node, ty *Node, env *typeEnvironment) (*decisionTree, *matchCompiler) {
+	mc := &matchCompiler{env: env, used: make(map[int]bool)}
+	var clauses []clause
+	for i, c := range n.Children[1:] {
+		clauses = append(clauses, clause{patterns: []*Node{c.Children[0]}, arm: i})
+	}
+	tree := mc.compile(clauses, []occurrence{{}}, []*Node{ty}, nil)
+	return tree, mc
+}
+
+// compile is the classic compilation of a pattern matrix.
+// the first clause whose patterns are all variables is selected, otherwise a column where it has a constructor is tested.
+func (mc *matchCompiler) compile(clauses []clause, occs []occurrence, types []*Node, assumptions []assumption) *decisionTree {
+	if len(clauses) == 0 {
+		mc.missing = append(mc.missing, witness(occurrence{}, assumptions))
+		return &decisionTree{arm: -1}
+	}
+	first := clauses[0]
+	col := -1
+	for i, p := range first.patterns {
+		if p.NodeType != PatternVariable {
+			col = i
+			break
+		}
+	}
+	if col < 0 {
+		bindings := first.bindings
+		for i, p := range first.patterns {
+			if p.Name != "_" {
+				bindings = append(bindings, binding{p.Name, occs[i]})
+			}
+		}
+		mc.used[first.arm] = true
+		return &decisionTree{arm: first.arm, bindings: bindings}
+	}
+
+	var ctors []constructor
+	for _, c := range clauses {
+		if p := c.patterns[col]; p.NodeType != PatternVariable && !containsConstructor(ctors, constructorOf(p)) {
+			ctors = append(ctors, constructorOf(p))
+		}
+	}
+	occ, ty := occs[col], mc.expose(types[col])
+	restOccs := append(append([]occurrence{}, occs[:col]...), occs[col+1:]...)
+	restTypes := append(append([]*Node{}, types[:col]...), types[col+1:]...)
+	ret := &decisionTree{occurrence: occ}
+	for _, ctor := range ctors {
+		var rows []clause
+		for _, c := range clauses {
+			if row, ok := specialize(c, col, ctor, occ); ok {
+				rows = append(rows, row)
+			}
+		}
+		subOccs := make([]occurrence, ctor.arity)
+		for i := range subOccs {
+			subOccs[i] = occ.child(i)
+		}
+		subTypes := mc.argumentTypes(ctor, ty)
+		tree := mc.compile(rows, append(subOccs, restOccs...), append(subTypes, restTypes...), append(assumptions, assumption{occ, ctor}))
+		ret.cases = append(ret.cases, decisionCase{ctor, tree})
+	}
+	if missing, ok := mc.missingConstructor(ctors, ty); ok {
+		var rows []clause
+		for _, c := range clauses {
+			if p := c.patterns[col]; p.NodeType == PatternVariable {
+				row := clause{arm: c.arm, bindings: c.bindings}
+				if p.Name != "_" {
+					row.bindings = append(append([]binding{}, c.bindings...), binding{p.Name, occ})
+				}
+				row.patterns = append(append([]*Node{}, c.patterns[:col]...), c.patterns[col+1:]...)
+				rows = append(rows, row)
+			}
+		}
+		ret.fallback = mc.compile(rows, restOccs, restTypes, append(assumptions, assumption{occ, missing}))
+	}
+	return ret
+}
+
+// specialize returns a clause for values built with ctor, whose column is replaced with the arguments of ctor.
+func specialize(c clause, col int, ctor constructor, occ occurrence) (clause, bool) {
+	p := c.patterns[col]
+	row := clause{arm: c.arm, bindings: c.bindings}
+	var args []*Node
+	switch {
+	case p.NodeType == PatternVariable:
+		if p.Name != "_" {
+			row.bindings = append(append([]binding{}, c.bindings...), binding{p.Name, occ})
+		}
+		for i := 0; i < ctor.arity; i++ {
+			args = append(args, &Node{NodeType: PatternVariable, Name: "_"})
+		}
+	case constructorOf(p) == ctor:
+		args = p.Children
+	default:
+		return row, false
+	}
+	row.patterns = append(append(args, c.patterns[:col]...), c.patterns[col+1:]...)
+	return row, true
+}
+
+func containsConstructor(ctors []constructor, c constructor) bool {
+	for _, d := range ctors {
+		if d == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (mc *matchCompiler) expose(ty *Node) *Node {
+	if ty == nil || mc.env == nil {
+		return ty
+	}
+	return mc.env.expose(ty)
+}
+
+// argumentTypes returns types of the arguments of ctor for a value of type ty. they are nil if unknown.
+func (mc *matchCompiler) argumentTypes(ctor constructor, ty *Node) []*Node {
+	ret := make([]*Node, ctor.arity)
+	if ty == nil {
+		return ret
+	}
+	switch {
+	case ctor.nodeType == Succ:
+		ret[0] = &Node{NodeType: TypeNat}
+	case ctor.nodeType == Cons && ty.NodeType == TypeList:
+		ret[0], ret[1] = ty.Children[0], ty
+	case ctor.nodeType == Tuple && ty.NodeType == TypeTuple && len(ty.Children) == ctor.arity:
+		copy(ret, ty.Children)
+	case ctor.nodeType == Variant && ty.NodeType == TypeVariant:
+		if f := variantField(ty, ctor.name); f != nil {
+			ret[0] = f
+		}
+	}
+	return ret
+}
+
+// missingConstructor returns a constructor which is not in ctors, or false if ctors cover every value of type ty.
+// integers are never covered, and variants are covered only if ty is known.
+func (mc *matchCompiler) missingConstructor(ctors []constructor, ty *Node) (constructor, bool) {
+	var all []constructor
+	switch ctors[0].nodeType {
+	case True, False:
+		all = []constructor{{nodeType: True}, {nodeType: False}}
+	case Zero, Succ:
+		all = []constructor{{nodeType: Zero}, {nodeType: Succ, arity: 1}}
+	case Nil, Cons:
+		all = []constructor{{nodeType: Nil}, {nodeType: Cons, arity: 2}}
+	case Tuple:
+		return constructor{}, false
+	case Variant:
+		if ty == nil || ty.NodeType != TypeVariant {
+			return constructor{nodeType: Variant, arity: 1}, true
+		}
+		for _, f := range ty.Children {
+			all = append(all, constructor{nodeType: Variant, name: f.Name, arity: 1})
+		}
+	}
+	for _, c := range ctors {
+		if c.nodeType == NodeNumber {
+			all = nil // machine integers
+			break
+		}
+	}
+	if all == nil {
+		return constructor{nodeType: NodeNumber, name: missingInteger(ctors)}, true
+	}
+	for _, c := range all {
+		if !containsConstructor(ctors, c) {
+			return c, true
+		}
+	}
+	return constructor{}, false
+}
+
+// missingInteger returns the smallest non-negative integer which is not in ctors.
+func missingInteger(ctors []constructor) string {
+	for i := 0; ; i++ {
+		s := strconv.Itoa(i)
+		if !containsConstructor(ctors, constructor{nodeType: NodeNumber, name: s}) && (i != 0 || !containsConstructor(ctors, constructor{nodeType: Zero})) {
+			return s
+		}
+	}
+}
+
+// witness returns a pattern of the values which satisfy assumptions at an occurrence.
+func witness(occ occurrence, assumptions []assumption) *Node {
+	for _, a := range assumptions {
+		if !a.occurrence.equal(occ) {
+			continue
+		}
+		args := make([]*Node, a.constructor.arity)
+		for i := range args {
+			args[i] = witness(occ.child(i), assumptions)
+		}
+		return a.constructor.pattern(args)
+	}
+	return &Node{NodeType: PatternVariable, Name: "_"}
+}
+
+// matchWarnings returns warnings of a match whose matched value has type ty.
+func matchWarnings(n *Node, ty *Node, env *typeEnvironment) []string {
+	var ret []string
+	_, mc := compileMatch(n, ty, env)
+	if len(mc.missing) != 0 {
+		ret = append(ret, fmt.Sprintf("match on %s is not exhaustive: %s is not matched", n.Children[0], mc.missing[0]))
+	}
+	for i, c := range n.Children[1:] {
+		if !mc.used[i] {
+			ret = append(ret, fmt.Sprintf("match on %s has a redundant case: %s", n.Children[0], c.Children[0]))
+		}
+	}
+	return ret
+}
@@ -0,0 +1,152 @@
+package gtl
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_parseMatch(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"match x with | 0 -> 1 | succ n -> n", "match x with | 0 -> (1) | succ (n) -> (n)"},
+		{"match x with | {a, _} -> a", "match x with | {a, _} -> (a)"},
+		{"match x with | <some = (succ n)> -> n | <none = _> -> 0", "match x with | <some = succ (n)> -> (n) | <none = _> -> (0)"},
+		{"match l with | [] -> 0 | [a] -> a | cons a (cons b _) -> a + b", "match l with | nil -> (0) | [a] -> (a) | cons a (cons b _) -> ((a + b))"},
+		{"match x with | true -> .y -> y | false -> (match y with | _ -> 0)", "match x with | true -> (.y -> (y)) | false -> (match y with | _ -> (0))"},
+	}
+	for i, v := range testcases {
+		ast := buildASTFromString(v.src)
+		if got := ast.Child.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+	ast := buildASTFromString("match x with | {a, b} -> a b c")
+	body := ast.Child.Children[1].Children[1]
+	if want, got := Variable, body.Children[0].Children[1].NodeType; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	if want, got := FreeVariable, body.Children[1].NodeType; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	for i, src := range []string{
+		"match x with",
+		"match x | 0 -> 0",
+		"match x with | 0 0",
+		"match x with | {a, a} -> a",
+		"match x with | <a = 0 -> 0",
+		"match x with | if -> 0",
+	} {
+		tokens, err := Tokenize(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Parse(tokens); err == nil {
+			t.Errorf("case %d: %q should not be parsed", i, src)
+		}
+	}
+}
+
+func Test_evalMatch(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"match succ (succ 0) with | 0 -> true | succ 0 -> false | succ (succ n) -> n", "0"},
+		{"def plus = .m .n -> match m with | 0 -> n | succ k -> succ (plus k n); plus (succ 0) (succ 0)", "succ (succ (0))"},
+		{"match 42 with | 0 -> 0 | 42 -> 1 | _ -> 2", "1"},
+		{"match 1 - 1 with | 0 -> 0 | _ -> 2", "0"},
+		{"match {true, {0, 1}} with | {false, _} -> 0 | {true, {x, y}} -> y", "1"},
+		{"match <b = 3> as <a: Nat, b: Int> with | <a = _> -> 0 | <b = n> -> n * 2", "6"},
+		{"def sum = .l -> match l with | [] -> 0 | cons x rest -> x + sum rest; sum [1, 2, 3]", "6"},
+		{"match [1, 2] with | [x] -> x | [x, y] -> y | _ -> 0", "2"},
+		{"(.x -> match x with | x -> x) 0", "0"},
+		{"match x with | 0 -> 0", "match x with | 0 -> (0)"},
+	}
+	for i, v := range testcases {
+		n, err := Eval(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := n.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+	if _, err := Eval(buildASTFromString("match succ 0 with | 0 -> 0")); err == nil {
+		t.Error("a value which no case matches should be an error")
+	}
+}
+
+func TestTypecheck_match(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"def plus : Nat -> Nat -> Nat = .m:Nat .n:Nat -> match m with | 0 -> n | succ k -> succ (plus k n); plus", "Nat -> Nat -> Nat"},
+		{".p:{Nat, Bool} -> match p with | {n, true} -> n | {_, false} -> 0", "{Nat, Bool} -> Nat"},
+		{".x:<none: Top, some: Nat> -> match x with | <none = _> -> 0 | <some = n> -> n", "<none: Top, some: Nat> -> Nat"},
+		{".l:(List Int) -> match l with | [] -> 0 | cons x _ -> x", "List Int -> Int"},
+		{".b:Bool -> match b with | true -> 0 | false -> 1", "Bool -> Int"},
+	}
+	for i, v := range testcases {
+		ty, warnings, err := TypecheckWarnings(buildASTFromString(v.src), KernelSubtyping)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := ty.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+		if len(warnings) != 0 {
+			t.Errorf("case %d: unexpected warnings %v", i, warnings)
+		}
+	}
+	for i, src := range []string{
+		".x:Nat -> match x with | true -> 0",
+		".x:Int -> match x with | succ n -> 0",
+		".x:{Nat, Nat} -> match x with | {a, b, c} -> 0",
+		".x:<a: Nat> -> match x with | <b = _> -> 0",
+		".x:Nat -> match x with | 0 -> 0 | succ n -> n true",
+		".b:Bool -> match b with | true -> 0 | false -> false",
+	} {
+		if _, err := Typecheck(buildASTFromString(src)); err == nil {
+			t.Errorf("case %d: %s should be ill-typed", i, src)
+		}
+	}
+}
+
+func TestTypecheckWarnings(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want []string
+	}{
+		{".x:Nat -> match x with | 0 -> 0", []string{"not exhaustive: succ (_) is not matched"}},
+		{".x:Nat -> match x with | 0 -> 0 | succ 0 -> 1", []string{"not exhaustive: succ (succ (_)) is not matched"}},
+		{".x:Int -> match x with | 0 -> 0 | 1 -> 1", []string{"not exhaustive: 2 is not matched"}},
+		{".x:{Bool, Bool} -> match x with | {true, _} -> 0 | {_, true} -> 1", []string{"not exhaustive: {false, false} is not matched"}},
+		{".x:<a: Nat, b: Nat> -> match x with | <a = _> -> 0", []string{"not exhaustive: <b = _> is not matched"}},
+		{".l:(List Bool) -> match l with | [] -> 0 | [true] -> 1 | cons false _ -> 2", []string{"not exhaustive: cons true (cons _ _) is not matched"}},
+		{".x:Nat -> match x with | _ -> 0 | 0 -> 1", []string{"redundant case: 0"}},
+		{".x:Bool -> match x with | true -> 0 | false -> 1 | true -> 2", []string{"redundant case: true"}},
+		{".x:{Bool, Nat} -> match x with | {_, 0} -> 0 | {b, succ _} -> 1 | {true, 0} -> 2", []string{"redundant case: {true, 0}"}},
+		{".x:Nat -> match x with | succ _ -> 0 | succ 0 -> 1", []string{"not exhaustive: 0 is not matched", "redundant case: succ (0)"}},
+	}
+	for i, v := range testcases {
+		_, warnings, err := TypecheckWarnings(buildASTFromString(v.src), KernelSubtyping)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if len(warnings) != len(v.want) {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, warnings)
+			continue
+		}
+		for j, w := range v.want {
+			if !strings.Contains(warnings[j], w) {
+				t.Errorf("case %d: want %v but got %v\n", i, w, warnings[j])
+			}
+		}
+	}
+}
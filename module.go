@@ -0,0 +1,316 @@
+package gtl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Tokenize returns all tokens of source, which ends with EOF.
+func Tokenize(source string) ([]*Token, error) {
+	l := NewLexer(source)
+	var tokens []*Token
+	for l.HasNext() {
+		token, err := l.NextToken()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// LoadFile returns an AST of a source file whose imports are resolved.
+// Imported modules are loaded relative to the importing file, and their definitions are placed before
+// the definitions of the importing file with qualified names such as "bool::and".
+// A module can be imported with an alias by `import "bool.tl" as B;`, then its names are referred as B::and.
+// If a module has export declarations, only the exported names can be referred by importers.
+func LoadFile(filename string) (*AST, error) {
+	return LoadFileWith(filename, LoadOptions{})
+}
+
+// LoadOptions changes how LoadFileWith loads a file.
+type LoadOptions struct {
+	// Prelude is the source of definitions which are in scope of every file as if it were imported without an alias.
+	// Prelude and ChurchPrelude are untyped, so a program loaded with them cannot be typechecked. That is why
+	// the typed backends tl-gogen and tl-wat load a file with LoadFile, which has no prelude.
+	Prelude string
+	// Pure rejects primitives such as true and succ in files other than the prelude.
+	Pure bool
+}
+
+// LoadFileWith is LoadFile with options.
+func LoadFileWith(filename string, opts LoadOptions) (*AST, error) {
+	l := newLoader()
+	l.pure = opts.Pure
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Prelude != "" {
+		if err := l.loadPrelude(opts.Prelude); err != nil {
+			return nil, err
+		}
+	}
+	m, main, err := l.load(path, "")
+	if err != nil {
+		return nil, err
+	}
+	if main == nil {
+		return nil, fmt.Errorf("%s has no main expression", filename)
+	}
+	if len(m.exports) != 0 {
+		return nil, fmt.Errorf("%s: export is allowed only in imported modules", filename)
+	}
+	return &AST{Declarations: l.decls, Child: main}, nil
+}
+
+type module struct {
+	// names which can be referred by importers, from their original name to the qualified name
+	terms   map[string]string
+	types   map[string]string
+	exports []string
+}
+
+type loader struct {
+	modules map[string]*module // by absolute path
+	keys    map[string]bool    // qualifiers which are already used
+	loading []string           // for detecting import cycles
+	prelude *module            // imported by every file but the prelude itself
+	pure    bool               // whether primitives are rejected
+
+	decls []*Node // linked declarations
+}
+
+func newLoader() *loader {
+	return &loader{modules: make(map[string]*module), keys: make(map[string]bool)}
+}
+
+func (l *loader) loadPrelude(source string) error {
+	decls, _, err := parseSource("prelude", source)
+	if err != nil {
+		return err
+	}
+	p, _, err := l.link("prelude", decls, nil, l.newKey("prelude"), nil)
+	if err != nil {
+		return err
+	}
+	l.prelude = p
+	return nil
+}
+
+// load links declarations of a file into l.decls and returns its main expression.
+// names defined in the file are qualified with key unless key is empty.
+func (l *loader) load(path string, key string) (*module, *Node, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	decls, main, err := parseSource(path, string(b))
+	if err != nil {
+		return nil, nil, err
+	}
+	if l.pure {
+		if err := checkPureProgram(decls, main); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return l.link(path, decls, main, key, l.prelude)
+}
+
+func parseSource(path string, source string) ([]*Node, *Node, error) {
+	tokens, err := Tokenize(source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	decls, main, err := parseProgram(tokens)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return decls, main, nil
+}
+
+// link is load with a parsed file.
+// if prelude is not nil, its names can be referred without a qualifier.
+func (l *loader) link(path string, decls []*Node, main *Node, key string, prelude *module) (*module, *Node, error) {
+	l.loading = append(l.loading, path)
+	defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+
+	m := &module{terms: make(map[string]string), types: make(map[string]string)}
+	// names which can be referred in this file
+	terms := make(map[string]string)
+	types := make(map[string]string)
+	if prelude != nil {
+		for k, v := range prelude.terms {
+			terms[k] = v
+		}
+		for k, v := range prelude.types {
+			types[k] = v
+		}
+	}
+	// every definition can be referred before it, for recursive definitions and forward references
+	local := make(map[string]string)
+	for _, d := range decls {
+		if d.NodeType == Definition {
+			local[d.Name] = qualify(key, d.Name)
+			terms[d.Name] = local[d.Name]
+		}
+	}
+	for _, d := range decls {
+		switch d.NodeType {
+		case Import:
+			imported, err := l.importModule(filepath.Join(filepath.Dir(path), d.Children[0].Name))
+			if err != nil {
+				return nil, nil, err
+			}
+			prefix := ""
+			if d.Name != "" {
+				prefix = d.Name + "::"
+			}
+			for k, v := range imported.terms {
+				if _, ok := local[prefix+k]; !ok { // definitions of this file shadow imported names
+					terms[prefix+k] = v
+				}
+			}
+			for k, v := range imported.types {
+				types[prefix+k] = v
+			}
+		case Definition:
+			qualified := local[d.Name]
+			linked := renameReferences(d, terms, types)
+			linked.Name = qualified
+			l.decls = append(l.decls, linked)
+			m.terms[d.Name] = qualified
+		case TypeDefinition:
+			qualified := qualify(key, d.Name)
+			linked := renameReferences(d, terms, types)
+			linked.Name = qualified
+			types[d.Name] = qualified
+			l.decls = append(l.decls, linked)
+			m.types[d.Name] = qualified
+		case Export:
+			m.exports = append(m.exports, d.Name)
+		}
+	}
+	if len(m.exports) != 0 {
+		exportedTerms := make(map[string]string)
+		exportedTypes := make(map[string]string)
+		for _, name := range m.exports {
+			term, isTerm := m.terms[name]
+			ty, isType := m.types[name]
+			if !isTerm && !isType {
+				return nil, nil, fmt.Errorf("%s: cannot export unknown name %s", path, name)
+			}
+			if isTerm {
+				exportedTerms[name] = term
+			}
+			if isType {
+				exportedTypes[name] = ty
+			}
+		}
+		m.terms, m.types = exportedTerms, exportedTypes
+	}
+	if main != nil {
+		main = renameReferences(main, terms, types)
+	}
+	return m, main, nil
+}
+
+func (l *loader) importModule(path string) (*module, error) {
+	for i, p := range l.loading {
+		if p == path {
+			cycle := append(l.loading[i:], path)
+			return nil, fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if m, ok := l.modules[path]; ok {
+		return m, nil
+	}
+	m, _, err := l.load(path, l.newKey(path))
+	if err != nil {
+		return nil, err
+	}
+	l.modules[path] = m
+	return m, nil
+}
+
+// newKey returns a unique qualifier from the base name of a module.
+func (l *loader) newKey(path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	key := base
+	for i := 2; l.keys[key]; i++ {
+		key = fmt.Sprintf("%s%d", base, i)
+	}
+	l.keys[key] = true
+	return key
+}
+
+func qualify(key, name string) string {
+	if key == "" {
+		return name
+	}
+	return key + "::" + name
+}
+
+// renameReferences replaces variables and type variables which refer to top-level names.
+// names bound by lambdas or type binders are left as is.
+func renameReferences(n *Node, terms, types map[string]string) *Node {
+	switch n.NodeType {
+	case Variable, FreeVariable:
+		if name, ok := terms[n.Name]; ok {
+			return &Node{NodeType: Variable, Name: name}
+		}
+		return n
+	case TypeVariable:
+		if name, ok := types[n.Name]; ok {
+			return &Node{NodeType: TypeVariable, Name: name}
+		}
+		return n
+	case Lambda:
+		def := renameReferences(n.Children[0], terms, types) // for type annotations
+		inner := terms
+		for _, p := range def.Children {
+			inner = without(inner, p.Name)
+		}
+		body := renameReferences(n.Children[1], inner, types)
+		return &Node{NodeType: Lambda, Children: []*Node{def, body}}
+	case MatchCase:
+		vars, _ := patternVariables(n.Children[0])
+		inner := terms
+		for _, v := range vars {
+			inner = without(inner, v)
+		}
+		return &Node{NodeType: MatchCase, Children: []*Node{n.Children[0], renameReferences(n.Children[1], inner, types)}}
+	case Unpack:
+		bound := renameReferences(n.Children[1], terms, types)
+		body := renameReferences(n.Children[2], without(terms, n.Children[0].Name), without(types, n.Name))
+		return &Node{NodeType: Unpack, Name: n.Name, Children: []*Node{n.Children[0], bound, body}}
+	case TypeAll, TypeAbstraction, TypeSome, OperatorAbstraction:
+		body := renameReferences(n.Children[0], terms, without(types, n.Name))
+		bound := renameReferences(n.Children[1], terms, types)
+		return &Node{NodeType: n.NodeType, Name: n.Name, Children: []*Node{body, bound}}
+	}
+	if len(n.Children) == 0 {
+		return n
+	}
+	ret := &Node{NodeType: n.NodeType, Name: n.Name, Children: make([]*Node, len(n.Children))}
+	for i, c := range n.Children {
+		ret.Children[i] = renameReferences(c, terms, types)
+	}
+	return ret
+}
+
+// without returns a copy of m which does not have key.
+func without(m map[string]string, key string) map[string]string {
+	if _, ok := m[key]; !ok {
+		return m
+	}
+	ret := make(map[string]string, len(m))
+	for k, v := range m {
+		if k != key {
+			ret[k] = v
+		}
+	}
+	return ret
+}
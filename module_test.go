@@ -0,0 +1,117 @@
+package gtl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadFile(t *testing.T) {
+	testcases := []struct {
+		filename string
+		want     string
+	}{
+		{"testdata/module/qualified.tl", "true"},
+		{"testdata/module/unqualified.tl", "true"},
+		{"testdata/module/shadow.tl", "true"},
+		{"testdata/module/even.tl", "true"},
+		{"testdata/module/operator.tl", "{3, 3}"},
+		{"testdata/module/forward.tl", "succ (0)"},
+	}
+	for _, v := range testcases {
+		ast, err := LoadFile(v.filename)
+		if err != nil {
+			t.Errorf("%s: %v", v.filename, err)
+			continue
+		}
+		n, err := Eval(ast)
+		if err != nil {
+			t.Errorf("%s: %v", v.filename, err)
+			continue
+		}
+		if want, got := v.want, n.String(); got != want {
+			t.Errorf("%s: want %v but got %v\n", v.filename, want, got)
+		}
+	}
+}
+
+func TestLoadFile_declarations(t *testing.T) {
+	ast, err := LoadFile("testdata/module/unqualified.tl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range ast.Declarations {
+		got = append(got, d.Name)
+	}
+	// bool.tl is loaded only once though it is imported twice
+	want := "bool::not bool::and bool::Pred nat::iseven nat::isodd nat::helper"
+	if got := strings.Join(got, " "); got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	// and (not false) (iszero 0)
+	and := ast.Child.Children[0].Children[0]
+	not := ast.Child.Children[0].Children[1].Children[0]
+	if want, got := "bool::and", and.Name; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	if want, got := "bool::not", not.Name; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+}
+
+func TestLoadFile_typecheck(t *testing.T) {
+	ast, err := LoadFile("testdata/module/even.tl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ty, err := Typecheck(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "Bool", ty.String(); got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+
+	// a definition of a module refers to a later definition
+	ast, err = LoadFile("testdata/module/forward.tl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ty, err = Typecheck(ast); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "Nat", ty.String(); got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+
+	// helper is not exported
+	ast, err = LoadFile("testdata/module/private.tl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Typecheck(ast); err == nil || !strings.Contains(err.Error(), "unbound variable helper") {
+		t.Errorf("want an unbound variable error but got %v", err)
+	}
+}
+
+func TestLoadFile_error(t *testing.T) {
+	testcases := []struct {
+		filename string
+		want     string
+	}{
+		{"testdata/module/cycle_a.tl", "import cycle"},
+		{"testdata/module/badexport.tl", "cannot export unknown name y"},
+		{"testdata/module/bool.tl", "no main expression"},
+		{"testdata/module/no_such_file.tl", "no_such_file.tl"},
+	}
+	for _, v := range testcases {
+		_, err := LoadFile(v.filename)
+		if err == nil {
+			t.Errorf("%s: should return an error", v.filename)
+			continue
+		}
+		if !strings.Contains(err.Error(), v.want) {
+			t.Errorf("%s: want an error containing %q but got %v", v.filename, v.want, err)
+		}
+	}
+}
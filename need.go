@@ -0,0 +1,315 @@
+package gtl
+
+import (
+	"fmt"
+)
+
+// ThunkStats counts the thunks of call-by-need evaluation.
+type ThunkStats struct {
+	Created int // delayed arguments, fields and definitions
+	Forced  int // thunks which are evaluated. a thunk is evaluated at most once
+}
+
+// EvalNeed evaluates a program with call-by-need.
+func EvalNeed(ast *AST) (*Node, error) {
+	n, _, err := EvalNeedStats(ast)
+	return n, err
+}
+
+// EvalNeedStats is an alternative to Eval, which evaluates a program with call-by-need.
+// An argument of a function, a field of a constructor or a definition is delayed into a thunk, which
+// is evaluated when its value is needed for the first time. Variables bound to a thunk share it, so the
+// thunk is evaluated at most once however many times it is used. A term whose value is never needed
+// is not evaluated, so infinite lists such as `def ones = cons 1 ones` can be used like streams.
+// Lambdas, variables and constants are not delayed because they are values already.
+// Finally the result is evaluated completely, and read back into a term like EvalCEK does.
+// The result is the same as the result of Eval unless Eval fails or does not terminate.
+func EvalNeedStats(ast *AST) (*Node, ThunkStats, error) {
+	m := &needMachine{globals: make(map[string]value)}
+	for _, d := range ast.Declarations {
+		if d.NodeType != Definition { // types have no runtime meaning
+			continue
+		}
+		m.globals[d.Name] = m.delay(d.Children[0], nil)
+	}
+	v, err := m.eval(ast.Child, nil)
+	if err != nil {
+		return nil, m.stats, err
+	}
+	if v, err = m.value(v); err != nil {
+		return nil, m.stats, err
+	}
+	return v.readback(), m.stats, nil
+}
+
+// thunk is a term which is evaluated when it is forced, and keeps its value after that.
+type thunk struct {
+	term    *Node
+	env     *machineEnv
+	value   value
+	forcing bool // true while it is evaluated, to detect a thunk which needs its own value
+}
+
+func (t *thunk) readback() *Node {
+	if t.value != nil {
+		return t.value.readback()
+	}
+	return t.env.substitute(t.term)
+}
+
+type needMachine struct {
+	globals map[string]value
+	stats   ThunkStats
+}
+
+// delay returns a thunk of n, or its value if n is a value already.
+func (m *needMachine) delay(n *Node, env *machineEnv) value {
+	switch n.NodeType {
+	case Lambda, TypeAbstraction:
+		return &closure{n, env}
+	case Variable: // shares the thunk
+		if v, ok := env.lookup(n.Name); ok {
+			return v
+		}
+		if v, ok := m.globals[n.Name]; ok {
+			return v
+		}
+		return atom{n}
+	case Cons:
+		return &data{node: n}
+	case True, False, Zero, FreeVariable, NodeNumber, IsZero, Succ, Pred, Nil, IsNil, Head, Tail, StringLiteral, Concat, StrLen, StrEq, BinaryOperator, CallCC, Abort:
+		return atom{n}
+	}
+	m.stats.Created++
+	return &thunk{term: n, env: env}
+}
+
+// force returns the value of v, which is evaluated if v is a thunk which is not forced yet.
+// the value is not a thunk, but its fields may be thunks.
+func (m *needMachine) force(v value) (value, error) {
+	t, ok := v.(*thunk)
+	if !ok {
+		return v, nil
+	}
+	if t.value != nil {
+		return t.value, nil
+	}
+	if t.forcing {
+		return nil, fmt.Errorf("%s depends on its own value", t.term)
+	}
+	t.forcing = true
+	ret, err := m.eval(t.term, t.env)
+	t.forcing = false
+	if err != nil {
+		return nil, err
+	}
+	m.stats.Forced++
+	t.value, t.term, t.env = ret, nil, nil
+	return ret, nil
+}
+
+// value forces v and the thunks in its fields, so it is read back like a value of Eval.
+func (m *needMachine) value(v value) (value, error) {
+	v, err := m.force(v)
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := v.(*data); ok {
+		for _, f := range d.fields {
+			if _, err := m.value(f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// eval evaluates a term until its head is a value, i.e. fields of data are left as thunks.
+func (m *needMachine) eval(n *Node, env *machineEnv) (value, error) {
+	switch n.NodeType {
+	case Variable:
+		return m.force(m.delay(n, env))
+	case Apply:
+		fn, err := m.eval(n.Children[0], env)
+		if err != nil {
+			return nil, err
+		}
+		if a, ok := fn.(atom); ok && !isControlPrimitive(a.node) {
+			// a built-in function needs its argument right away, so it is not delayed
+			arg, err := m.eval(n.Children[1], env)
+			if err != nil {
+				return nil, err
+			}
+			return m.apply(fn, arg)
+		}
+		return m.apply(fn, m.delay(n.Children[1], env))
+	case IF:
+		cond, err := m.eval(n.Children[0], env)
+		if err != nil {
+			return nil, err
+		}
+		if a, ok := cond.(atom); ok && a.node.NodeType == True {
+			return m.eval(n.Children[1], env)
+		}
+		if a, ok := cond.(atom); ok && a.node.NodeType == False {
+			return m.eval(n.Children[2], env)
+		}
+		ret := &Node{NodeType: IF, Children: []*Node{cond.readback(), nil, nil}}
+		for i := 1; i < 3; i++ { // the branches of a stuck if are evaluated like Eval does
+			v, err := m.eval(n.Children[i], env)
+			if err != nil {
+				return nil, err
+			}
+			if v, err = m.value(v); err != nil {
+				return nil, err
+			}
+			ret.Children[i] = v.readback()
+		}
+		return atom{ret}, nil
+	case TypeApplication:
+		f, err := m.eval(n.Children[0], env)
+		if err != nil {
+			return nil, err
+		}
+		switch l := f.(type) {
+		case atom:
+			if isListPrimitive(l.node) || isControlPrimitive(l.node) { // nil[T] is nil at runtime
+				return f, nil
+			}
+		case *data:
+			if l.node.NodeType == Cons && len(l.fields) == 0 {
+				return f, nil
+			}
+		case *closure:
+			if l.term.NodeType == TypeAbstraction {
+				return m.eval(substType(l.term.Children[0], l.term.Name, n.Children[1]), l.env)
+			}
+		}
+		return atom{&Node{NodeType: TypeApplication, Children: []*Node{f.readback(), n.Children[1]}}}, nil
+	case Pack:
+		return &data{node: n, fields: []value{m.delay(n.Children[1], env)}}, nil
+	case Variant:
+		return &data{node: n, fields: []value{m.delay(n.Children[0], env)}}, nil
+	case Tuple:
+		fields := make([]value, len(n.Children))
+		for i, c := range n.Children {
+			fields[i] = m.delay(c, env)
+		}
+		return &data{node: n, fields: fields}, nil
+	case Unpack:
+		bound, err := m.eval(n.Children[1], env)
+		if err != nil {
+			return nil, err
+		}
+		param := n.Children[0]
+		if d, ok := bound.(*data); ok && d.node.NodeType == Pack {
+			body := substType(n.Children[2], n.Name, d.node.Children[0])
+			return m.eval(body, env.extend(param.Name, d.fields[0]))
+		}
+		if bound, err = m.value(bound); err != nil {
+			return nil, err
+		}
+		stuck := &Node{NodeType: Unpack, Name: n.Name, Children: []*Node{param, bound.readback(), n.Children[2]}}
+		return atom{env.substitute(stuck)}, nil
+	case Match:
+		v, err := m.eval(n.Children[0], env)
+		if err != nil {
+			return nil, err
+		}
+		return m.match(n, env, v)
+	case Lambda, TypeAbstraction, Cons, True, False, Zero, FreeVariable, NodeNumber, IsZero, Succ, Pred, Nil, IsNil, Head, Tail, StringLiteral, Concat, StrLen, StrEq, BinaryOperator, CallCC, Abort:
+		return m.delay(n, env), nil
+	}
+	return nil, fmt.Errorf("cannot eval: %s", n.NodeType)
+}
+
+// apply applies a function to an argument, which is delayed.
+func (m *needMachine) apply(fn, arg value) (value, error) {
+	var err error
+	switch l := fn.(type) {
+	case *closure:
+		if l.term.NodeType != Lambda {
+			break
+		}
+		def := l.term.Children[0]
+		env := l.env.extend(def.Children[0].Name, arg)
+		if len(def.Children) == 1 {
+			return m.eval(l.term.Children[1].Children[0], env)
+		}
+		rest := &Node{
+			NodeType: Lambda,
+			Children: []*Node{
+				&Node{NodeType: LambdaDef, Children: def.Children[1:]},
+				l.term.Children[1],
+			},
+		}
+		return &closure{rest, env}, nil
+	case *data:
+		if l.node.NodeType == Cons && len(l.fields) < 2 { // the fields of cons are lazy
+			return applyBuiltin(fn, arg)
+		}
+	case atom:
+		if isControlPrimitive(l.node) {
+			return nil, fmt.Errorf("%s is not supported by call-by-need evaluation", l.node)
+		}
+		if l.node.NodeType == IsNil || l.node.NodeType == Head || l.node.NodeType == Tail {
+			// only the first cons cell is needed, so they work on infinite lists
+			if arg, err = m.force(arg); err != nil {
+				return nil, err
+			}
+			v, err := applyBuiltin(fn, arg)
+			if err != nil {
+				return nil, err
+			}
+			return m.force(v)
+		}
+	}
+	// a built-in function needs the value of the argument, and so does a stuck term to show it
+	if arg, err = m.value(arg); err != nil {
+		return nil, err
+	}
+	return applyBuiltin(fn, arg)
+}
+
+// match follows the decision tree of a match like evalMatch. the parts of the value which the tree tests
+// are forced, and the pattern variables are bound to the other parts without forcing them.
+func (m *needMachine) match(n *Node, env *machineEnv, v value) (value, error) {
+	tree, _ := compileMatch(n, nil, nil)
+	leaf, ok, err := selectCaseBy(tree, v, func(o occurrence) (value, error) {
+		p, err := m.part(v, o)
+		if err != nil {
+			return nil, err
+		}
+		return m.force(p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if v, err = m.value(v); err != nil {
+			return nil, err
+		}
+		stuck := &Node{NodeType: Match, Children: append([]*Node{v.readback()}, n.Children[1:]...)}
+		return atom{env.substitute(stuck)}, nil
+	}
+	for _, b := range leaf.bindings {
+		p, err := m.part(v, b.occurrence)
+		if err != nil {
+			return nil, err
+		}
+		env = env.extend(b.name, p)
+	}
+	return m.eval(n.Children[leaf.arm+1].Children[1], env)
+}
+
+// part is partOf which forces the thunks on the way to the part, but not the part itself.
+func (m *needMachine) part(v value, o occurrence) (value, error) {
+	for _, i := range o {
+		var err error
+		if v, err = m.force(v); err != nil {
+			return nil, err
+		}
+		v = partOf(v, occurrence{i})
+	}
+	return v, nil
+}
@@ -0,0 +1,145 @@
+package gtl
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestEvalNeed(t *testing.T) {
+	for i, src := range evalSources {
+		want, err := Eval(buildASTFromString(src))
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		got, err := EvalNeed(buildASTFromString(src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+	}
+}
+
+func TestEvalNeed_prelude(t *testing.T) {
+	for i, src := range []string{
+		"times (succ (succ 0)) (succ (succ (succ 0)))",
+		"equal (succ 0) (succ 0)",
+		"map (plus (succ 0)) [0, succ 0]",
+		"foldr plus 0 [succ 0, succ 0]",
+		"length (append [0] [0, 0])",
+	} {
+		want, err := Eval(buildASTWithPrelude(Prelude, src))
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		got, err := EvalNeed(buildASTWithPrelude(Prelude, src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+	}
+}
+
+func TestEvalNeed_files(t *testing.T) {
+	files, err := filepath.Glob("sample/*.tl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := Eval(buildASTFromString(string(b)))
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		got, err := EvalNeed(buildASTFromString(string(b)))
+		if err != nil {
+			t.Errorf("%s: %v", f, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("%s: want %v but got %v\n", f, want, got)
+		}
+	}
+}
+
+// streamDefinitions are functions on infinite lists.
+const streamDefinitions = `
+def from = .n -> cons n (from (n + 1));
+def take = .n .l -> if n == 0 then nil else cons (head l) (take (n - 1) (tail l));
+def zipWith = .f .l .m -> cons (f (head l) (head m)) (zipWith f (tail l) (tail m));
+def nth = .n .l -> match l with | cons x rest -> if n == 0 then x else nth (n - 1) rest;
+`
+
+func TestEvalNeed_stream(t *testing.T) {
+	for i, v := range []struct {
+		src  string
+		want string
+	}{
+		{"take 3 (from 0)", "[0, 1, 2]"},
+		{"def ones = cons 1 ones; take 2 ones", "[1, 1]"},
+		{"def fibs = cons 0 (cons 1 (zipWith (+) fibs (tail fibs))); take 8 fibs", "[0, 1, 1, 2, 3, 5, 8, 13]"},
+		{"def fibs = cons 0 (cons 1 (zipWith (+) fibs (tail fibs))); nth 60 fibs", "1548008755920"},
+		{"def filter = .f .l -> match l with | cons x rest -> if f x then cons x (filter f rest) else filter f rest; def sieve = .l -> match l with | cons p rest -> cons p (sieve (filter (.n -> if n - n / p * p == 0 then false else true) rest)); take 5 (sieve (from 2))", "[2, 3, 5, 7, 11]"},
+		{"(.x -> 0) (1 / 0)", "0"},
+		{"match {1 / 0, 1} with | {_, y} -> y", "1"},
+	} {
+		want, err := Eval(buildASTFromString(v.want))
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		got, err := EvalNeed(buildASTFromString(streamDefinitions + v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+	}
+}
+
+func TestEvalNeedStats(t *testing.T) {
+	for i, v := range []struct {
+		src  string
+		want ThunkStats
+	}{
+		{"(.x -> x + x + x) (1 + 2)", ThunkStats{Created: 1, Forced: 1}},
+		{"(.x -> {x, x, x}) (1 + 2)", ThunkStats{Created: 1, Forced: 1}},
+		{"(.x .y -> y) (1 + 2) 0", ThunkStats{Created: 1, Forced: 0}},
+		{"(.x -> 0) ((.y -> y + y) (1 + 2))", ThunkStats{Created: 1, Forced: 0}},
+		{"(.x -> x + x) ((.y -> y + y) (1 + 2))", ThunkStats{Created: 2, Forced: 2}},
+		{"def n = 1 + 2; {n, n}", ThunkStats{Created: 1, Forced: 1}},
+		{"head [1 + 2, 3 + 4]", ThunkStats{Created: 2, Forced: 1}},
+	} {
+		_, got, err := EvalNeedStats(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got != v.want {
+			t.Errorf("case %d: want %+v but got %+v\n", i, v.want, got)
+		}
+	}
+}
+
+func TestEvalNeed_error(t *testing.T) {
+	for i, src := range []string{
+		"1 / (1 - 1)",
+		"match succ 0 with | 0 -> 0",
+		"def x = x + 1; x",
+		"callcc [Nat] (.k:(Nat -> Bot) -> 0)",
+	} {
+		if _, err := EvalNeed(buildASTFromString(src)); err == nil {
+			t.Errorf("case %d: no error", i)
+		}
+	}
+}
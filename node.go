@@ -10,7 +10,7 @@ type Node struct {
 	NodeType NodeType
 	Children []*Node
 
-	Name string // for Variable, LambdaParam
+	Name string // for Variable, LambdaParam, TypeAbstraction, TypeVariable, TypeAll, TypeSome, Unpack, OperatorAbstraction, Definition, TypeDefinition, StringLiteral, Import, Export, Variant, VariantField, PatternVariable
 }
 
 func (n *Node) String() string {
@@ -24,30 +24,269 @@ func (n *Node) String() string {
 	case Zero:
 		return "0"
 	case Succ:
+		if len(n.Children) == 1 { // numerical value
+			return fmt.Sprintf("succ (%s)", n.Children[0])
+		}
 		return "succ"
 	case Pred:
 		return "pred"
 	case IsZero:
 		return "iszero"
 	case Variable, FreeVariable:
+		if isOperatorName(n.Name) {
+			return fmt.Sprintf("(%s)", n.Name)
+		}
 		return n.Name
 	case Lambda:
 		return fmt.Sprintf("%s -> (%s)", n.Children[0], n.Children[1])
 	case LambdaDef:
 		var tmp []string
 		for _, p := range n.Children {
-			tmp = append(tmp, fmt.Sprintf(".%s", p.Name))
+			tmp = append(tmp, p.String())
 		}
 		return strings.Join(tmp, " ")
+	case LambdaParam:
+		if len(n.Children) == 0 {
+			return fmt.Sprintf(".%s", n.Name)
+		}
+		return fmt.Sprintf(".%s:%s", n.Name, n.Children[0].atomicTypeString())
 	case LambdaBody:
 		return n.Children[0].String()
 	case Apply:
-		return fmt.Sprintf("%s %s", n.Children[0], n.Children[1])
+		if f := n.Children[0]; f.NodeType == Apply && f.Children[0].isOperator() {
+			return fmt.Sprintf("(%s %s %s)", f.Children[1].closedString(), f.Children[0].Name, n.Children[1].closedString())
+		}
+		return fmt.Sprintf("%s %s", n.Children[0].closedString(), n.Children[1].atomicString())
+	case TypeAbstraction:
+		return fmt.Sprintf("\\%s -> (%s)", binderString(n.Name, n.Children[1], true), n.Children[0])
+	case TypeApplication:
+		return fmt.Sprintf("%s [%s]", n.Children[0].closedString(), n.Children[1])
+	case TypeBool:
+		return "Bool"
+	case TypeNat:
+		return "Nat"
+	case TypeVariable:
+		return n.Name
+	case TypeArrow:
+		if l := n.Children[0]; l.NodeType == OperatorApplication || l.NodeType == TypeList {
+			return fmt.Sprintf("%s -> %s", l, n.Children[1])
+		}
+		return fmt.Sprintf("%s -> %s", n.Children[0].atomicTypeString(), n.Children[1])
+	case TypeAll:
+		return fmt.Sprintf("All %s. %s", binderString(n.Name, n.Children[1], false), n.Children[0])
+	case TypeSome:
+		return fmt.Sprintf("{Some %s, %s}", binderString(n.Name, n.Children[1], false), n.Children[0])
+	case TypeTop:
+		return "Top"
+	case TypeBot:
+		return "Bot"
+	case CallCC:
+		return "callcc"
+	case Abort:
+		return "abort"
+	case OperatorAbstraction:
+		if k := n.Children[1]; k.NodeType != KindStar {
+			return fmt.Sprintf("\\%s:%s -> %s", n.Name, k, n.Children[0])
+		}
+		return fmt.Sprintf("\\%s -> %s", n.Name, n.Children[0])
+	case OperatorApplication:
+		f := n.Children[0].String()
+		if n.Children[0].NodeType == OperatorAbstraction {
+			f = fmt.Sprintf("(%s)", f)
+		}
+		return fmt.Sprintf("%s %s", f, n.Children[1].atomicTypeString())
+	case Definition:
+		name := n.Name
+		if isOperatorName(name) {
+			name = fmt.Sprintf("(%s)", name)
+		}
+		if len(n.Children) == 2 {
+			return fmt.Sprintf("def %s : %s = %s;", name, n.Children[1], n.Children[0])
+		}
+		return fmt.Sprintf("def %s = %s;", name, n.Children[0])
+	case TypeDefinition:
+		return fmt.Sprintf("type %s = %s;", n.Name, n.Children[0])
+	case StringLiteral:
+		return fmt.Sprintf("\"%s\"", stringEscaper.Replace(n.Name))
+	case Concat:
+		if len(n.Children) == 1 {
+			return fmt.Sprintf("concat %s", n.Children[0])
+		}
+		return "concat"
+	case StrLen:
+		return "strlen"
+	case StrEq:
+		if len(n.Children) == 1 {
+			return fmt.Sprintf("streq %s", n.Children[0])
+		}
+		return "streq"
+	case TypeString:
+		return "String"
+	case NodeNumber:
+		if n.Name == "0" { // 0 is zero of Nat
+			return "0i"
+		}
+		return n.Name
+	case BinaryOperator:
+		if len(n.Children) == 1 {
+			return fmt.Sprintf("(%s %s)", n.Children[0], n.Name)
+		}
+		return fmt.Sprintf("(%s)", n.Name)
+	case TypeInt:
+		return "Int"
+	case Tuple, TypeTuple:
+		var tmp []string
+		for _, c := range n.Children {
+			tmp = append(tmp, c.String())
+		}
+		return fmt.Sprintf("{%s}", strings.Join(tmp, ", "))
+	case Variant:
+		if len(n.Children) == 1 { // pattern
+			return fmt.Sprintf("<%s = %s>", n.Name, n.Children[0])
+		}
+		return fmt.Sprintf("<%s = %s> as %s", n.Name, n.Children[0].closedString(), n.Children[1])
+	case TypeVariant:
+		var tmp []string
+		for _, f := range n.Children {
+			tmp = append(tmp, f.String())
+		}
+		return fmt.Sprintf("<%s>", strings.Join(tmp, ", "))
+	case VariantField:
+		return fmt.Sprintf("%s: %s", n.Name, n.Children[0])
+	case Match:
+		tmp := []string{fmt.Sprintf("match %s with", n.Children[0].closedString())}
+		for _, c := range n.Children[1:] {
+			tmp = append(tmp, c.String())
+		}
+		return strings.Join(tmp, " ")
+	case MatchCase:
+		return fmt.Sprintf("| %s -> (%s)", n.Children[0], n.Children[1])
+	case PatternVariable:
+		return n.Name
+	case Import:
+		if n.Name != "" {
+			return fmt.Sprintf("import %s as %s;", n.Children[0], n.Name)
+		}
+		return fmt.Sprintf("import %s;", n.Children[0])
+	case Export:
+		return fmt.Sprintf("export %s;", n.Name)
+	case Nil:
+		return "nil"
+	case Cons:
+		switch len(n.Children) {
+		case 0:
+			return "cons"
+		case 1:
+			return fmt.Sprintf("cons %s", n.Children[0].atomicString())
+		}
+		if elems, ok := n.listElements(); ok {
+			var tmp []string
+			for _, e := range elems {
+				tmp = append(tmp, e.String())
+			}
+			return fmt.Sprintf("[%s]", strings.Join(tmp, ", "))
+		}
+		return fmt.Sprintf("cons %s %s", n.Children[0].atomicString(), n.Children[1].atomicString())
+	case IsNil:
+		return "isnil"
+	case Head:
+		return "head"
+	case Tail:
+		return "tail"
+	case TypeList:
+		return fmt.Sprintf("List %s", n.Children[0].atomicTypeString())
+	case KindStar:
+		return "*"
+	case KindArrow:
+		if l := n.Children[0]; l.NodeType == KindArrow {
+			return fmt.Sprintf("(%s) => %s", l, n.Children[1])
+		}
+		return fmt.Sprintf("%s => %s", n.Children[0], n.Children[1])
+	case Pack:
+		return fmt.Sprintf("{*%s, %s} as %s", n.Children[0], n.Children[1], n.Children[2])
+	case Unpack:
+		return fmt.Sprintf("let {%s, %s} = %s in %s", n.Name, n.Children[0].Name, n.Children[1], n.Children[2])
 	default:
 		panic("unknown type?")
 	}
 }
 
+// isOperator returns whether a node is a function of an infix operator.
+func (n *Node) isOperator() bool {
+	switch n.NodeType {
+	case BinaryOperator:
+		return len(n.Children) == 0
+	case Variable, FreeVariable:
+		return isOperatorName(n.Name)
+	}
+	return false
+}
+
+// isOpen returns whether a term extends to the right as far as possible, such as the body of a lambda.
+func (n *Node) isOpen() bool {
+	switch n.NodeType {
+	case Lambda, TypeAbstraction, IF, Match, Unpack, Pack, Variant:
+		return true
+	}
+	return false
+}
+
+// closedString wraps a term with parentheses if it is open, so that it can be followed by other tokens.
+func (n *Node) closedString() string {
+	if n.isOpen() {
+		return fmt.Sprintf("(%s)", n)
+	}
+	return n.String()
+}
+
+// atomicString wraps a term with parentheses unless it can be an argument of an application as is.
+func (n *Node) atomicString() string {
+	switch n.NodeType {
+	case Apply:
+		if f := n.Children[0]; f.NodeType == Apply && f.Children[0].isOperator() { // an infix operator has them
+			return n.String()
+		}
+	case TypeApplication:
+	case Succ, Concat, StrEq:
+		if len(n.Children) == 0 {
+			return n.String()
+		}
+	case Cons:
+		if _, ok := n.listElements(); ok || len(n.Children) == 0 {
+			return n.String()
+		}
+	default:
+		return n.closedString()
+	}
+	return fmt.Sprintf("(%s)", n)
+}
+
+// stringEscaper is the inverse of unescaping string literals in Lexer
+var stringEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\t", "\\t", "\r", "\\r")
+
+// atomicTypeString wraps a type with parentheses unless it can be read as a single token.
+func (n *Node) atomicTypeString() string {
+	switch n.NodeType {
+	case TypeArrow, TypeAll, OperatorAbstraction, OperatorApplication, TypeList:
+		return fmt.Sprintf("(%s)", n)
+	}
+	return n.String()
+}
+
+// binderString returns "X", "X <: T" or "X:K" for a type variable and its bound.
+func binderString(name string, bound *Node, atomic bool) string {
+	if bound.NodeType != TypeTop {
+		if atomic {
+			return fmt.Sprintf("%s <: %s", name, bound.atomicTypeString())
+		}
+		return fmt.Sprintf("%s <: %s", name, bound)
+	}
+	if len(bound.Children) == 1 {
+		return fmt.Sprintf("%s:%s", name, bound.Children[0])
+	}
+	return name
+}
+
 func (n *Node) show(indent string) {
 	fmt.Printf("%s%s\n", indent, n.NodeType)
 	nextIndent := indent + "  "
@@ -61,16 +300,30 @@ func (n *Node) IsNumericalValue() bool {
 	if n.NodeType == Zero {
 		return true
 	}
-	if n.NodeType == Succ {
+	if n.NodeType == Succ && len(n.Children) == 1 {
 		c := n.Children[0]
 		return c.IsNumericalValue()
 	}
 	return false
 }
 
+// IsListValue returns whether a node is nil or a cons cell.
+func (n *Node) IsListValue() bool {
+	return n.NodeType == Nil || (n.NodeType == Cons && len(n.Children) == 2)
+}
+
+// listElements returns elements of a list value which ends with nil.
+func (n *Node) listElements() ([]*Node, bool) {
+	var ret []*Node
+	for ; n.NodeType == Cons && len(n.Children) == 2; n = n.Children[1] {
+		ret = append(ret, n.Children[0])
+	}
+	return ret, n.NodeType == Nil
+}
+
 // IsValue returns whether a node is a value or not.
 func (n *Node) IsValue() bool {
-	if n.NodeType == True || n.NodeType == False {
+	if n.NodeType == True || n.NodeType == False || n.NodeType == StringLiteral || n.NodeType == NodeNumber || n.IsListValue() {
 		return true
 	}
 	return n.IsNumericalValue()
@@ -81,7 +334,13 @@ func (n *Node) IsApplyable() bool {
 	if n.NodeType == Lambda {
 		return true
 	}
-	if n.NodeType == Succ || n.NodeType == Pred || n.NodeType == IsZero {
+	if (n.NodeType == Succ && len(n.Children) == 0) || n.NodeType == Pred || n.NodeType == IsZero {
+		return true
+	}
+	if (n.NodeType == Cons && len(n.Children) < 2) || n.NodeType == IsNil || n.NodeType == Head || n.NodeType == Tail {
+		return true
+	}
+	if n.NodeType == Concat || n.NodeType == StrLen || n.NodeType == StrEq || n.NodeType == BinaryOperator {
 		return true
 	}
 	return false
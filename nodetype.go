@@ -22,7 +22,7 @@ const (
 	Variable
 	// FreeVariable is a free variable
 	FreeVariable
-	// Lambda is a function. a lambda's children are always [LambdaDef, LambdaBody]
+	// Lambda is a function. a lambda's children are always [LambdaDef, LambdaBody]. ".x .y -> t" with several params is curried as ".x -> .y -> t"
 	Lambda
 	// LambdaDef has some LambdaParams
 	LambdaDef
@@ -32,6 +32,92 @@ const (
 	LambdaBody
 	// Apply is "function call"
 	Apply
-	// NodeNumber is a numerical value
+	// NodeNumber is a machine integer. its Name is the decimal representation. zero is written as 0i
 	NodeNumber
+	// TypeAbstraction is a type-level function "\X <: T -> t". its Name is the type parameter and its children are always [t, T]
+	TypeAbstraction
+	// TypeApplication is "t [T]". its children are always [term, type]
+	TypeApplication
+	// TypeBool is the type of true and false
+	TypeBool
+	// TypeNat is the type of natural numbers
+	TypeNat
+	// TypeVariable is a type variable, which is bound by outer TypeAbstraction or TypeAll
+	TypeVariable
+	// TypeArrow is a function type. its children are always [parameter type, result type]
+	TypeArrow
+	// TypeAll is a universal type "All X <: T. T'". its Name is the bound variable and its children are always [T', T]
+	TypeAll
+	// TypeSome is an existential type "{Some X <: T, T'}". its Name is the bound variable and its children are always [T', T]
+	TypeSome
+	// Pack is "{*T, t} as T'". its children are always [hidden type, term, existential type]
+	Pack
+	// Unpack is "let {X, x} = t in t'". its Name is the type variable and its children are always [LambdaParam, t, t']
+	Unpack
+	// TypeTop is the maximum type Top. it may have single child, a kind K of Top[K]
+	TypeTop
+	// OperatorAbstraction is a type operator "\X:K -> T". its Name is the type parameter and its children are always [T, K]
+	OperatorAbstraction
+	// OperatorApplication is an application of a type operator "T T'". its children are always [T, T']
+	OperatorApplication
+	// KindStar is the kind of proper types "*"
+	KindStar
+	// KindArrow is the kind of type operators "K => K'". its children are always [K, K']
+	KindArrow
+	// Definition is a top-level declaration "def x = t" or "def x : T = t". its Name is the defined name and its children are [t] or [t, T]
+	Definition
+	// TypeDefinition is a top-level declaration of a type synonym "type X = T". its Name is the defined name and it has single child
+	TypeDefinition
+	// StringLiteral is a literal string. its Name is the unescaped content
+	StringLiteral
+	// Import is a top-level declaration "import "path" as X". its Name is the alias, which may be empty, and it has single StringLiteral child
+	Import
+	// Export is a top-level declaration "export x". its Name is the exported name
+	Export
+	// Nil is the empty list "nil"
+	Nil
+	// Cons is "cons". it has no children as a function, [t] when applied once, and [t, t'] as a list value
+	Cons
+	// IsNil is "isnil"
+	IsNil
+	// Head is "head"
+	Head
+	// Tail is "tail"
+	Tail
+	// TypeList is the type of lists "List T". it has single child
+	TypeList
+	// Concat is "concat". it has no children as a function, and [s] when applied once
+	Concat
+	// StrLen is "strlen"
+	StrLen
+	// StrEq is "streq". it has no children as a function, and [s] when applied once
+	StrEq
+	// TypeString is the type of strings "String"
+	TypeString
+	// BinaryOperator is an arithmetic operator such as "+". its Name is the operator, and it has [t] when applied once
+	BinaryOperator
+	// TypeInt is the type of machine integers "Int"
+	TypeInt
+	// Tuple is "{t1, t2, ...}". its children are the elements, which are patterns in a pattern
+	Tuple
+	// TypeTuple is the type of tuples "{T1, T2, ...}"
+	TypeTuple
+	// Variant is "<l = t> as T". its Name is the label and its children are always [t, T], or [p] in a pattern
+	Variant
+	// TypeVariant is a variant type "<l1: T1, l2: T2, ...>". its children are VariantFields
+	TypeVariant
+	// VariantField is "l: T" in a variant type. its Name is the label and it has single child
+	VariantField
+	// Match is "match t with | p -> t' ...". its children are always [t, MatchCase...]
+	Match
+	// MatchCase is "| p -> t" in a match. its children are always [p, t]
+	MatchCase
+	// PatternVariable is a variable in a pattern, which matches any value. "_" does not bind the value
+	PatternVariable
+	// CallCC is "callcc", which calls a function with the current continuation
+	CallCC
+	// Abort is "abort", which discards the current continuation and makes its argument the result of the program
+	Abort
+	// TypeBot is the minimum type "Bot", which has no values. a term of Bot never returns, like an invocation of a continuation
+	TypeBot
 )
@@ -4,9 +4,9 @@ package gtl
 
 import "strconv"
 
-const _NodeType_name = "TrueFalseIFZeroSuccPredIsZeroVariableFreeVariableLambdaLambdaDefLambdaParamLambdaBodyApplyNodeNumber"
+const _NodeType_name = "TrueFalseIFZeroSuccPredIsZeroVariableFreeVariableLambdaLambdaDefLambdaParamLambdaBodyApplyNodeNumberTypeAbstractionTypeApplicationTypeBoolTypeNatTypeVariableTypeArrowTypeAllTypeSomePackUnpackTypeTopOperatorAbstractionOperatorApplicationKindStarKindArrowDefinitionTypeDefinitionStringLiteralImportExportNilConsIsNilHeadTailTypeListConcatStrLenStrEqTypeStringBinaryOperatorTypeIntTupleTypeTupleVariantTypeVariantVariantFieldMatchMatchCasePatternVariableCallCCAbortTypeBot"
 
-var _NodeType_index = [...]uint8{0, 4, 9, 11, 15, 19, 23, 29, 37, 49, 55, 64, 75, 85, 90, 100}
+var _NodeType_index = [...]uint16{0, 4, 9, 11, 15, 19, 23, 29, 37, 49, 55, 64, 75, 85, 90, 100, 115, 130, 138, 145, 157, 166, 173, 181, 185, 191, 198, 217, 236, 244, 253, 263, 277, 290, 296, 302, 305, 309, 314, 318, 322, 330, 336, 342, 347, 357, 371, 378, 383, 392, 399, 410, 422, 427, 436, 451, 457, 462, 469}
 
 func (i NodeType) String() string {
 	if i >= NodeType(len(_NodeType_index)-1) {
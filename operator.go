@@ -0,0 +1,175 @@
+package gtl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type associativity uint8
+
+const (
+	leftAssociative associativity = iota
+	rightAssociative
+	nonAssociative
+)
+
+// fixity is the precedence and the associativity of an infix operator. larger precedence binds tighter.
+type fixity struct {
+	operator      string
+	precedence    int
+	associativity associativity
+}
+
+// builtinFixities are fixities of operators on machine integers.
+// Operators which are not declared are infixl 9.
+var builtinFixities = []fixity{
+	{"==", 4, nonAssociative},
+	{"<", 4, nonAssociative},
+	{"+", 6, leftAssociative},
+	{"-", 6, leftAssociative},
+	{"*", 7, leftAssociative},
+	{"/", 7, leftAssociative},
+}
+
+const maxPrecedence = 9
+
+func (e *parseEnvironemnt) AddFixity(f fixity) {
+	e.fixities = append(e.fixities, f)
+}
+
+// LookupFixity returns the fixity of an operator, which is declared lastly.
+func (e *parseEnvironemnt) LookupFixity(op string) fixity {
+	for i := len(e.fixities) - 1; i >= 0; i-- {
+		if e.fixities[i].operator == op {
+			return e.fixities[i]
+		}
+	}
+	for _, f := range builtinFixities {
+		if f.operator == op {
+			return f
+		}
+	}
+	return fixity{op, maxPrecedence, leftAssociative}
+}
+
+func isInfixOperator(t *Token) bool {
+	return t.TokenType == Operator || t.TokenType == Star
+}
+
+// isOperatorName returns whether a name is an operator such as "+", "++" or "list::++" of an imported module.
+func isOperatorName(name string) bool {
+	if i := strings.LastIndex(name, "::"); i >= 0 {
+		name = name[i+2:]
+	}
+	if name == "" {
+		return false
+	}
+	for i := range name {
+		if c := name[i : i+1]; c != "*" && !isOperatorPart(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseOperatorExpression is a Pratt parser of infix operations whose operators have at least minPrecedence.
+// operands are applications, so `f x + g y` is (f x) + (g y).
+func parseOperatorExpression(tokens []*Token, env parseEnvironemnt, minPrecedence int) (*Node, parseEnvironemnt, error) {
+	left, env, err := parseApplication(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	if t := tokens[env.idx]; left == nil && isInfixOperator(t) {
+		return nil, env, fmt.Errorf("operator %s without left operand at %d", t.Text, env.idx)
+	}
+	if left == nil {
+		return nil, env, nil
+	}
+	for {
+		t := tokens[env.idx]
+		if !isInfixOperator(t) {
+			return left, env, nil
+		}
+		f := env.LookupFixity(t.Text)
+		if f.precedence < minPrecedence {
+			return left, env, nil
+		}
+		env.idx++ // operator
+		next := f.precedence + 1
+		if f.associativity == rightAssociative {
+			next = f.precedence
+		}
+		var right *Node
+		right, env, err = parseOperatorExpression(tokens, env, next)
+		if err != nil {
+			return nil, env, err
+		}
+		if right == nil {
+			return nil, env, fmt.Errorf("operator %s without right operand at %d", t.Text, env.idx)
+		}
+		left = binaryOperation(env, t.Text, left, right)
+		if n := tokens[env.idx]; f.associativity == nonAssociative && isInfixOperator(n) && env.LookupFixity(n.Text).precedence == f.precedence {
+			return nil, env, fmt.Errorf("non-associative operator %s cannot be followed by %s at %d", t.Text, n.Text, env.idx)
+		}
+	}
+}
+
+// binaryOperation returns `l op r`, which is `(op) l r`.
+func binaryOperation(env parseEnvironemnt, op string, l, r *Node) *Node {
+	f := &Node{NodeType: Apply, Children: []*Node{buildOperatorNode(env, op), l}}
+	return &Node{NodeType: Apply, Children: []*Node{f, r}}
+}
+
+// buildOperatorNode returns a function which an operator is bound to.
+// operators of machine integers are built-in unless they are defined by users.
+func buildOperatorNode(env parseEnvironemnt, op string) *Node {
+	if !env.IsBound(op) {
+		for _, f := range builtinFixities {
+			if f.operator == op {
+				return &Node{NodeType: BinaryOperator, Name: op}
+			}
+		}
+	}
+	return buildVariableNode(env, op)
+}
+
+// infixl 6 +;
+// infixr 5 ++;
+// infix 4 ==;
+func parseFixity(tokens []*Token, env parseEnvironemnt) (parseEnvironemnt, error) {
+	f := fixity{associativity: leftAssociative}
+	switch tokens[env.idx].TokenType {
+	case KeywordInfixr:
+		f.associativity = rightAssociative
+	case KeywordInfix:
+		f.associativity = nonAssociative
+	}
+	t := tokens[env.idx+1]
+	p, err := strconv.Atoi(t.Text)
+	if t.TokenType != Number || err != nil || p > maxPrecedence {
+		return env, fmt.Errorf("precedence should be a number from 0 to %d but got %v at %d", maxPrecedence, t, env.idx+1)
+	}
+	f.precedence = p
+	if t := tokens[env.idx+2]; !isInfixOperator(t) {
+		return env, fmt.Errorf("there should be an operator but got %v at %d", t, env.idx+2)
+	}
+	f.operator = tokens[env.idx+2].Text
+	if t := tokens[env.idx+3]; t.TokenType != Semicolon {
+		return env, fmt.Errorf("fixity declaration should end with ; but got %v at %d", t, env.idx+3)
+	}
+	env.idx += 4
+	env.AddFixity(f)
+	return env, nil
+}
+
+// parseDefinedName returns a name after def or export, which is a word or an operator in parens such as (++).
+func parseDefinedName(tokens []*Token, idx int) (string, int, bool) {
+	if t := tokens[idx]; t.TokenType == Word {
+		return t.Text, idx + 1, true
+	}
+	if tokens[idx].TokenType == LParen && isInfixOperator(tokens[idx+1]) && tokens[idx+2].TokenType == RParen {
+		return tokens[idx+1].Text, idx + 3, true
+	}
+	return "", idx, false
+}
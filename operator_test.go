@@ -0,0 +1,79 @@
+package gtl
+
+import "testing"
+
+func Test_parseOperatorExpression(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"a ++ b ++ c", "((a ++ b) ++ c)"},
+		{"infixr 5 ++; a ++ b ++ c", "(a ++ (b ++ c))"},
+		{"infixr 5 ++; a ++ b + c", "(a ++ (b + c))"},
+		{"infixl 8 ++; a ++ b * c", "((a ++ b) * c)"},
+		{"infixl 6 +; infixl 8 +; 1 + 2 * 3", "((1 + 2) * 3)"},
+		{"f $ g $ x", "((f $ g) $ x)"},
+		{"infixr 0 $; f $ g $ x", "(f $ (g $ x))"},
+		{"concat a b <> c", "(concat a b <> c)"},
+		{"(+)", "(+)"},
+		{"(++) a b", "(a ++ b)"},
+		{"def (++) = .l .m -> l; a ++ b", "(a ++ b)"},
+	}
+	for i, v := range testcases {
+		ast := buildASTFromString(v.src)
+		if got := ast.Child.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+	for i, src := range []string{
+		"1 < 2 < 3",
+		"infix 4 ===; a === b === c",
+		"infixl 10 +; 1",
+		"infixl x +; 1",
+		"infixl 6 a; 1",
+		"infixl 6 +",
+	} {
+		tokens, err := Tokenize(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Parse(tokens); err == nil {
+			t.Errorf("case %d: %q should not be parsed", i, src)
+		}
+	}
+}
+
+func Test_buildOperatorNode(t *testing.T) {
+	ast := buildASTFromString("1 + 2")
+	if want, got := BinaryOperator, ast.Child.Children[0].Children[0].NodeType; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	ast = buildASTFromString("def (+) = .a .b -> a; 1 + 2")
+	if want, got := Variable, ast.Child.Children[0].Children[0].NodeType; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+}
+
+func TestEval_userDefinedOperator(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"infixr 5 ++; def (++) = .l .m -> if isnil l then m else cons (head l) (tail l ++ m); [0] ++ [1] ++ [2]", "[0, 1, 2]"},
+		{"infixl 6 <>; def (<>) = .a .b -> concat a b; \"a\" <> \"b\" <> \"c\"", "\"abc\""},
+		{"infixr 0 $; def ($) = .f .x -> f x; succ $ succ $ 0", "succ (succ (0))"},
+		{"def (+) = .a .b -> a; 1 + 2", "1"},
+		{"(+) 1 2", "3"},
+		{"def twice = .f .x -> f (f x); twice ((*) 3) 2", "18"},
+	}
+	for i, v := range testcases {
+		n, err := Eval(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := n.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
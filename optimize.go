@@ -0,0 +1,277 @@
+package gtl
+
+// inlineSize is the largest size of a function definition which Optimize inlines.
+const inlineSize = 20
+
+// Optimize returns a program which evaluates to the same result as ast with less work.
+// It evaluates closed terms such as `iszero 0` in advance, reduces `if true then a else b` to a,
+// applies lambdas to values when that does not make the program larger, and inlines small functions
+// which are defined once and have no free variables. Definitions of values which the program
+// does not use are removed. Type annotations are kept but the result may not be well-typed.
+func Optimize(ast *AST) *AST {
+	count := make(map[string]int)
+	for _, d := range ast.Declarations {
+		if d.NodeType == Definition {
+			count[d.Name]++
+		}
+	}
+	o := &optimizer{inline: make(map[string]*Node), unfolding: make(map[string]bool)}
+	ret := &AST{}
+	for _, d := range ast.Declarations {
+		if d.NodeType != Definition {
+			ret.Declarations = append(ret.Declarations, d)
+			continue
+		}
+		t := o.optimize(d.Children[0], nil)
+		if t.NodeType == Lambda && count[d.Name] == 1 && len(freeVariables(t)) == 0 && size(t) <= inlineSize {
+			o.inline[d.Name] = t
+		}
+		ret.Declarations = append(ret.Declarations, &Node{NodeType: Definition, Name: d.Name, Children: append([]*Node{t}, d.Children[1:]...)})
+	}
+	ret.Child = o.optimize(ast.Child, nil)
+	ret.Declarations = removeUnusedDefinitions(ret.Declarations, ret.Child)
+	return ret
+}
+
+type optimizer struct {
+	inline    map[string]*Node // functions which are inlined into the following declarations
+	unfolding map[string]bool  // inlined functions whose applications are being optimized
+}
+
+// optimize returns the optimized term of n. locals are variables bound in n's context except definitions.
+func (o *optimizer) optimize(n *Node, locals []string) *Node {
+	switch n.NodeType {
+	case Lambda:
+		params := locals
+		for _, p := range n.Children[0].Children {
+			params = append(params, p.Name)
+		}
+		body := o.optimize(n.Children[1].Children[0], params)
+		return &Node{NodeType: Lambda, Children: []*Node{n.Children[0], &Node{NodeType: LambdaBody, Children: []*Node{body}}}}
+	case Apply:
+		return o.optimizeApply(n, locals)
+	case IF:
+		cond := o.optimize(n.Children[0], locals)
+		switch cond.NodeType {
+		case True:
+			return o.optimize(n.Children[1], locals)
+		case False:
+			return o.optimize(n.Children[2], locals)
+		}
+		return fold(&Node{NodeType: IF, Children: []*Node{cond, o.optimize(n.Children[1], locals), o.optimize(n.Children[2], locals)}})
+	case TypeApplication:
+		f := o.optimize(n.Children[0], locals)
+		if f.NodeType == TypeAbstraction {
+			return o.optimize(substType(f.Children[0], f.Name, n.Children[1]), locals)
+		}
+		return fold(&Node{NodeType: TypeApplication, Children: []*Node{f, n.Children[1]}})
+	case TypeAbstraction:
+		return &Node{NodeType: TypeAbstraction, Name: n.Name, Children: []*Node{o.optimize(n.Children[0], locals), n.Children[1]}}
+	case Pack:
+		return &Node{NodeType: Pack, Children: []*Node{n.Children[0], o.optimize(n.Children[1], locals), n.Children[2]}}
+	case Variant:
+		return &Node{NodeType: Variant, Name: n.Name, Children: []*Node{o.optimize(n.Children[0], locals), n.Children[1]}}
+	case Unpack:
+		bound := o.optimize(n.Children[1], locals)
+		body := o.optimize(n.Children[2], append(locals, n.Children[0].Name))
+		return fold(&Node{NodeType: Unpack, Name: n.Name, Children: []*Node{n.Children[0], bound, body}})
+	case Match:
+		ret := &Node{NodeType: Match, Children: []*Node{o.optimize(n.Children[0], locals)}}
+		for _, mc := range n.Children[1:] {
+			vars, _ := patternVariables(mc.Children[0])
+			body := o.optimize(mc.Children[1], append(locals, vars...))
+			ret.Children = append(ret.Children, &Node{NodeType: MatchCase, Children: []*Node{mc.Children[0], body}})
+		}
+		return fold(ret)
+	case Tuple:
+		ret := &Node{NodeType: Tuple, Children: make([]*Node, len(n.Children))}
+		for i, c := range n.Children {
+			ret.Children[i] = o.optimize(c, locals)
+		}
+		return ret
+	}
+	return n
+}
+
+// optimizeApply optimizes an application. a lambda, or an inlined function, is applied to its arguments
+// as long as they are reducible. a function is not inlined into its own unfolding, so self-application
+// such as `w w` where `def w = .x -> x x` terminates.
+func (o *optimizer) optimizeApply(n *Node, locals []string) *Node {
+	var args []*Node
+	f := n
+	for ; f.NodeType == Apply; f = f.Children[0] {
+		args = append([]*Node{o.optimize(f.Children[1], locals)}, args...)
+	}
+	f = o.optimize(f, locals)
+	for len(args) != 0 {
+		l, unfolded := f, ""
+		if f.NodeType == Variable && !containsString(locals, f.Name) && o.inline[f.Name] != nil && !o.unfolding[f.Name] {
+			l, unfolded = o.inline[f.Name], f.Name
+		}
+		if l.NodeType != Lambda || !reducible(l, args[0]) {
+			break
+		}
+		body, _ := applyLambda(l, args[0])
+		if unfolded != "" {
+			o.unfolding[unfolded] = true
+		}
+		f, args = o.optimize(body, locals), args[1:]
+		delete(o.unfolding, unfolded)
+	}
+	return fold(applyTerms(f, args...))
+}
+
+// reducible reports whether a lambda l can be applied to arg without changing the meaning or
+// enlarging the program: arg is a value whose variables are not captured in l, and arg is atomic
+// or the parameter occurs at most once.
+func reducible(l, arg *Node) bool {
+	if !isSyntacticValue(arg) {
+		return false
+	}
+	param := l.Children[0].Children[0].Name
+	scope := l.Children[1].Children[0]
+	if len(l.Children[0].Children) > 1 {
+		scope = &Node{NodeType: Lambda, Children: []*Node{
+			&Node{NodeType: LambdaDef, Children: l.Children[0].Children[1:]},
+			l.Children[1],
+		}}
+	}
+	bound := boundVariables(scope)
+	for _, v := range freeVariables(arg) {
+		if containsString(bound, v) {
+			return false
+		}
+	}
+	return len(arg.Children) == 0 || occurrences(scope, param) <= 1
+}
+
+// isSyntacticValue reports whether n is a value before evaluation, i.e. evaluating n has no effect and
+// takes little work. a variable is a value because only values are bound to variables.
+func isSyntacticValue(n *Node) bool {
+	switch n.NodeType {
+	case Apply:
+		var args []*Node
+		f := n
+		for ; f.NodeType == Apply; f = f.Children[0] {
+			args = append(args, f.Children[1])
+		}
+		p, ok := primitiveOf(f)
+		if !ok || len(args) > primitives[p].arity {
+			return false
+		}
+		if len(args) == primitives[p].arity && f.NodeType != Succ && f.NodeType != Cons { // constructors are values
+			return false
+		}
+		for _, a := range args {
+			if !isSyntacticValue(a) {
+				return false
+			}
+		}
+		return true
+	case Tuple:
+		for _, c := range n.Children {
+			if !isSyntacticValue(c) {
+				return false
+			}
+		}
+		return true
+	case Variant:
+		return isSyntacticValue(n.Children[0])
+	case Pack:
+		return isSyntacticValue(n.Children[1])
+	case IF, Match, Unpack, TypeApplication:
+		return false
+	}
+	return true
+}
+
+// fold evaluates n in advance if it has no variables, so it does not depend on the context.
+// such a term has no recursion, so the evaluation terminates. if it fails, n is kept as it is.
+func fold(n *Node) *Node {
+	if hasVariable(n) {
+		return n
+	}
+	var env evalEnvironment
+	v, err := eval(n, &env)
+	if err != nil {
+		return n
+	}
+	return sourceTerm(v)
+}
+
+func hasVariable(n *Node) bool {
+	if n.NodeType == Variable {
+		return true
+	}
+	for _, c := range n.Children {
+		if hasVariable(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceTerm returns a term of a value of Eval as the parser makes it,
+// e.g. `succ 0` for the numerical value and `cons 0 nil` for the list value.
+func sourceTerm(v *Node) *Node {
+	switch v.NodeType {
+	case Succ, Cons, BinaryOperator, Concat, StrEq:
+		if len(v.Children) != 0 {
+			args := make([]*Node, len(v.Children))
+			for i, c := range v.Children {
+				args[i] = sourceTerm(c)
+			}
+			return applyTerms(&Node{NodeType: v.NodeType, Name: v.Name}, args...)
+		}
+	}
+	if len(v.Children) == 0 {
+		return v
+	}
+	ret := &Node{NodeType: v.NodeType, Name: v.Name, Children: make([]*Node, len(v.Children))}
+	for i, c := range v.Children {
+		ret.Children[i] = sourceTerm(c)
+	}
+	return ret
+}
+
+// removeUnusedDefinitions removes definitions of values which are not used by main or other definitions.
+// a definition of a term which is not a value is kept, because evaluating it may fail or not terminate.
+func removeUnusedDefinitions(decls []*Node, main *Node) []*Node {
+	used := make(map[string]bool)
+	var mark func(n *Node)
+	mark = func(n *Node) {
+		for _, v := range freeVariables(n) {
+			if used[v] {
+				continue
+			}
+			used[v] = true
+			for _, d := range decls {
+				if d.NodeType == Definition && d.Name == v {
+					mark(d.Children[0])
+				}
+			}
+		}
+	}
+	for _, d := range decls {
+		if d.NodeType == Definition && !isSyntacticValue(d.Children[0]) {
+			mark(d.Children[0])
+		}
+	}
+	mark(main)
+	var ret []*Node
+	for _, d := range decls {
+		if d.NodeType != Definition || used[d.Name] || !isSyntacticValue(d.Children[0]) {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+// size returns the number of nodes in n.
+func size(n *Node) int {
+	ret := 1
+	for _, c := range n.Children {
+		ret += size(c)
+	}
+	return ret
+}
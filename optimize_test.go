@@ -0,0 +1,145 @@
+package gtl
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	for i, src := range append([]string{
+		"def not = .b -> if b then false else true; not (iszero 0)",
+		"def apply = .f .x -> f x; apply (.n -> n + 1) 2",
+		"(.f -> {f 0, f 1}) (.n -> n * 2)",
+		"(.x -> .y -> x) y",
+		"def y = 0; (.x -> .y -> x) y true",
+		"def id = .x -> x; (.id -> id 0) succ",
+		"def loop = .x -> loop x; (.x -> 0) loop",
+		`(\X -> .x:X -> x) [Nat] (iszero 0)`,
+		"match {iszero 0, 1} with | {true, n} -> n | {false, _} -> 0",
+		"def w = .x -> x x; .y -> w w",
+		"def w = .x -> x x; def v = .x -> x x; .y -> {w v, v w}",
+	}, evalSources...) {
+		want, err := Eval(buildASTFromString(src))
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		ast, err := reparse(Optimize(buildASTFromString(src))) // the output of tl-eval --emit opt
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if containsFunction(want) { // bodies of functions are optimized
+			continue
+		}
+		got, err := Eval(ast)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+	}
+}
+
+func TestOptimize_prelude(t *testing.T) {
+	for i, src := range []string{
+		"times (succ (succ 0)) (succ (succ (succ 0)))",
+		"equal (succ 0) (succ 0)",
+		"map (plus (succ 0)) [0, succ 0]",
+		"foldr plus 0 [succ 0, succ 0]",
+		"length (append [0] [0, 0])",
+	} {
+		want, err := Eval(buildASTWithPrelude(Prelude, src))
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		ast, err := reparse(Optimize(buildASTWithPrelude(Prelude, src)))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		got, err := Eval(ast)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+		if len(ast.Declarations) >= len(buildASTWithPrelude(Prelude, src).Declarations) {
+			t.Errorf("case %d: unused definitions are not removed", i)
+		}
+	}
+}
+
+func TestOptimize_files(t *testing.T) {
+	files, err := filepath.Glob("sample/*.tl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := Eval(buildASTFromString(string(b)))
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		got, err := Eval(Optimize(buildASTFromString(string(b))))
+		if err != nil {
+			t.Errorf("%s: %v", f, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("%s: want %v but got %v\n", f, want, got)
+		}
+	}
+}
+
+func TestOptimize_output(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"iszero 0", "true"},
+		{"if true then x else y", "x"},
+		{"if iszero (succ 0) then x else y", "y"},
+		{"1 + 2 * 3", "7"},
+		{"[pred (succ 0)]", "cons 0 nil"},
+		{"(.x -> succ x) 0", "succ 0"},
+		{".n -> if iszero 0 then n else 0", ".n -> (n)"},
+		{"(.x -> {x, x}) y", "{y, y}"},
+		{"(.x -> {x, x}) (.y -> y)", "(.x -> ({x, x})) (.y -> (y))"},
+		{"(.x -> x) (f 0)", "(.x -> (x)) (f 0)"},
+		{"(.x -> .y -> x) y", "(.x -> (.y -> (x))) y"},
+		{"(.x -> 0) (.y -> y)", "0"},
+		{"1 / 0", "(1 / 0)"},
+		{"def inc = .x -> x + 1; inc 2", "3"},
+		{"def inc = .x -> x + 1; def unused = .x -> x; def y = inc (f 0); y", "def inc = .x -> ((x + 1));\ndef y = inc (f 0);\ny"},
+		{"def fact = .n -> if n < 2 then 1 else n * fact (n - 1); 0", "0"},
+		{"def w = .x -> x x; .y -> w w", "def w = .x -> (x x);\n.y -> (w w)"},
+	}
+	for i, v := range testcases {
+		ast := Optimize(buildASTFromString(v.src))
+		var lines []string
+		for _, d := range ast.Declarations {
+			lines = append(lines, d.String())
+		}
+		lines = append(lines, ast.Child.String())
+		if got := strings.Join(lines, "\n"); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+func TestOptimize_error(t *testing.T) {
+	for i, src := range []string{"1 / (1 - 1)", "def x = 1 / 0; 0", "match succ 0 with | 0 -> 0"} {
+		if _, err := Eval(Optimize(buildASTFromString(src))); err == nil {
+			t.Errorf("case %d: the error is lost", i)
+		}
+	}
+}
@@ -3,14 +3,21 @@ package gtl
 import (
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
 )
 
-// AST is a abstract syntax tree. It contains only one Program Node.
+// AST is a abstract syntax tree. It contains top-level declarations and one Program Node.
 type AST struct {
-	Child *Node
+	Declarations []*Node // Definition or TypeDefinition in order
+	Child        *Node
 }
 
 func (ast *AST) show() {
+	for _, d := range ast.Declarations {
+		d.show("")
+	}
 	ast.Child.show("")
 }
 
@@ -19,6 +26,7 @@ type parseEnvironemnt struct {
 	parenCount int
 
 	knownWords []string
+	fixities   []fixity
 }
 
 func (e *parseEnvironemnt) AddKnownWord(name string) {
@@ -45,35 +53,240 @@ func (e *parseEnvironemnt) IsBound(name string) bool {
 }
 
 // Parse returns an AST for tokens.
+// A program is zero or more declarations followed by a main expression.
+//
+//	import "lib/bool.tl" as B;
+//	def id = .x -> x;
+//	type Fn = Nat -> Nat;
+//	infixr 5 ++;
+//	def (++) = .l .m -> l;
+//	id 0
 func Parse(tokens []*Token) (*AST, error) {
-	var nodes []*Node
+	decls, node, err := parseProgram(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, errors.New("no nodes")
+	}
+	return &AST{Declarations: decls, Child: node}, nil
+}
+
+// parseProgram returns declarations and a main expression, which is nil if it does not exist.
+// all defined names are known from the beginning, so a definition can refer to the later ones
+// such as mutually recursive functions.
+func parseProgram(tokens []*Token) ([]*Node, *Node, error) {
 	var env parseEnvironemnt
-parseLoop:
-	for l := len(tokens); env.idx < l; {
-		var t *Node
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].TokenType != KeywordDef {
+			continue
+		}
+		if name, _, ok := parseDefinedName(tokens, i+1); ok {
+			env.AddKnownWord(name)
+		}
+	}
+	var decls []*Node
+	for {
+		var decl *Node
 		var err error
-		t, env, err = parse(tokens, env)
+		switch tokens[env.idx].TokenType {
+		case KeywordDef:
+			decl, env, err = parseDef(tokens, env)
+		case KeywordType:
+			decl, env, err = parseTypeDef(tokens, env)
+		case KeywordImport:
+			decl, env, err = parseImport(tokens, env)
+		case KeywordInfixl, KeywordInfixr, KeywordInfix:
+			env, err = parseFixity(tokens, env)
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		case KeywordExport:
+			var exports []*Node
+			exports, env, err = parseExport(tokens, env)
+			if err != nil {
+				return nil, nil, err
+			}
+			decls = append(decls, exports...)
+			continue
+		}
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		if decl == nil {
+			break
 		}
-		// FIXME: too tricky...
-		if t == nil {
-			if env.idx != l {
-				return nil, fmt.Errorf("parse returns nil pointer at %d", env.idx)
+		decls = append(decls, decl)
+	}
+	node, env, err := parseExpression(tokens, env)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tokens[env.idx].TokenType == Semicolon {
+		env.idx++
+	}
+	if t := tokens[env.idx]; t.TokenType != EOF {
+		return nil, nil, fmt.Errorf("unexpected token %v at %d", t.Text, env.idx)
+	}
+	return decls, node, nil
+}
+
+// import "path";
+// import "path" as X;
+func parseImport(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	if t := tokens[env.idx+1]; t.TokenType != String {
+		return nil, env, fmt.Errorf("after import, there should be a path but got %v at %d", t, env.idx+1)
+	}
+	path := &Node{NodeType: StringLiteral, Name: tokens[env.idx+1].Text}
+	ret := &Node{NodeType: Import, Children: []*Node{path}}
+	env.idx += 2
+	if tokens[env.idx].TokenType == KeywordAs {
+		if t := tokens[env.idx+1]; t.TokenType != Word {
+			return nil, env, fmt.Errorf("after as, there should be a name but got %v at %d", t, env.idx+1)
+		}
+		ret.Name = tokens[env.idx+1].Text
+		env.idx += 2
+	}
+	if t := tokens[env.idx]; t.TokenType != Semicolon {
+		return nil, env, fmt.Errorf("import should end with ; but got %v at %d", t, env.idx)
+	}
+	env.idx++ // ;
+	return ret, env, nil
+}
+
+// export x, y, T;
+func parseExport(tokens []*Token, env parseEnvironemnt) ([]*Node, parseEnvironemnt, error) {
+	var ret []*Node
+	for {
+		name, next, ok := parseDefinedName(tokens, env.idx+1)
+		if !ok {
+			return nil, env, fmt.Errorf("there should be an exported name but got %v at %d", tokens[env.idx+1], env.idx+1)
+		}
+		ret = append(ret, &Node{NodeType: Export, Name: name})
+		env.idx = next
+		switch t := tokens[env.idx]; t.TokenType {
+		case Comma:
+		case Semicolon:
+			env.idx++ // ;
+			return ret, env, nil
+		default:
+			return nil, env, fmt.Errorf("export should end with ; but got %v at %d", t, env.idx)
+		}
+	}
+}
+
+// def x = t;
+// def x : T = t;
+func parseDef(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	name, next, ok := parseDefinedName(tokens, env.idx+1)
+	if !ok {
+		return nil, env, fmt.Errorf("after def, there should be a name but got %v at %d", tokens[env.idx+1], env.idx+1)
+	}
+	ret := &Node{NodeType: Definition, Name: name}
+	env.idx = next
+	var ty *Node
+	if tokens[env.idx].TokenType == Colon {
+		env.idx++ // :
+		var err error
+		ty, env, err = parseType(tokens, env)
+		if err != nil {
+			return nil, env, err
+		}
+	}
+	if t := tokens[env.idx]; t.TokenType != Equal {
+		return nil, env, fmt.Errorf("after a defined name, there should be = but got %v at %d", t, env.idx)
+	}
+	env.idx++                  // =
+	env.AddKnownWord(ret.Name) // before the body, for recursive definitions
+	body, env, err := parseExpressionOrError(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	if t := tokens[env.idx]; t.TokenType != Semicolon {
+		return nil, env, fmt.Errorf("definition should end with ; but got %v at %d", t, env.idx)
+	}
+	env.idx++ // ;
+	ret.Children = []*Node{body}
+	if ty != nil {
+		ret.Children = append(ret.Children, ty)
+	}
+	return ret, env, nil
+}
+
+// type X = T;
+func parseTypeDef(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	if t := tokens[env.idx+1]; t.TokenType != Word {
+		return nil, env, fmt.Errorf("after type, there should be a name but got %v at %d", t, env.idx+1)
+	}
+	ret := &Node{NodeType: TypeDefinition, Name: tokens[env.idx+1].Text}
+	if t := tokens[env.idx+2]; t.TokenType != Equal {
+		return nil, env, fmt.Errorf("after a type name, there should be = but got %v at %d", t, env.idx+2)
+	}
+	env.idx += 3
+	ty, env, err := parseType(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	if t := tokens[env.idx]; t.TokenType != Semicolon {
+		return nil, env, fmt.Errorf("type definition should end with ; but got %v at %d", t, env.idx)
+	}
+	env.idx++ // ;
+	ret.Children = []*Node{ty}
+	return ret, env, nil
+}
+
+func isExpressionEnd(t *Token) bool {
+	switch t.TokenType {
+	case EOF, RParen, RBracket, RBlace, Comma, Semicolon, KeywordThen, KeywordElse, KeywordIn, KeywordWith, Bar:
+		return true
+	}
+	return false
+}
+
+// parseExpression parses terms until the end of an expression and applies them from left to right.
+// it returns nil node if there is no term.
+func parseExpression(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	return parseOperatorExpression(tokens, env, 0)
+}
+
+// parseApplication parses juxtaposed terms until an infix operator or the end of an expression.
+func parseApplication(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	var nodes []*Node
+	for !isExpressionEnd(tokens[env.idx]) && (!isInfixOperator(tokens[env.idx]) || isVariantStart(tokens, env.idx)) {
+		if tokens[env.idx].TokenType == LBracket && len(nodes) != 0 && looksLikeType(tokens, env.idx+1) { // t [T]
+			ty, nextEnv, err := parseTypeArgument(tokens, env)
+			if err != nil {
+				return nil, nextEnv, err
 			}
-			break parseLoop
+			env = nextEnv
+			app := &Node{NodeType: TypeApplication, Children: []*Node{foldNodes(nodes), ty}}
+			nodes = []*Node{app}
+			continue
+		}
+		if tokens[env.idx].TokenType == Word && len(nodes) != 0 { // `concat a b` is (concat a) b, not concat (a b)
+			nodes = append(nodes, buildVariableNode(env, tokens[env.idx].Text))
+			env.idx++
+			continue
 		}
+		t, nextEnv, err := parse(tokens, env)
+		if err != nil {
+			return nil, nextEnv, err
+		}
+		env = nextEnv
 		nodes = append(nodes, t)
 	}
-	if l := len(nodes); l == 0 {
-		return nil, errors.New("no nodes")
-	} else if l == 1 {
-		ret := &AST{Child: nodes[0]}
-		return ret, nil
+	if len(nodes) == 0 {
+		return nil, env, nil
 	}
-	app := nodesToApply(nodes)
-	ret := &AST{Child: app}
-	return ret, nil
+	return foldNodes(nodes), env, nil
+}
+
+func foldNodes(nodes []*Node) *Node {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	return nodesToApply(nodes)
 }
 
 func parse(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
@@ -94,8 +307,37 @@ func parse(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, erro
 		return parseIf(tokens, env)
 	case KeywordIsZero:
 		return parseIsZero(tokens, env)
+	case KeywordSucc:
+		return parseSucc(tokens, env)
+	case KeywordPred:
+		return parsePred(tokens, env)
+	case KeywordNil, KeywordCons, KeywordIsNil, KeywordHead, KeywordTail:
+		return parseListPrimitive(tokens, env)
+	case LBracket:
+		return parseList(tokens, env)
+	case String:
+		return parseString(tokens, env)
+	case KeywordConcat, KeywordStrLen, KeywordStrEq:
+		return parseStringPrimitive(tokens, env)
+	case KeywordCallCC, KeywordAbort:
+		return parseControlPrimitive(tokens, env)
+	case LBlace:
+		if tokens[env.idx+1].TokenType == Star {
+			return parsePack(tokens, env)
+		}
+		return parseTuple(tokens, env)
+	case Operator:
+		if isVariantStart(tokens, env.idx) {
+			return parseVariant(tokens, env)
+		}
+	case KeywordMatch:
+		return parseMatch(tokens, env)
+	case KeywordLet:
+		return parseLet(tokens, env)
 	case Dot: // start param
 		return parseDot(tokens, env)
+	case Backslash: // start type param
+		return parseBackslash(tokens, env)
 	case Word:
 		return parseWord(tokens, env)
 	}
@@ -109,12 +351,20 @@ func parseEOF(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, e
 }
 
 func parseLParen(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	if isInfixOperator(tokens[env.idx+1]) && tokens[env.idx+2].TokenType == RParen { // (+)
+		ret := buildOperatorNode(env, tokens[env.idx+1].Text)
+		env.idx += 3
+		return ret, env, nil
+	}
 	env.idx++
 	env.parenCount++
-	ret, nextEnv, err := parse(tokens, env)
+	ret, nextEnv, err := parseExpression(tokens, env)
 	if err != nil {
 		return nil, nextEnv, err
 	}
+	if ret == nil {
+		return nil, nextEnv, fmt.Errorf("empty parens at %d", env.idx)
+	}
 	if tokens[nextEnv.idx].TokenType != RParen {
 		return nil, env, fmt.Errorf("mismatch lparen at %d", env.idx)
 	}
@@ -127,14 +377,26 @@ func parseLParen(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt
 }
 
 func parseNumber(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
-	t := tokens[env.idx]
-	if t.Text == "0" {
-		env.idx++
-		ret := &Node{NodeType: Zero}
-		return ret, env, nil
+	ret, err := buildNumberNode(tokens[env.idx].Text)
+	if err != nil {
+		return nil, env, err
+	}
+	env.idx++
+	return ret, env, nil
+}
+
+// 0 is zero of Nat for TaPL, and the others are machine integers.
+// a number with the suffix i such as 0i is always a machine integer.
+func buildNumberNode(text string) (*Node, error) {
+	if text == "0" {
+		return &Node{NodeType: Zero}, nil
+	}
+	text = strings.TrimSuffix(text, "i")
+	i, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %v: %v", text, err)
 	}
-	err := fmt.Errorf("unknown number %v", t.Text)
-	return nil, env, err
+	return &Node{NodeType: NodeNumber, Name: strconv.FormatInt(i, 10)}, nil
 }
 
 func parseTrue(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
@@ -150,7 +412,7 @@ func parseFalse(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt,
 func parseIf(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
 	env.idx++ // if
 	ret := &Node{NodeType: IF, Children: make([]*Node, 3)}
-	cond, env, err := parse(tokens, env)
+	cond, env, err := parseExpressionOrError(tokens, env)
 	if err != nil {
 		return nil, env, err
 	}
@@ -160,7 +422,7 @@ func parseIf(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, er
 		return nil, env, err
 	}
 	env.idx++ // then
-	truePart, env, err := parse(tokens, env)
+	truePart, env, err := parseExpressionOrError(tokens, env)
 	if err != nil {
 		return nil, env, err
 	}
@@ -170,7 +432,7 @@ func parseIf(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, er
 		return nil, env, err
 	}
 	env.idx++ // else
-	falsePart, env, err := parse(tokens, env)
+	falsePart, env, err := parseExpressionOrError(tokens, env)
 	if err != nil {
 		return nil, env, err
 	}
@@ -178,46 +440,467 @@ func parseIf(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, er
 	return ret, env, err
 }
 
+// parseExpressionOrError is parseExpression which rejects an empty expression.
+func parseExpressionOrError(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	beg := env.idx
+	ret, env, err := parseExpression(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	if ret == nil {
+		return nil, env, fmt.Errorf("expression is expected at %d but got %v", beg, tokens[beg])
+	}
+	return ret, env, nil
+}
+
 func parseIsZero(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
 	env.idx++
 	return &Node{NodeType: IsZero}, env, nil
 }
 
+func parseSucc(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	env.idx++
+	return &Node{NodeType: Succ}, env, nil
+}
+
+func parsePred(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	env.idx++
+	return &Node{NodeType: Pred}, env, nil
+}
+
+func parseListPrimitive(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	var nt NodeType
+	switch t := tokens[env.idx]; t.TokenType {
+	case KeywordNil:
+		nt = Nil
+	case KeywordCons:
+		nt = Cons
+	case KeywordIsNil:
+		nt = IsNil
+	case KeywordHead:
+		nt = Head
+	case KeywordTail:
+		nt = Tail
+	default:
+		return nil, env, fmt.Errorf("unknown list primitive %v at %d", t, env.idx)
+	}
+	env.idx++
+	return &Node{NodeType: nt}, env, nil
+}
+
+func parseString(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	ret := &Node{NodeType: StringLiteral, Name: tokens[env.idx].Text}
+	env.idx++
+	return ret, env, nil
+}
+
+func parseStringPrimitive(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	var nt NodeType
+	switch t := tokens[env.idx]; t.TokenType {
+	case KeywordConcat:
+		nt = Concat
+	case KeywordStrLen:
+		nt = StrLen
+	case KeywordStrEq:
+		nt = StrEq
+	default:
+		return nil, env, fmt.Errorf("unknown string primitive %v at %d", t, env.idx)
+	}
+	env.idx++
+	return &Node{NodeType: nt}, env, nil
+}
+
+func parseControlPrimitive(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	var nt NodeType
+	switch t := tokens[env.idx]; t.TokenType {
+	case KeywordCallCC:
+		nt = CallCC
+	case KeywordAbort:
+		nt = Abort
+	default:
+		return nil, env, fmt.Errorf("unknown control primitive %v at %d", t, env.idx)
+	}
+	env.idx++
+	return &Node{NodeType: nt}, env, nil
+}
+
+// [t1, t2, ...] is cons t1 (cons t2 (... nil))
+func parseList(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	env.idx++ // [
+	var elems []*Node
+	if tokens[env.idx].TokenType != RBracket {
+		for {
+			var elem *Node
+			var err error
+			elem, env, err = parseExpressionOrError(tokens, env)
+			if err != nil {
+				return nil, env, err
+			}
+			elems = append(elems, elem)
+			if tokens[env.idx].TokenType != Comma {
+				break
+			}
+			env.idx++ // ,
+		}
+	}
+	if t := tokens[env.idx]; t.TokenType != RBracket {
+		return nil, env, fmt.Errorf("list should end with ] but got %v at %d", t, env.idx)
+	}
+	env.idx++ // ]
+	ret := &Node{NodeType: Nil}
+	for i := len(elems) - 1; i >= 0; i-- {
+		cons := &Node{NodeType: Apply, Children: []*Node{&Node{NodeType: Cons}, elems[i]}}
+		ret = &Node{NodeType: Apply, Children: []*Node{cons, ret}}
+	}
+	return ret, env, nil
+}
+
+// looksLikeType returns whether tokens from idx seem to start a type rather than a term.
+// Types are told by their capitalized names, so `f [Nat]` is a type application but `f [n]` is an application to a list.
+func looksLikeType(tokens []*Token, idx int) bool {
+	switch t := tokens[idx]; t.TokenType {
+	case KeywordAll, Backslash:
+		return true
+	case LBlace:
+		return tokens[idx+1].TokenType == KeywordSome || looksLikeType(tokens, idx+1)
+	case LParen:
+		return looksLikeType(tokens, idx+1)
+	case Operator: // <l: T>
+		return t.Text == "<" && tokens[idx+1].TokenType == Word && tokens[idx+2].TokenType == Colon
+	case Word:
+		name := t.Text
+		if i := strings.LastIndex(name, "::"); i >= 0 {
+			name = name[i+2:]
+		}
+		return unicode.IsUpper(rune(name[0]))
+	}
+	return false
+}
+
+// {*T, t} as {Some X, T'}
+func parsePack(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	env.idx++ // {
+	if t := tokens[env.idx]; t.TokenType != Star {
+		return nil, env, fmt.Errorf("package should start with * but got %v at %d", t, env.idx)
+	}
+	env.idx++ // *
+	hidden, env, err := parseType(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	if t := tokens[env.idx]; t.TokenType != Comma {
+		return nil, env, fmt.Errorf("after a hidden type, there should be a comma but got %v at %d", t, env.idx)
+	}
+	env.idx++ // ,
+	term, env, err := parseExpressionOrError(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	if t := tokens[env.idx]; t.TokenType != RBlace {
+		return nil, env, fmt.Errorf("package should be closed by } but got %v at %d", t, env.idx)
+	}
+	env.idx++ // }
+	if t := tokens[env.idx]; t.TokenType != KeywordAs {
+		return nil, env, fmt.Errorf("package should be annotated with as but got %v at %d", t, env.idx)
+	}
+	env.idx++ // as
+	ty, env, err := parseAtomicType(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	return &Node{NodeType: Pack, Children: []*Node{hidden, term, ty}}, env, nil
+}
+
+// {t1, t2, ...} or {}
+func parseTuple(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	env.idx++ // {
+	ret := &Node{NodeType: Tuple}
+	if tokens[env.idx].TokenType == RBlace { // {} such as the environment of a closure which captures nothing
+		env.idx++
+		return ret, env, nil
+	}
+	for {
+		elem, nextEnv, err := parseExpressionOrError(tokens, env)
+		if err != nil {
+			return nil, nextEnv, err
+		}
+		env = nextEnv
+		ret.Children = append(ret.Children, elem)
+		if tokens[env.idx].TokenType != Comma {
+			break
+		}
+		env.idx++ // ,
+	}
+	if t := tokens[env.idx]; t.TokenType != RBlace {
+		return nil, env, fmt.Errorf("tuple should be closed by } but got %v at %d", t, env.idx)
+	}
+	env.idx++ // }
+	return ret, env, nil
+}
+
+// isVariantStart returns whether tokens from idx start "<l =", which is not a comparison because = cannot be an operand.
+func isVariantStart(tokens []*Token, idx int) bool {
+	return tokens[idx].TokenType == Operator && tokens[idx].Text == "<" && tokens[idx+1].TokenType == Word && tokens[idx+2].TokenType == Equal
+}
+
+// <l = t> as <l: T, l': T'>
+// t is an application, so operations in it should be wrapped with parens as in <l = (x + 1)>.
+func parseVariant(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	ret := &Node{NodeType: Variant, Name: tokens[env.idx+1].Text}
+	env.idx += 3 // < l =
+	beg := env.idx
+	term, env, err := parseApplication(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	if term == nil {
+		return nil, env, fmt.Errorf("expression is expected at %d but got %v", beg, tokens[beg])
+	}
+	if t := tokens[env.idx]; t.TokenType != Operator || t.Text != ">" {
+		return nil, env, fmt.Errorf("variant should be closed by > but got %v at %d", t, env.idx)
+	}
+	env.idx++ // >
+	if t := tokens[env.idx]; t.TokenType != KeywordAs {
+		return nil, env, fmt.Errorf("variant should be annotated with as but got %v at %d", t, env.idx)
+	}
+	env.idx++ // as
+	ty, env, err := parseAtomicType(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	ret.Children = []*Node{term, ty}
+	return ret, env, nil
+}
+
+// let {X, x} = t in t'
+func parseLet(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	pattern := []TokenType{KeywordLet, LBlace, Word, Comma, Word, RBlace, Equal}
+	for i, tt := range pattern {
+		if t := tokens[env.idx+i]; t.TokenType != tt {
+			return nil, env, fmt.Errorf("unpacking should be like let {X, x} = t but got %v at %d", t, env.idx+i)
+		}
+	}
+	param := &Node{NodeType: LambdaParam, Name: tokens[env.idx+4].Text}
+	ret := &Node{NodeType: Unpack, Name: tokens[env.idx+2].Text}
+	env.idx += len(pattern)
+	bound, env, err := parseExpressionOrError(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	if t := tokens[env.idx]; t.TokenType != KeywordIn {
+		return nil, env, fmt.Errorf("after a bound term, there should be in but got %v at %d", t, env.idx)
+	}
+	env.idx++ // in
+	env.AddKnownWord(param.Name)
+	body, env, err := parseExpressionOrError(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	env.RemoveKnownWord(param.Name)
+	ret.Children = []*Node{param, bound, body}
+	return ret, env, nil
+}
+
+// match t with | p1 -> t1 | p2 -> t2 ...
+// a body of a case extends as far as possible, so a nested match should be wrapped with parens.
+func parseMatch(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	env.idx++ // match
+	scrutinee, env, err := parseExpressionOrError(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	if t := tokens[env.idx]; t.TokenType != KeywordWith {
+		return nil, env, fmt.Errorf("after a matched term, there should be with but got %v at %d", t, env.idx)
+	}
+	env.idx++ // with
+	ret := &Node{NodeType: Match, Children: []*Node{scrutinee}}
+	for tokens[env.idx].TokenType == Bar {
+		env.idx++ // |
+		var pattern *Node
+		pattern, env, err = parsePattern(tokens, env)
+		if err != nil {
+			return nil, env, err
+		}
+		vars, err := patternVariables(pattern)
+		if err != nil {
+			return nil, env, err
+		}
+		if t := tokens[env.idx]; t.TokenType != Arrow {
+			return nil, env, fmt.Errorf("after a pattern, there should be an arrow but got %v at %d", t, env.idx)
+		}
+		env.idx++ // ->
+		for _, v := range vars {
+			env.AddKnownWord(v)
+		}
+		var body *Node
+		body, env, err = parseExpressionOrError(tokens, env)
+		if err != nil {
+			return nil, env, err
+		}
+		for _, v := range vars {
+			env.RemoveKnownWord(v)
+		}
+		ret.Children = append(ret.Children, &Node{NodeType: MatchCase, Children: []*Node{pattern, body}})
+	}
+	if len(ret.Children) == 1 {
+		return nil, env, fmt.Errorf("match should have at least one case but got %v at %d", tokens[env.idx], env.idx)
+	}
+	return ret, env, nil
+}
+
+// succ p
+// cons p p'
+// or an atomic pattern
+func parsePattern(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	var ret *Node
+	switch tokens[env.idx].TokenType {
+	case KeywordSucc:
+		ret = &Node{NodeType: Succ, Children: make([]*Node, 1)}
+	case KeywordCons:
+		ret = &Node{NodeType: Cons, Children: make([]*Node, 2)}
+	default:
+		return parseAtomicPattern(tokens, env)
+	}
+	env.idx++
+	for i := range ret.Children {
+		var err error
+		ret.Children[i], env, err = parseAtomicPattern(tokens, env)
+		if err != nil {
+			return nil, env, err
+		}
+	}
+	return ret, env, nil
+}
+
+// x, _, 0, 1, true, false, nil, [p1, p2], {p1, p2}, <l = p> or (p)
+func parseAtomicPattern(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	switch t := tokens[env.idx]; t.TokenType {
+	case Word:
+		env.idx++
+		return &Node{NodeType: PatternVariable, Name: t.Text}, env, nil
+	case Number:
+		return parseNumber(tokens, env)
+	case KeywordTrue:
+		return parseTrue(tokens, env)
+	case KeywordFalse:
+		return parseFalse(tokens, env)
+	case KeywordNil:
+		env.idx++
+		return &Node{NodeType: Nil}, env, nil
+	case LBracket:
+		elems, env, err := parsePatterns(tokens, env, RBracket)
+		if err != nil {
+			return nil, env, err
+		}
+		ret := &Node{NodeType: Nil}
+		for i := len(elems) - 1; i >= 0; i-- {
+			ret = &Node{NodeType: Cons, Children: []*Node{elems[i], ret}}
+		}
+		return ret, env, nil
+	case LBlace:
+		elems, env, err := parsePatterns(tokens, env, RBlace)
+		if err != nil {
+			return nil, env, err
+		}
+		if len(elems) == 0 {
+			return nil, env, fmt.Errorf("tuple pattern should have elements at %d", env.idx)
+		}
+		return &Node{NodeType: Tuple, Children: elems}, env, nil
+	case Operator:
+		if !isVariantStart(tokens, env.idx) {
+			break
+		}
+		ret := &Node{NodeType: Variant, Name: tokens[env.idx+1].Text}
+		env.idx += 3 // < l =
+		p, env, err := parsePattern(tokens, env)
+		if err != nil {
+			return nil, env, err
+		}
+		if t := tokens[env.idx]; t.TokenType != Operator || t.Text != ">" {
+			return nil, env, fmt.Errorf("variant pattern should be closed by > but got %v at %d", t, env.idx)
+		}
+		env.idx++ // >
+		ret.Children = []*Node{p}
+		return ret, env, nil
+	case LParen:
+		env.idx++
+		ret, env, err := parsePattern(tokens, env)
+		if err != nil {
+			return nil, env, err
+		}
+		if t := tokens[env.idx]; t.TokenType != RParen {
+			return nil, env, fmt.Errorf("mismatch lparen in pattern at %d", env.idx)
+		}
+		env.idx++
+		return ret, env, nil
+	}
+	return nil, env, fmt.Errorf("there should be a pattern but got %v at %d", tokens[env.idx], env.idx)
+}
+
+// parsePatterns parses patterns separated by commas after an opening bracket until the closing token.
+func parsePatterns(tokens []*Token, env parseEnvironemnt, closing TokenType) ([]*Node, parseEnvironemnt, error) {
+	env.idx++ // [ or {
+	var ret []*Node
+	for tokens[env.idx].TokenType != closing {
+		p, nextEnv, err := parsePattern(tokens, env)
+		if err != nil {
+			return nil, nextEnv, err
+		}
+		env = nextEnv
+		ret = append(ret, p)
+		if tokens[env.idx].TokenType != Comma {
+			break
+		}
+		env.idx++ // ,
+	}
+	if t := tokens[env.idx]; t.TokenType != closing {
+		return nil, env, fmt.Errorf("patterns should be closed by %s but got %v at %d", closing, t, env.idx)
+	}
+	env.idx++
+	return ret, env, nil
+}
+
 // .x .y -> x y
+// .x:Nat .y:(Nat -> Bool) -> y x
 func parseDot(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
 	def := &Node{NodeType: LambdaDef}
 	body := &Node{NodeType: LambdaBody}
 	ret := &Node{NodeType: Lambda, Children: []*Node{def, body}}
 paramLoop:
-	for i := env.idx; ; {
-		if len(tokens) <= i+1 {
-			return nil, env, fmt.Errorf("after dot, there should be a variable but nothing at %d", i+1)
+	for {
+		if len(tokens) <= env.idx+1 {
+			return nil, env, fmt.Errorf("after dot, there should be a variable but nothing at %d", env.idx+1)
 		}
-		afterDot := tokens[i+1]
+		afterDot := tokens[env.idx+1]
 		if afterDot.TokenType != Word {
-			return nil, env, fmt.Errorf("after dot, there should be a variable but got %v at %d", afterDot, i+1)
+			return nil, env, fmt.Errorf("after dot, there should be a variable but got %v at %d", afterDot, env.idx+1)
 		}
-		def.Children = append(def.Children, &Node{NodeType: LambdaParam, Name: afterDot.Text})
-		if len(tokens) <= i+1 {
-			return nil, env, fmt.Errorf("after a parameter, there should be a dot or arrow but nothing at %d", i+1)
+		param := &Node{NodeType: LambdaParam, Name: afterDot.Text}
+		def.Children = append(def.Children, param)
+		env.idx += 2 // skip dot and parameter token
+		if tokens[env.idx].TokenType == Colon {
+			env.idx++
+			ty, nextEnv, err := parseAtomicType(tokens, env)
+			if err != nil {
+				return nil, nextEnv, err
+			}
+			env = nextEnv
+			param.Children = []*Node{ty}
 		}
-		i++ // skip parameter token
-		dotOrArrow := tokens[i+1]
-		switch dotOrArrow.TokenType {
+		switch dotOrArrow := tokens[env.idx]; dotOrArrow.TokenType {
 		case Arrow:
-			env.idx = i + 2 // skip arrow
+			env.idx++ // skip arrow
 			break paramLoop
 		case Dot:
-			i++
 		default:
-			return nil, env, fmt.Errorf("after a parameter, there should be a dot or arrow but got %v at %d", dotOrArrow, i+1)
+			return nil, env, fmt.Errorf("after a parameter, there should be a dot or arrow but got %v at %d", dotOrArrow, env.idx)
 		}
 	}
 
 	for _, p := range def.Children {
 		env.AddKnownWord(p.Name)
 	}
-	bc, env, err := parse(tokens, env)
+	bc, env, err := parseExpressionOrError(tokens, env)
 	if err != nil {
 		return nil, env, err
 	}
@@ -229,6 +912,346 @@ paramLoop:
 	return ret, env, nil
 }
 
+// \X -> t
+// \X <: T -> t
+// \X:K -> t
+func parseBackslash(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	if t := tokens[env.idx+1]; t.TokenType != Word {
+		return nil, env, fmt.Errorf("after backslash, there should be a type variable but got %v at %d", t, env.idx+1)
+	}
+	ret := &Node{NodeType: TypeAbstraction, Name: tokens[env.idx+1].Text}
+	env.idx += 2
+	bound, env, err := parseBound(tokens, env, parseAtomicType)
+	if err != nil {
+		return nil, env, err
+	}
+	if t := tokens[env.idx]; t.TokenType != Arrow {
+		return nil, env, fmt.Errorf("after a type parameter, there should be an arrow but got %v at %d", t, env.idx)
+	}
+	env.idx++ // ->
+	body, env, err := parseExpressionOrError(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	ret.Children = []*Node{body, bound}
+	return ret, env, nil
+}
+
+// parseBound parses "<: T" or ":K" if exists, otherwise it returns Top.
+func parseBound(tokens []*Token, env parseEnvironemnt, parseBoundType func([]*Token, parseEnvironemnt) (*Node, parseEnvironemnt, error)) (*Node, parseEnvironemnt, error) {
+	switch tokens[env.idx].TokenType {
+	case Subtype:
+		env.idx++ // <:
+		return parseBoundType(tokens, env)
+	case Colon:
+		env.idx++ // :
+		kind, env, err := parseKind(tokens, env)
+		if err != nil {
+			return nil, env, err
+		}
+		return topOfKind(kind), env, nil
+	}
+	return &Node{NodeType: TypeTop}, env, nil
+}
+
+// [T]
+func parseTypeArgument(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	env.idx++ // [
+	ret, env, err := parseType(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	if t := tokens[env.idx]; t.TokenType != RBracket {
+		return nil, env, fmt.Errorf("type argument should be closed by ] but got %v at %d", t, env.idx)
+	}
+	env.idx++ // ]
+	return ret, env, nil
+}
+
+// All X. T
+// \X -> T
+// T -> T -> T
+// F T T
+func parseType(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	switch tokens[env.idx].TokenType {
+	case KeywordAll:
+		return parseAll(tokens, env)
+	case Backslash:
+		return parseOperatorAbstraction(tokens, env)
+	}
+	left, env, err := parseOperatorApplication(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	if tokens[env.idx].TokenType != Arrow {
+		return left, env, nil
+	}
+	env.idx++ // ->
+	right, env, err := parseType(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	return &Node{NodeType: TypeArrow, Children: []*Node{left, right}}, env, nil
+}
+
+// F T U -> (F T) U
+func parseOperatorApplication(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	var ret *Node
+	var err error
+	if t := tokens[env.idx]; t.TokenType == Word && t.Text == "List" { // List T
+		env.idx++
+		var elem *Node
+		elem, env, err = parseAtomicType(tokens, env)
+		if err != nil {
+			return nil, env, err
+		}
+		ret = &Node{NodeType: TypeList, Children: []*Node{elem}}
+	} else {
+		ret, env, err = parseAtomicType(tokens, env)
+		if err != nil {
+			return nil, env, err
+		}
+	}
+	for {
+		switch tokens[env.idx].TokenType {
+		case Word, LParen, LBlace:
+		default:
+			return ret, env, nil
+		}
+		var arg *Node
+		arg, env, err = parseAtomicType(tokens, env)
+		if err != nil {
+			return nil, env, err
+		}
+		ret = &Node{NodeType: OperatorApplication, Children: []*Node{ret, arg}}
+	}
+}
+
+// \X -> T
+// \X:K -> T
+func parseOperatorAbstraction(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	if t := tokens[env.idx+1]; t.TokenType != Word {
+		return nil, env, fmt.Errorf("after backslash, there should be a type variable but got %v at %d", t, env.idx+1)
+	}
+	ret := &Node{NodeType: OperatorAbstraction, Name: tokens[env.idx+1].Text}
+	env.idx += 2
+	kind := &Node{NodeType: KindStar}
+	if tokens[env.idx].TokenType == Colon {
+		env.idx++ // :
+		var err error
+		kind, env, err = parseKind(tokens, env)
+		if err != nil {
+			return nil, env, err
+		}
+	}
+	if t := tokens[env.idx]; t.TokenType != Arrow {
+		return nil, env, fmt.Errorf("after a type parameter, there should be an arrow but got %v at %d", t, env.idx)
+	}
+	env.idx++ // ->
+	body, env, err := parseType(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	ret.Children = []*Node{body, kind}
+	return ret, env, nil
+}
+
+// *
+// * => * => *
+func parseKind(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	var left *Node
+	switch t := tokens[env.idx]; t.TokenType {
+	case Star:
+		env.idx++
+		left = &Node{NodeType: KindStar}
+	case LParen:
+		env.idx++
+		var err error
+		left, env, err = parseKind(tokens, env)
+		if err != nil {
+			return nil, env, err
+		}
+		if t := tokens[env.idx]; t.TokenType != RParen {
+			return nil, env, fmt.Errorf("mismatch lparen in kind at %d", env.idx)
+		}
+		env.idx++
+	default:
+		return nil, env, fmt.Errorf("there should be a kind but got %v at %d", t, env.idx)
+	}
+	if tokens[env.idx].TokenType != FatArrow {
+		return left, env, nil
+	}
+	env.idx++ // =>
+	right, env, err := parseKind(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	return &Node{NodeType: KindArrow, Children: []*Node{left, right}}, env, nil
+}
+
+// Bool, X, {Some X, T}, {T1, T2}, <l: T> or (T)
+func parseAtomicType(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	switch t := tokens[env.idx]; t.TokenType {
+	case LBlace:
+		if tokens[env.idx+1].TokenType == KeywordSome {
+			return parseSome(tokens, env)
+		}
+		return parseTupleType(tokens, env)
+	case Operator:
+		if t.Text != "<" {
+			return nil, env, fmt.Errorf("there should be a type but got %v at %d", t, env.idx)
+		}
+		return parseVariantType(tokens, env)
+	case Word:
+		env.idx++
+		return buildTypeNode(t.Text), env, nil
+	case LParen:
+		env.idx++
+		ret, env, err := parseType(tokens, env)
+		if err != nil {
+			return nil, env, err
+		}
+		if t := tokens[env.idx]; t.TokenType != RParen {
+			return nil, env, fmt.Errorf("mismatch lparen in type at %d", env.idx)
+		}
+		env.idx++
+		return ret, env, nil
+	default:
+		return nil, env, fmt.Errorf("there should be a type but got %v at %d", t, env.idx)
+	}
+}
+
+// All X. T
+// All X <: T. T'
+// All X:K. T
+func parseAll(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	if t := tokens[env.idx+1]; t.TokenType != Word {
+		return nil, env, fmt.Errorf("after All, there should be a type variable but got %v at %d", t, env.idx+1)
+	}
+	ret := &Node{NodeType: TypeAll, Name: tokens[env.idx+1].Text}
+	env.idx += 2
+	bound, env, err := parseBound(tokens, env, parseType)
+	if err != nil {
+		return nil, env, err
+	}
+	if t := tokens[env.idx]; t.TokenType != Dot {
+		return nil, env, fmt.Errorf("after a type variable, there should be a dot but got %v at %d", t, env.idx)
+	}
+	env.idx++ // .
+	body, env, err := parseType(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	ret.Children = []*Node{body, bound}
+	return ret, env, nil
+}
+
+// {Some X, T}
+// {Some X <: T, T'}
+// {Some X:K, T}
+func parseSome(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	pattern := []TokenType{LBlace, KeywordSome, Word}
+	for i, tt := range pattern {
+		if t := tokens[env.idx+i]; t.TokenType != tt {
+			return nil, env, fmt.Errorf("existential type should be like {Some X, T} but got %v at %d", t, env.idx+i)
+		}
+	}
+	ret := &Node{NodeType: TypeSome, Name: tokens[env.idx+2].Text}
+	env.idx += len(pattern)
+	bound, env, err := parseBound(tokens, env, parseType)
+	if err != nil {
+		return nil, env, err
+	}
+	if t := tokens[env.idx]; t.TokenType != Comma {
+		return nil, env, fmt.Errorf("after a type variable, there should be a comma but got %v at %d", t, env.idx)
+	}
+	env.idx++ // ,
+	body, env, err := parseType(tokens, env)
+	if err != nil {
+		return nil, env, err
+	}
+	if t := tokens[env.idx]; t.TokenType != RBlace {
+		return nil, env, fmt.Errorf("existential type should be closed by } but got %v at %d", t, env.idx)
+	}
+	env.idx++ // }
+	ret.Children = []*Node{body, bound}
+	return ret, env, nil
+}
+
+// {T1, T2, ...}
+func parseTupleType(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	env.idx++ // {
+	ret := &Node{NodeType: TypeTuple}
+	for {
+		elem, nextEnv, err := parseType(tokens, env)
+		if err != nil {
+			return nil, nextEnv, err
+		}
+		env = nextEnv
+		ret.Children = append(ret.Children, elem)
+		if tokens[env.idx].TokenType != Comma {
+			break
+		}
+		env.idx++ // ,
+	}
+	if t := tokens[env.idx]; t.TokenType != RBlace {
+		return nil, env, fmt.Errorf("tuple type should be closed by } but got %v at %d", t, env.idx)
+	}
+	env.idx++ // }
+	return ret, env, nil
+}
+
+// <l1: T1, l2: T2, ...>
+func parseVariantType(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt, error) {
+	env.idx++ // <
+	ret := &Node{NodeType: TypeVariant}
+	labels := make(map[string]bool)
+	for {
+		if t, c := tokens[env.idx], tokens[env.idx+1]; t.TokenType != Word || c.TokenType != Colon {
+			return nil, env, fmt.Errorf("variant type should be like <l: T> but got %v at %d", t, env.idx)
+		}
+		label := tokens[env.idx].Text
+		if labels[label] {
+			return nil, env, fmt.Errorf("duplicate label %s in variant type at %d", label, env.idx)
+		}
+		labels[label] = true
+		env.idx += 2 // l :
+		ty, nextEnv, err := parseType(tokens, env)
+		if err != nil {
+			return nil, nextEnv, err
+		}
+		env = nextEnv
+		ret.Children = append(ret.Children, &Node{NodeType: VariantField, Name: label, Children: []*Node{ty}})
+		if tokens[env.idx].TokenType != Comma {
+			break
+		}
+		env.idx++ // ,
+	}
+	if t := tokens[env.idx]; t.TokenType != Operator || t.Text != ">" {
+		return nil, env, fmt.Errorf("variant type should be closed by > but got %v at %d", t, env.idx)
+	}
+	env.idx++ // >
+	return ret, env, nil
+}
+
+func buildTypeNode(name string) *Node {
+	switch name {
+	case "Bool":
+		return &Node{NodeType: TypeBool}
+	case "Nat":
+		return &Node{NodeType: TypeNat}
+	case "Top":
+		return &Node{NodeType: TypeTop}
+	case "Bot":
+		return &Node{NodeType: TypeBot}
+	case "String":
+		return &Node{NodeType: TypeString}
+	case "Int":
+		return &Node{NodeType: TypeInt}
+	}
+	return &Node{NodeType: TypeVariable, Name: name}
+}
+
 func buildVariableNode(env parseEnvironemnt, name string) *Node {
 	var nt NodeType
 	if env.IsBound(name) {
@@ -270,10 +1293,7 @@ func parseWord(tokens []*Token, env parseEnvironemnt) (*Node, parseEnvironemnt,
 	env.idx++
 applyLoop:
 	for i := env.idx; ; {
-		switch t := tokens[i]; t.TokenType {
-		case RParen, EOF:
-			env.idx = i
-			break applyLoop
+		switch tokens[i].TokenType {
 		case Word:
 			v := buildVariableNode(env, tokens[i].Text)
 			nodes = append(nodes, v)
@@ -287,16 +1307,17 @@ applyLoop:
 			nodes = append(nodes, v)
 			i++
 		case Number:
-			v := &Node{NodeType: NodeNumber}
+			v, err := buildNumberNode(tokens[i].Text)
+			if err != nil {
+				return nil, env, err
+			}
 			nodes = append(nodes, v)
 			i++
-		case KeywordThen, KeywordElse:
+		default: // the rest is left to parseExpression
 			env.idx = i
 			break applyLoop
-		default:
-			return nil, env, fmt.Errorf("unexpected token %v at %d", t, i)
 		}
 	}
-	app := nodesToApply(nodes)
+	app := foldNodes(nodes)
 	return app, env, nil
 }
@@ -1,6 +1,8 @@
 package gtl
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -279,3 +281,327 @@ func Test_buildVariableNode(t *testing.T) {
 		t.Errorf("want %v but got %v\n", want, got)
 	}
 }
+
+func Test_parseBackslash(t *testing.T) {
+	ast := buildASTFromString(`\X -> .x:X -> x`)
+	n := ast.Child
+	if want, got := TypeAbstraction, n.NodeType; got != want {
+		t.Fatalf("want %v but got %v\n", want, got)
+	}
+	if want, got := "X", n.Name; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	param := n.Children[0].Children[0].Children[0]
+	if want, got := LambdaParam, param.NodeType; got != want {
+		t.Fatalf("want %v but got %v\n", want, got)
+	}
+	if want, got := TypeVariable, param.Children[0].NodeType; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+}
+
+func Test_parseTypeArgument(t *testing.T) {
+	ast := buildASTFromString("f [Nat] x [All X. X -> X]")
+	n := ast.Child
+	if want, got := TypeApplication, n.NodeType; got != want {
+		t.Fatalf("want %v but got %v\n", want, got)
+	}
+	if want, got := "All X. X -> X", n.Children[1].String(); got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	if want, got := "f [Nat] x", n.Children[0].String(); got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+}
+
+func Test_parseType(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"Bool", "Bool"},
+		{"Nat -> Bool -> Nat", "Nat -> Bool -> Nat"},
+		{"(Nat -> Bool) -> Nat", "(Nat -> Bool) -> Nat"},
+		{"All X. All Y. X -> Y", "All X. All Y. X -> Y"},
+		{"(All X. X) -> Nat", "(All X. X) -> Nat"},
+		{"List Nat -> Nat", "List Nat -> Nat"},
+		{"List (List Nat)", "List (List Nat)"},
+		{"List (Nat -> Nat)", "List (Nat -> Nat)"},
+		{"{Nat, Bool -> Bool}", "{Nat, Bool -> Bool}"},
+		{"<none: Top, some: {Nat, Nat}>", "<none: Top, some: {Nat, Nat}>"},
+		{"<a: Nat> -> Nat", "<a: Nat> -> Nat"},
+	}
+	for i, v := range testcases {
+		ast := buildASTFromString(fmt.Sprintf(".x:(%s) -> x", v.src))
+		ty := ast.Child.Children[0].Children[0].Children[0]
+		if got := ty.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+func Test_parseList(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"[]", "nil"},
+		{"[0]", "cons 0 nil"},
+		{"[0, succ 0, x]", "cons 0 (cons (succ 0) (cons x nil))"},
+		{"[[0], []]", "cons (cons 0 nil) (cons nil nil)"},
+		{"f [x]", "f (cons x nil)"},
+		{"f [Nat]", "f [Nat]"},
+		{"f [B::x]", "f (cons B::x nil)"},
+		{"nil [List Nat]", "nil [List Nat]"},
+		{"cons [Nat] 0 (nil [Nat])", "cons [Nat] 0 (nil [Nat])"},
+	}
+	for i, v := range testcases {
+		ast := buildASTFromString(v.src)
+		if got := ast.Child.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+func Test_parseBinaryOperation(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"1 + 2", "(1 + 2)"},
+		{"1 + 2 * 3", "(1 + (2 * 3))"},
+		{"1 * 2 + 3", "((1 * 2) + 3)"},
+		{"1 - 2 - 3", "((1 - 2) - 3)"},
+		{"1 + 2 < 3 * 4", "((1 + 2) < (3 * 4))"},
+		{"(1 + 2) * 3", "((1 + 2) * 3)"},
+		{"f x + g y", "(f x + g y)"},
+		{".x -> x * x", ".x -> ((x * x))"},
+		{"if x == 0 then 1 else x / 2", "if ((x == 0)) then (1) else ((x / 2))"},
+		{"[1 + 1, 2]", "cons (1 + 1) (cons 2 nil)"},
+	}
+	for i, v := range testcases {
+		ast := buildASTFromString(v.src)
+		if got := ast.Child.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+	for i, src := range []string{"1 +", "* 2", "1 + * 2", "99999999999999999999"} {
+		tokens, err := Tokenize(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Parse(tokens); err == nil {
+			t.Errorf("case %d: %q should not be parsed", i, src)
+		}
+	}
+}
+
+func Test_parseTupleAndVariant(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"{0, true}", "{0, true}"},
+		{"{f x, {y}}", "{f x, {y}}"},
+		{"<some = f 0> as <none: Top, some: Nat>", "<some = f 0> as <none: Top, some: Nat>"},
+		{"f <a = x> as T", "f (<a = x> as T)"},
+		{"x < y", "(x < y)"},
+		{"[<a = 0> as <a: Nat>]", "cons (<a = 0> as <a: Nat>) nil"},
+		{"f [{Nat, Bool}]", "f [{Nat, Bool}]"},
+	}
+	for i, v := range testcases {
+		ast := buildASTFromString(v.src)
+		if got := ast.Child.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+	for i, src := range []string{"{0,}", "<a = 0> as", "<a = 0 as T", "<a = > as T", ".x:<a: Nat, a: Bool> -> x"} {
+		tokens, err := Tokenize(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Parse(tokens); err == nil {
+			t.Errorf("case %d: %q should not be parsed", i, src)
+		}
+	}
+}
+
+func Test_parsePack(t *testing.T) {
+	ast := buildASTFromString("{*Nat, .x:Nat -> x} as {Some X, X -> X}")
+	n := ast.Child
+	if want, got := Pack, n.NodeType; got != want {
+		t.Fatalf("want %v but got %v\n", want, got)
+	}
+	if want, got := TypeNat, n.Children[0].NodeType; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	if want, got := Lambda, n.Children[1].NodeType; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	if want, got := "{Some X, X -> X}", n.Children[2].String(); got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+}
+
+func Test_parseLet(t *testing.T) {
+	ast := buildASTFromString("let {X, x} = p in f x")
+	n := ast.Child
+	if want, got := Unpack, n.NodeType; got != want {
+		t.Fatalf("want %v but got %v\n", want, got)
+	}
+	if want, got := "X", n.Name; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	if want, got := "x", n.Children[0].Name; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	if want, got := FreeVariable, n.Children[1].NodeType; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	if want, got := Variable, n.Children[2].Children[1].NodeType; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+}
+
+func TestParse_declarations(t *testing.T) {
+	ast := buildASTFromString(`
+def id = .x -> x;
+type Fn = Nat -> Nat;
+def twice : (Fn -> Fn) = .f:Fn .x:Nat -> f (f x);
+twice id 0
+`)
+	if want, got := 3, len(ast.Declarations); got != want {
+		t.Fatalf("want %v but got %v\n", want, got)
+	}
+	wants := []struct {
+		nodeType NodeType
+		name     string
+	}{
+		{Definition, "id"},
+		{TypeDefinition, "Fn"},
+		{Definition, "twice"},
+	}
+	for i, v := range wants {
+		d := ast.Declarations[i]
+		if d.NodeType != v.nodeType || d.Name != v.name {
+			t.Errorf("case %d: want %v %v but got %v %v\n", i, v.nodeType, v.name, d.NodeType, d.Name)
+		}
+	}
+	if want, got := 2, len(ast.Declarations[2].Children); got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	// defined names are bound in the main expression
+	if want, got := Variable, ast.Child.Children[0].Children[0].NodeType; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	// and in the definitions before them
+	ast = buildASTFromString("def even = .n -> odd n; def odd = .n -> even n; even")
+	if want, got := Variable, ast.Declarations[0].Children[0].Children[1].Children[0].Children[0].NodeType; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+}
+
+func TestParse_imports(t *testing.T) {
+	tokens, err := Tokenize(`
+import "bool.tl";
+import "lib/nat.tl" as N;
+def x = N::iseven 0;
+export x, not;
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decls, main, err := parseProgram(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if main != nil {
+		t.Errorf("a module should not need a main expression but got %v", main)
+	}
+	wants := []string{
+		`import "bool.tl";`,
+		`import "lib/nat.tl" as N;`,
+		`def x = N::iseven 0;`,
+		`export x;`,
+		`export not;`,
+	}
+	if want, got := len(wants), len(decls); got != want {
+		t.Fatalf("want %v but got %v\n", want, got)
+	}
+	for i, want := range wants {
+		if got := decls[i].String(); got != want {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+	}
+}
+
+func TestParse_declarationError(t *testing.T) {
+	testcases := []string{
+		"def x = 0",
+		"def = 0; x",
+		"type X Nat; 0",
+		"def x = 0;",
+		"import bool; 0",
+		"import \"bool.tl\" as; 0",
+		"export; 0",
+	}
+	for i, v := range testcases {
+		l := NewLexer(v)
+		var tokens []*Token
+		for l.HasNext() {
+			tok, err := l.NextToken()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens = append(tokens, tok)
+		}
+		if _, err := Parse(tokens); err == nil {
+			t.Errorf("case %d: %q should not be parsed", i, v)
+		}
+	}
+}
+
+// programString prints a program like tl-eval --emit does.
+func programString(ast *AST) string {
+	var lines []string
+	for _, d := range ast.Declarations {
+		lines = append(lines, d.String())
+	}
+	return strings.Join(append(lines, ast.Child.String()), "\n")
+}
+
+// reparse parses a printed program again. it is an error unless the result is printed in the same way.
+func reparse(ast *AST) (*AST, error) {
+	src := programString(ast)
+	tokens, err := Tokenize(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", src, err)
+	}
+	ret, err := Parse(tokens)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", src, err)
+	}
+	if got := programString(ret); got != src {
+		return nil, fmt.Errorf("%s is parsed as %s", src, got)
+	}
+	return ret, nil
+}
+
+func TestNode_String(t *testing.T) {
+	for i, src := range append([]string{
+		"f (g x) (.y -> y) (if b then 0 else 1)",
+		"(.x -> x) 0",
+		"(\\X -> .x:X -> x) [Nat]",
+		"f (match x with | 0 -> 0 | _ -> 1) (let {X, x} = p in x)",
+		"(match x with | 0 -> .y -> y) 0",
+		"f (succ 0) (cons x) (cons x y) [x, y] (x [Nat])",
+		"f ({*Nat, 0} as {Some X, X}) (<a = 0> as <a: Nat>) 1",
+		"(f x + g (h y)) * 2",
+		"(.x -> x) 1 + 2",
+		"match match x with | 0 -> y with | _ -> 0",
+	}, evalSources...) {
+		if _, err := reparse(buildASTFromString(src)); err != nil {
+			t.Errorf("case %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,532 @@
+package gtl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// specializationLimit is the number of specialized definitions of a function, after which
+// the function is specialized with no static arguments. without the limit, a static argument
+// which changes in every call, such as a counter, would make infinitely many definitions.
+const specializationLimit = 10
+
+// unfoldingLimit is the number of applications of lambdas which are unfolded in the body of a lambda,
+// after which applications are residualized. Eval does not evaluate the bodies, so they may not terminate,
+// such as `.y -> (.x -> x x) (.x -> x x)`. calls of definitions are specialized instead.
+const unfoldingLimit = 1000
+
+// PartialEval specializes a program to inputs, which are values of some of its free variables.
+// It evaluates what depends only on the program and the inputs, like Eval, and residualizes the rest
+// into a program which takes the other free variables. Unlike stuck terms of Eval, the residual program
+// computes each unknown term once: a term which is not a value is bound by a lambda before it is used.
+// A call of a defined function with unknown arguments becomes a call of a new definition, which is
+// specialized to the known arguments and shared by calls with the same ones, so recursion terminates.
+// As Eval does for stuck ifs, both branches of an unknown condition are specialized.
+// The bodies of lambdas are specialized although Eval does not evaluate them, so a call of a definition
+// there is always specialized, and other lambdas there are applied at most unfoldingLimit times.
+// The residual program is optimized by Optimize.
+func PartialEval(ast *AST, inputs map[string]*Node) (*AST, error) {
+	p := &partialEvaluator{
+		names:       newNameSupply(ast),
+		globals:     make(map[string]*Node),
+		inputs:      make(map[string]*Node),
+		dynamic:     make(map[string]bool),
+		specialized: make(map[string]string),
+		count:       make(map[string]int),
+	}
+	for name, t := range inputs {
+		v, err := p.eval(t)
+		if err != nil {
+			return nil, fmt.Errorf("input %s: %v", name, err)
+		}
+		if !p.isStatic(v) {
+			return nil, fmt.Errorf("input %s is not a value: %v", name, t)
+		}
+		p.inputs[name] = v
+	}
+	var unknown func(n *Node)
+	unknown = func(n *Node) {
+		if n.NodeType == FreeVariable && p.inputs[n.Name] == nil {
+			p.dynamic[n.Name] = true
+		}
+		for _, c := range n.Children {
+			unknown(c)
+		}
+	}
+	for _, d := range ast.Declarations {
+		unknown(d)
+	}
+	unknown(ast.Child)
+	ret := &AST{}
+	for _, d := range ast.Declarations {
+		if d.NodeType != Definition {
+			ret.Declarations = append(ret.Declarations, d)
+			continue
+		}
+		v, err := p.eval(d.Children[0])
+		if err != nil {
+			return nil, fmt.Errorf("def %s: %v", d.Name, err)
+		}
+		ret.Declarations = append(ret.Declarations, p.residual...)
+		p.residual = nil
+		if p.isStatic(v) {
+			p.globals[d.Name] = v
+			continue
+		}
+		t, err := p.residualize(v)
+		if err != nil {
+			return nil, fmt.Errorf("def %s: %v", d.Name, err)
+		}
+		ret.Declarations = append(ret.Declarations, p.residual...)
+		ret.Declarations = append(ret.Declarations, &Node{NodeType: Definition, Name: d.Name, Children: []*Node{t}})
+		p.residual = nil
+		p.dynamic[d.Name] = true
+	}
+	v, err := p.eval(ast.Child)
+	if err != nil {
+		return nil, err
+	}
+	if ret.Child, err = p.residualize(v); err != nil {
+		return nil, err
+	}
+	ret.Declarations = append(ret.Declarations, p.residual...)
+	return Optimize(ret), nil
+}
+
+type partialEvaluator struct {
+	names       nameSupply
+	globals     map[string]*Node  // static values of definitions
+	inputs      map[string]*Node  // values of free variables
+	dynamic     map[string]bool   // variables which are bound in the residual program, and unknown free variables
+	specialized map[string]string // definitions of specialized functions by functions and static arguments
+	count       map[string]int    // the number of specialized definitions by functions
+	residual    []*Node           // specialized definitions, which are added before the current declaration
+	lambdas     int               // the number of lambdas whose bodies are being residualized
+	unfoldings  int               // the number of applications of lambdas unfolded in the bodies
+}
+
+// eval returns a static value of n, or a residual term if n depends on unknown values.
+// a static value may have lambdas whose bodies are not evaluated yet, as values of Eval have,
+// and it is turned into a term by residualize. a residual term has been residualized already.
+func (p *partialEvaluator) eval(n *Node) (*Node, error) {
+	switch n.NodeType {
+	case Variable:
+		if v, ok := p.globals[n.Name]; ok && !p.dynamic[n.Name] {
+			return v, nil
+		}
+		return n, nil
+	case FreeVariable:
+		if v, ok := p.inputs[n.Name]; ok {
+			return v, nil
+		}
+		return n, nil
+	case Apply:
+		return p.evalApply(n)
+	case IF:
+		return p.evalIf(n)
+	case TypeApplication:
+		return p.evalTypeApplication(n)
+	case Tuple:
+		ret := &Node{NodeType: Tuple, Children: make([]*Node, len(n.Children))}
+		for i, c := range n.Children {
+			v, err := p.eval(c)
+			if err != nil {
+				return nil, err
+			}
+			ret.Children[i] = v
+		}
+		return ret, nil
+	case Variant:
+		v, err := p.eval(n.Children[0])
+		if err != nil {
+			return nil, err
+		}
+		return &Node{NodeType: Variant, Name: n.Name, Children: []*Node{v, n.Children[1]}}, nil
+	case Pack:
+		v, err := p.eval(n.Children[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Node{NodeType: Pack, Children: []*Node{n.Children[0], v, n.Children[2]}}, nil
+	case Unpack:
+		return p.evalUnpack(n)
+	case Match:
+		return p.evalMatch(n)
+	}
+	return n, nil
+}
+
+func (p *partialEvaluator) evalApply(n *Node) (*Node, error) {
+	var args []*Node
+	f := n
+	for ; f.NodeType == Apply; f = f.Children[0] {
+		args = append([]*Node{f.Children[1]}, args...)
+	}
+	l, err := p.eval(f)
+	if err != nil {
+		return nil, err
+	}
+	for i, a := range args {
+		if args[i], err = p.eval(a); err != nil {
+			return nil, err
+		}
+	}
+	if f.NodeType == Variable && l.NodeType == Lambda && l == p.globals[f.Name] {
+		// Eval does not evaluate the body of a lambda, so a call in it may not terminate even if its
+		// arguments are static, such as `w w` where `def w = .x -> x x`. it is specialized instead.
+		if arity := len(l.Children[0].Children); len(args) >= arity {
+			specialize := p.lambdas > 0
+			for _, a := range args[:arity] {
+				specialize = specialize || !p.isStatic(a)
+			}
+			if specialize {
+				if l, err = p.specialize(f.Name, l, args[:arity]); err != nil {
+					return nil, err
+				}
+				args = args[arity:]
+			}
+		}
+	}
+	for _, a := range args {
+		if l, err = p.apply(l, a); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// apply applies f to a, where both are evaluated.
+func (p *partialEvaluator) apply(f, a *Node) (*Node, error) {
+	switch {
+	case f.NodeType == Lambda:
+		if !isSyntacticValue(a) { // bind a to a variable, so the residual program evaluates it once
+			return p.bind(a, func(v *Node) (*Node, error) {
+				return p.apply(f, v)
+			})
+		}
+		if p.lambdas > 0 {
+			if p.unfoldings >= unfoldingLimit {
+				break
+			}
+			p.unfoldings++
+		}
+		body, saturated := applyLambda(f, a)
+		if !saturated {
+			return body, nil
+		}
+		return p.eval(body)
+	case isControlPrimitive(f):
+		return nil, fmt.Errorf("%s is not supported by the partial evaluator", f)
+	case f.IsApplyable() && isSyntacticValue(a):
+		if v, err := applyPrimitive(f, a); err == nil && v.NodeType != Apply {
+			return v, nil
+		}
+	}
+	// the application is stuck, or it fails only if the residual program evaluates it
+	l, err := p.residualize(f)
+	if err != nil {
+		return nil, err
+	}
+	r, err := p.residualize(a)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{NodeType: Apply, Children: []*Node{l, r}}, nil
+}
+
+// bind returns a residual term `(.t -> body) a` where body is built from the variable t.
+func (p *partialEvaluator) bind(a *Node, body func(*Node) (*Node, error)) (*Node, error) {
+	t := p.names.fresh("t")
+	p.dynamic[t] = true
+	v, err := body(&Node{NodeType: Variable, Name: t})
+	if err != nil {
+		return nil, err
+	}
+	if v, err = p.residualize(v); err != nil {
+		return nil, err
+	}
+	if a, err = p.residualize(a); err != nil {
+		return nil, err
+	}
+	return applyTerms(untypedLambda([]string{t}, v), a), nil
+}
+
+// specialize returns a call of the definition of a function f specialized to the static ones of args.
+// if every argument is static, the last one is not, so the definition is a function which is evaluated
+// when it is called, and a call in its body with the same arguments refers to the definition.
+func (p *partialEvaluator) specialize(f string, l *Node, args []*Node) (*Node, error) {
+	static := make([]bool, len(args))
+	all := true
+	for i, a := range args {
+		static[i] = p.count[f] < specializationLimit && p.isStatic(a)
+		all = all && static[i]
+	}
+	static[len(args)-1] = static[len(args)-1] && !all
+	key := []string{f}
+	for i, a := range args {
+		if static[i] {
+			key = append(key, a.String())
+		} else {
+			key = append(key, "_")
+		}
+	}
+	var dynamic []*Node
+	for i, a := range args {
+		if !static[i] {
+			v, err := p.residualize(a)
+			if err != nil {
+				return nil, err
+			}
+			dynamic = append(dynamic, v)
+		}
+	}
+	if name, ok := p.specialized[strings.Join(key, " ")]; ok {
+		return applyTerms(&Node{NodeType: Variable, Name: name}, dynamic...), nil
+	}
+	name := p.names.fresh(specializedName(f))
+	p.specialized[strings.Join(key, " ")] = name
+	p.count[f]++
+	var params []string
+	body := l
+	for i, a := range args {
+		if !static[i] {
+			param := p.names.fresh(body.Children[0].Children[0].Name)
+			p.dynamic[param] = true
+			params = append(params, param)
+			a = &Node{NodeType: Variable, Name: param}
+		}
+		body, _ = applyLambda(body, a)
+	}
+	v, err := p.eval(body)
+	if err != nil {
+		return nil, err
+	}
+	if v, err = p.residualize(v); err != nil {
+		return nil, err
+	}
+	p.residual = append(p.residual, &Node{NodeType: Definition, Name: name, Children: []*Node{untypedLambda(params, v)}})
+	return applyTerms(&Node{NodeType: Variable, Name: name}, dynamic...), nil
+}
+
+// specializedName returns the base name of specialized definitions of f, which is f unless it is an operator.
+func specializedName(f string) string {
+	if isOperatorName(f) {
+		return "op"
+	}
+	return f
+}
+
+func (p *partialEvaluator) evalIf(n *Node) (*Node, error) {
+	cond, err := p.eval(n.Children[0])
+	if err != nil {
+		return nil, err
+	}
+	switch cond.NodeType {
+	case True:
+		return p.eval(n.Children[1])
+	case False:
+		return p.eval(n.Children[2])
+	}
+	ret := &Node{NodeType: IF, Children: []*Node{cond, n.Children[1], n.Children[2]}}
+	for i, c := range ret.Children {
+		if i != 0 {
+			if c, err = p.eval(c); err != nil {
+				return nil, err
+			}
+		}
+		if ret.Children[i], err = p.residualize(c); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func (p *partialEvaluator) evalTypeApplication(n *Node) (*Node, error) {
+	f, err := p.eval(n.Children[0])
+	if err != nil {
+		return nil, err
+	}
+	if isListPrimitive(f) || isControlPrimitive(f) { // nil[T] is nil at runtime
+		return f, nil
+	}
+	if f.NodeType == TypeAbstraction {
+		return p.eval(substType(f.Children[0], f.Name, n.Children[1]))
+	}
+	if f, err = p.residualize(f); err != nil {
+		return nil, err
+	}
+	return &Node{NodeType: TypeApplication, Children: []*Node{f, n.Children[1]}}, nil
+}
+
+func (p *partialEvaluator) evalUnpack(n *Node) (*Node, error) {
+	bound, err := p.eval(n.Children[1])
+	if err != nil {
+		return nil, err
+	}
+	if bound.NodeType == Pack && isSyntacticValue(bound.Children[1]) {
+		body := substType(n.Children[2], n.Name, bound.Children[0])
+		return p.eval(substTerm(body, n.Children[0].Name, bound.Children[1]))
+	}
+	if bound, err = p.residualize(bound); err != nil {
+		return nil, err
+	}
+	x := p.names.fresh(n.Children[0].Name)
+	p.dynamic[x] = true
+	body, err := p.eval(substTerm(n.Children[2], n.Children[0].Name, &Node{NodeType: Variable, Name: x}))
+	if err != nil {
+		return nil, err
+	}
+	if body, err = p.residualize(body); err != nil {
+		return nil, err
+	}
+	return &Node{NodeType: Unpack, Name: n.Name, Children: []*Node{&Node{NodeType: LambdaParam, Name: x}, bound, body}}, nil
+}
+
+// evalMatch follows the decision tree of a match as evalMatch does. if a part of the value which
+// the tree tests is unknown, or no case matches, the match is residualized.
+func (p *partialEvaluator) evalMatch(n *Node) (*Node, error) {
+	v, err := p.eval(n.Children[0])
+	if err != nil {
+		return nil, err
+	}
+	if !isSyntacticValue(v) {
+		return p.bind(v, func(v *Node) (*Node, error) {
+			return p.evalMatch(&Node{NodeType: Match, Children: append([]*Node{v}, n.Children[1:]...)})
+		})
+	}
+	tree, _ := compileMatch(n, nil, nil)
+	for tree != nil && len(tree.cases) != 0 {
+		part := valueAt(v, tree.occurrence)
+		if !isConstructorValue(part) {
+			tree = nil
+			break
+		}
+		next := tree.fallback
+		for _, c := range tree.cases {
+			if c.constructor.matches(part) {
+				next = c.tree
+				break
+			}
+		}
+		tree = next
+	}
+	if tree != nil && tree.arm >= 0 {
+		body := n.Children[tree.arm+1].Children[1]
+		for _, b := range tree.bindings {
+			body = substTerm(body, b.name, valueAt(v, b.occurrence))
+		}
+		return p.eval(body)
+	}
+	if v, err = p.residualize(v); err != nil {
+		return nil, err
+	}
+	ret := &Node{NodeType: Match, Children: []*Node{v}}
+	for _, mc := range n.Children[1:] {
+		pattern, body := p.renamePattern(mc.Children[0], mc.Children[1])
+		if body, err = p.eval(body); err != nil {
+			return nil, err
+		}
+		if body, err = p.residualize(body); err != nil {
+			return nil, err
+		}
+		ret.Children = append(ret.Children, &Node{NodeType: MatchCase, Children: []*Node{pattern, body}})
+	}
+	return ret, nil
+}
+
+// renamePattern replaces the variables of a pattern with fresh ones, which are bound in the residual program.
+func (p *partialEvaluator) renamePattern(pattern, body *Node) (*Node, *Node) {
+	if pattern.NodeType == PatternVariable {
+		if pattern.Name == "_" {
+			return pattern, body
+		}
+		x := p.names.fresh(pattern.Name)
+		p.dynamic[x] = true
+		return &Node{NodeType: PatternVariable, Name: x}, substTerm(body, pattern.Name, &Node{NodeType: Variable, Name: x})
+	}
+	if len(pattern.Children) == 0 {
+		return pattern, body
+	}
+	ret := &Node{NodeType: pattern.NodeType, Name: pattern.Name, Children: make([]*Node, len(pattern.Children))}
+	for i, c := range pattern.Children {
+		ret.Children[i], body = p.renamePattern(c, body)
+	}
+	return ret, body
+}
+
+// residualize returns a term of v. the bodies of lambdas in a static value are specialized
+// with their parameters unknown, and values of Eval are written as the parser makes them.
+func (p *partialEvaluator) residualize(v *Node) (*Node, error) {
+	switch v.NodeType {
+	case Lambda:
+		var params []string
+		body := v.Children[1].Children[0]
+		for _, param := range v.Children[0].Children {
+			x := p.names.fresh(param.Name)
+			p.dynamic[x] = true
+			params = append(params, x)
+			body = substTerm(body, param.Name, &Node{NodeType: Variable, Name: x})
+		}
+		p.enterLambda()
+		defer p.exitLambda()
+		t, err := p.eval(body)
+		if err != nil {
+			return nil, err
+		}
+		if t, err = p.residualize(t); err != nil {
+			return nil, err
+		}
+		return untypedLambda(params, t), nil
+	case TypeAbstraction:
+		p.enterLambda()
+		defer p.exitLambda()
+		t, err := p.eval(v.Children[0])
+		if err != nil {
+			return nil, err
+		}
+		if t, err = p.residualize(t); err != nil {
+			return nil, err
+		}
+		return &Node{NodeType: TypeAbstraction, Name: v.Name, Children: []*Node{t, v.Children[1]}}, nil
+	case Tuple, Variant, Pack, Succ, Cons, BinaryOperator, Concat, StrEq:
+		if len(v.Children) == 0 {
+			return v, nil
+		}
+		ret := &Node{NodeType: v.NodeType, Name: v.Name, Children: make([]*Node, len(v.Children))}
+		for i, c := range v.Children {
+			t, err := p.residualize(c)
+			if err != nil {
+				return nil, err
+			}
+			ret.Children[i] = t
+		}
+		return sourceTerm(ret), nil
+	}
+	return v, nil
+}
+
+// enterLambda and exitLambda surround the evaluation of the body of a lambda or a type abstraction.
+// the unfoldings are counted until the outermost one is residualized.
+func (p *partialEvaluator) enterLambda() {
+	p.lambdas++
+}
+
+func (p *partialEvaluator) exitLambda() {
+	if p.lambdas--; p.lambdas == 0 {
+		p.unfoldings = 0
+	}
+}
+
+// isStatic reports whether v is a value which has no variables bound in the residual program,
+// so the value is known at any place of the program.
+func (p *partialEvaluator) isStatic(v *Node) bool {
+	if !isSyntacticValue(v) {
+		return false
+	}
+	for _, x := range freeVariables(v) {
+		if p.dynamic[x] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+package gtl
+
+import (
+	"strings"
+	"testing"
+)
+
+// substInputs replaces free variables of a program with values.
+func substInputs(ast *AST, inputs map[string]*Node) *AST {
+	var subst func(n *Node) *Node
+	subst = func(n *Node) *Node {
+		if v, ok := inputs[n.Name]; ok && n.NodeType == FreeVariable {
+			return v
+		}
+		if len(n.Children) == 0 {
+			return n
+		}
+		ret := &Node{NodeType: n.NodeType, Name: n.Name, Children: make([]*Node, len(n.Children))}
+		for i, c := range n.Children {
+			ret.Children[i] = subst(c)
+		}
+		return ret
+	}
+	ret := &AST{Child: subst(ast.Child)}
+	for _, d := range ast.Declarations {
+		ret.Declarations = append(ret.Declarations, subst(d))
+	}
+	return ret
+}
+
+func parseInputs(inputs map[string]string) map[string]*Node {
+	ret := make(map[string]*Node)
+	for name, src := range inputs {
+		ret[name] = buildASTFromString(src).Child
+	}
+	return ret
+}
+
+var partialSources = []struct {
+	src     string
+	static  map[string]string
+	dynamic map[string]string
+}{
+	{"iszero y", nil, map[string]string{"y": "0"}},
+	{"if iszero y then x + 1 else 0", map[string]string{"x": "2"}, map[string]string{"y": "0"}},
+	{"(.a .b -> {a, b, a}) (f 0) x", map[string]string{"f": ".n -> n + 1"}, map[string]string{"x": "true"}},
+	{"(.a -> {a, a}) (f 0)", nil, map[string]string{"f": ".n -> n + 1"}},
+	{"def power = .n .x -> if n == 0 then 1 else x * power (n - 1) x; power n x", map[string]string{"n": "3"}, map[string]string{"x": "2"}},
+	{"def power = .n .x -> if n == 0 then 1 else x * power (n - 1) x; power n x", map[string]string{"x": "2"}, map[string]string{"n": "3"}},
+	{"def fact = .n -> if n < 2 then 1 else n * fact (n - 1); fact 5 + fact n", nil, map[string]string{"n": "4"}},
+	{"def count = .n .acc -> if n == 0 then acc else count (n - 1) (acc + 1); count n 0", nil, map[string]string{"n": "15"}},
+	{"def even = .n -> if n == 0 then true else odd (n - 1); def odd = .n -> if n == 0 then false else even (n - 1); {even n, odd m}", map[string]string{"m": "3"}, map[string]string{"n": "4"}},
+	{"def sum = .l -> match l with | [] -> 0 | cons x rest -> x + sum rest; sum (cons x [2, 3])", nil, map[string]string{"x": "1"}},
+	{"match {x, 1} with | {true, n} -> n | {false, _} -> 0", nil, map[string]string{"x": "true"}},
+	{"match {x, y} with | {0, n} -> n | {m, n} -> m + n", map[string]string{"y": "1"}, map[string]string{"x": "2"}},
+	{"let {X, x} = p in {x, x}", nil, map[string]string{"p": "{*Nat, 0} as {Some X, X}"}},
+	{"if b then 1 / 0 else 1", nil, map[string]string{"b": "false"}},
+	{`(\X -> .x:X -> x) [Nat] y`, nil, map[string]string{"y": "0"}},
+	{"def twice = .f .x -> f (f x); twice (.n -> n * k) x", map[string]string{"k": "3"}, map[string]string{"x": "1"}},
+	{"def map = .f .l -> if isnil l then l else cons (f (head l)) (map f (tail l)); map (.n -> n + k) l", map[string]string{"k": "1"}, map[string]string{"l": "[1, 2]"}},
+}
+
+func TestPartialEval(t *testing.T) {
+	for i, v := range partialSources {
+		static, dynamic := parseInputs(v.static), parseInputs(v.dynamic)
+		all := make(map[string]*Node)
+		for name, n := range static {
+			all[name] = n
+		}
+		for name, n := range dynamic {
+			all[name] = n
+		}
+		want, err := Eval(substInputs(buildASTFromString(v.src), all))
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		ast, err := PartialEval(buildASTFromString(v.src), static)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if ast, err = reparse(ast); err != nil { // the residual program is printed by tl-eval --emit pe
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		got, err := Eval(substInputs(ast, dynamic))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+	}
+}
+
+func TestPartialEval_prelude(t *testing.T) {
+	for i, v := range []struct {
+		src     string
+		static  map[string]string
+		dynamic map[string]string
+	}{
+		{"times n (succ (succ 0))", nil, map[string]string{"n": "succ (succ (succ 0))"}},
+		{"map (plus k) l", map[string]string{"k": "succ 0"}, map[string]string{"l": "[0, succ 0]"}},
+		{"foldr plus 0 (append l [succ 0])", nil, map[string]string{"l": "[succ 0]"}},
+		{"length (append [0] l)", nil, map[string]string{"l": "[0, 0]"}},
+	} {
+		static, dynamic := parseInputs(v.static), parseInputs(v.dynamic)
+		all := parseInputs(v.static)
+		for name, n := range dynamic {
+			all[name] = n
+		}
+		want, err := Eval(substInputs(buildASTWithPrelude(Prelude, v.src), all))
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		ast, err := PartialEval(buildASTWithPrelude(Prelude, v.src), static)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if ast, err = reparse(ast); err != nil { // the residual program is printed by tl-eval --emit pe
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		got, err := Eval(substInputs(ast, dynamic))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+	}
+}
+
+func TestPartialEval_output(t *testing.T) {
+	testcases := []struct {
+		src    string
+		inputs map[string]string
+		want   string
+	}{
+		{"iszero y", nil, "iszero y"},
+		{"if iszero y then x + 1 else 0", map[string]string{"x": "2"}, "if (iszero y) then (3) else (0)"},
+		{"(.a -> {a, a}) (f 0)", nil, "(.t -> ({t, t})) (f 0)"},
+		{"(.a -> a + 1) (f 0)", map[string]string{"f": ".n -> n"}, "1"},
+		{"def power = .n .x -> if n == 0 then 1 else x * power (n - 1) x; power 3 x", nil, "(x * (x * (x * 1)))"},
+		{"def fact = .n -> if n < 2 then 1 else n * fact (n - 1); fact 5 + fact n", nil,
+			"def fact1 = .n1 -> (if ((n1 < 2)) then (1) else ((n1 * fact1 (n1 - 1))));\n(120 + fact1 n)"},
+		{"def k = 0; def f = .x -> k; f y", nil, "0"},
+		{"def loop = .x -> loop x; loop y", nil, "def loop1 = .x1 -> (loop1 x1);\nloop1 y"},
+	}
+	for i, v := range testcases {
+		ast, err := PartialEval(buildASTFromString(v.src), parseInputs(v.inputs))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		var lines []string
+		for _, d := range ast.Declarations {
+			lines = append(lines, d.String())
+		}
+		lines = append(lines, ast.Child.String())
+		if got := strings.Join(lines, "\n"); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+// the bodies of lambdas are specialized, but they are not evaluated by Eval, so calls in them may not terminate
+func TestPartialEval_lambdaBody(t *testing.T) {
+	for i, src := range []string{
+		"def w = .x -> x x; .y -> w w",
+		".y -> (.x -> x x) (.x -> x x)",
+		".y -> (.x -> .z -> x x) (.x -> .z -> x x)",
+		"def w = .x -> x x; .y -> {w w, y}",
+	} {
+		ast, err := PartialEval(buildASTFromString(src), nil)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if ast, err = reparse(ast); err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		got, err := Eval(ast)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got.NodeType != Lambda {
+			t.Errorf("case %d: want a lambda but got %v\n", i, got)
+		}
+	}
+}
+
+func TestPartialEval_error(t *testing.T) {
+	for i, v := range []struct {
+		src    string
+		inputs map[string]string
+	}{
+		{"callcc [Nat] (.k:(Nat -> Bot) -> k x)", nil},
+		{"x", map[string]string{"x": "f 0"}},
+	} {
+		if _, err := PartialEval(buildASTFromString(v.src), parseInputs(v.inputs)); err == nil {
+			t.Errorf("case %d: no error", i)
+		}
+	}
+}
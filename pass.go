@@ -0,0 +1,133 @@
+package gtl
+
+import (
+	"strconv"
+)
+
+// nameSupply generates variables for passes which transform a program, such as ConvertCPS.
+// it has the names in the program and the names generated so far.
+type nameSupply map[string]bool
+
+func newNameSupply(ast *AST) nameSupply {
+	s := make(nameSupply)
+	var collect func(n *Node)
+	collect = func(n *Node) {
+		switch n.NodeType {
+		case Variable, FreeVariable, LambdaParam, PatternVariable, Definition:
+			s[n.Name] = true
+		}
+		for _, ch := range n.Children {
+			collect(ch)
+		}
+	}
+	for _, d := range ast.Declarations {
+		collect(d)
+	}
+	collect(ast.Child)
+	return s
+}
+
+// fresh returns a variable which is not used in the program, such as k or k1.
+func (s nameSupply) fresh(base string) string {
+	name := base
+	for i := 1; s[name]; i++ {
+		name = base + strconv.Itoa(i)
+	}
+	s[name] = true
+	return name
+}
+
+// applyTerms returns an application of f to args one by one.
+func applyTerms(f *Node, args ...*Node) *Node {
+	for _, a := range args {
+		f = &Node{NodeType: Apply, Children: []*Node{f, a}}
+	}
+	return f
+}
+
+// untypedLambda returns a lambda whose parameters have no type annotations.
+func untypedLambda(params []string, body *Node) *Node {
+	def := &Node{NodeType: LambdaDef}
+	for _, p := range params {
+		def.Children = append(def.Children, &Node{NodeType: LambdaParam, Name: p})
+	}
+	return &Node{NodeType: Lambda, Children: []*Node{def, &Node{NodeType: LambdaBody, Children: []*Node{body}}}}
+}
+
+// freeVariables returns the variables which occur free in n, in the order of their first occurrences.
+// the parser tells a Variable bound by an enclosing binder from a FreeVariable, but that is relative to
+// the whole program. this is relative to n, so a variable bound outside of a lambda is free in the lambda.
+func freeVariables(n *Node) []string {
+	var ret []string
+	visitFreeVariables(n, func(v *Node) {
+		if !containsString(ret, v.Name) {
+			ret = append(ret, v.Name)
+		}
+	})
+	return ret
+}
+
+// occurrences returns how many times the variable name occurs free in n.
+func occurrences(n *Node, name string) int {
+	ret := 0
+	visitFreeVariables(n, func(v *Node) {
+		if v.Name == name {
+			ret++
+		}
+	})
+	return ret
+}
+
+// visitFreeVariables calls visit with every occurrence of a variable which is free in n.
+func visitFreeVariables(n *Node, visit func(*Node)) {
+	var walk func(n *Node, bound []string)
+	walk = func(n *Node, bound []string) {
+		switch n.NodeType {
+		case Variable, FreeVariable:
+			if !containsString(bound, n.Name) {
+				visit(n)
+			}
+		case Lambda:
+			for _, p := range n.Children[0].Children {
+				bound = append(bound, p.Name)
+			}
+			walk(n.Children[1].Children[0], bound)
+		case TypeAbstraction, TypeApplication:
+			walk(n.Children[0], bound)
+		case Pack:
+			walk(n.Children[1], bound)
+		case Variant:
+			walk(n.Children[0], bound)
+		case Unpack:
+			walk(n.Children[1], bound)
+			walk(n.Children[2], append(bound, n.Children[0].Name))
+		case Match:
+			walk(n.Children[0], bound)
+			for _, mc := range n.Children[1:] {
+				vars, _ := patternVariables(mc.Children[0])
+				walk(mc.Children[1], append(bound, vars...))
+			}
+		default:
+			for _, c := range n.Children {
+				walk(c, bound)
+			}
+		}
+	}
+	walk(n, nil)
+}
+
+// boundVariables returns the variables which are bound by binders in n, such as parameters of lambdas.
+func boundVariables(n *Node) []string {
+	var ret []string
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n.NodeType == LambdaParam || n.NodeType == PatternVariable { // the variable of unpacking is a LambdaParam
+			ret = append(ret, n.Name)
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(n)
+	return ret
+}
@@ -0,0 +1,38 @@
+package gtl
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_freeVariables(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"x", "x"},
+		{".x -> x y", "y"},
+		{".x .y -> z y x w z", "z w"},
+		{"(.x -> x) x", "x"},
+		{"match l with | cons h t -> h x | [] -> y", "l x y"},
+		{"let {X, p} = q in p r", "q r"},
+		{`\X -> .x:X -> f [X] x`, "f"},
+		{"<a = x> as <a: Nat>", "x"},
+	}
+	for i, v := range testcases {
+		if got := strings.Join(freeVariables(buildASTFromString(v.src).Child), " "); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+func Test_nameSupply(t *testing.T) {
+	s := newNameSupply(buildASTFromString("def k1 = 0; .k -> k1"))
+	var got []string
+	for i := 0; i < 3; i++ {
+		got = append(got, s.fresh("k"))
+	}
+	if want := "k2 k3 k4"; strings.Join(got, " ") != want {
+		t.Errorf("want %v but got %v\n", want, strings.Join(got, " "))
+	}
+}
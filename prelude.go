@@ -0,0 +1,35 @@
+package gtl
+
+// Prelude is the standard library which tl-eval loads before a program.
+const Prelude = `
+def id = .x -> x;
+def compose = .f .g .x -> f (g x);
+
+def not = .b -> if b then false else true;
+def and = .a .b -> if a then b else false;
+def or = .a .b -> if a then true else b;
+
+def plus = .m .n -> match m with
+  | 0 -> n
+  | succ k -> succ (plus k n);
+def times = .m .n -> match m with
+  | 0 -> 0
+  | succ k -> plus n (times k n);
+def equal = .m .n -> match {m, n} with
+  | {0, 0} -> true
+  | {succ j, succ k} -> equal j k
+  | _ -> false;
+
+def length = .l -> match l with
+  | [] -> 0
+  | cons _ t -> succ (length t);
+def append = .l .m -> match l with
+  | [] -> m
+  | cons h t -> cons h (append t m);
+def map = .f .l -> match l with
+  | [] -> nil
+  | cons h t -> cons (f h) (map f t);
+def foldr = .f .z .l -> match l with
+  | [] -> z
+  | cons h t -> f h (foldr f z t);
+`
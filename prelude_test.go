@@ -0,0 +1,93 @@
+package gtl
+
+import (
+	"testing"
+)
+
+// NOTE: this function may cause panic
+func buildASTWithPrelude(prelude, source string) *AST {
+	l := newLoader()
+	if err := l.loadPrelude(prelude); err != nil {
+		panic(err)
+	}
+	decls, main, err := parseSource("test", source)
+	if err != nil {
+		panic(err)
+	}
+	_, main, err = l.link("test", decls, main, "", l.prelude)
+	if err != nil {
+		panic(err)
+	}
+	return &AST{Declarations: l.decls, Child: main}
+}
+
+// NOTE: this function may cause panic
+func evalWithPrelude(prelude, source string) *Node {
+	n, err := Eval(buildASTWithPrelude(prelude, source))
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func TestPrelude(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"id true", "true"},
+		{"compose not not true", "true"},
+		{"compose iszero pred (succ 0)", "true"},
+		{"not true", "false"},
+		{"and true false", "false"},
+		{"and true true", "true"},
+		{"or false true", "true"},
+		{"or false false", "false"},
+		{"plus (succ (succ 0)) (succ 0)", "succ (succ (succ (0)))"},
+		{"plus 0 0", "0"},
+		{"times (succ (succ 0)) (succ (succ (succ 0)))", "succ (succ (succ (succ (succ (succ (0))))))"},
+		{"times (succ 0) 0", "0"},
+		{"equal (succ 0) (succ 0)", "true"},
+		{"equal (succ 0) (succ (succ 0))", "false"},
+		{"equal (succ (succ 0)) (succ 0)", "false"},
+		{"length [true, false]", "succ (succ (0))"},
+		{"length []", "0"},
+		{"append [0] [true, false]", "[0, true, false]"},
+		{"map not [true, false]", "[false, true]"},
+		{"map (plus (succ 0)) []", "nil"},
+		{"foldr plus 0 [succ 0, succ (succ 0)]", "succ (succ (succ (0)))"},
+		{"foldr and true [true, false]", "false"},
+		// definitions of a program shadow the prelude
+		{"def not = .b -> b; not true", "true"},
+		// and so do parameters
+		{"(.id -> id) false", "false"},
+	}
+	for i, v := range testcases {
+		if got := evalWithPrelude(Prelude, v.src).String(); got != v.want {
+			t.Errorf("case %d: %s: want %v but got %v\n", i, v.src, v.want, got)
+		}
+	}
+}
+
+func TestLoadFileWith_prelude(t *testing.T) {
+	ast, err := LoadFileWith("testdata/module/prelude.tl", LoadOptions{Prelude: Prelude})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := Eval(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "true", n.String(); got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+
+	// the prelude is not loaded by LoadFile
+	ast, err = LoadFile("testdata/module/prelude.tl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := FreeVariable, ast.Child.Children[0].Children[0].NodeType; got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+}
@@ -0,0 +1,190 @@
+package gtl
+
+import (
+	"fmt"
+)
+
+// ErrSubtypeDepth is an error for FullSubtyping, which means checking gave up because it might not terminate
+var ErrSubtypeDepth = fmt.Errorf("gave up subtype checking after %d nested comparisons", maxSubtypeDepth)
+
+// wrapSubtypeError adds a description to err unless err is ErrSubtypeDepth, which should be reported as is.
+func wrapSubtypeError(err error, format string, a ...interface{}) error {
+	if err == ErrSubtypeDepth {
+		return err
+	}
+	return fmt.Errorf(format+": %v", append(a, err)...)
+}
+
+// expose replaces a type variable with its bound until it gets a concrete type.
+func (te *typeEnvironment) expose(ty *Node) *Node {
+	ty = normalizeType(ty)
+	for {
+		promoted := te.promote(ty)
+		if promoted == nil {
+			return ty
+		}
+		ty = promoted
+	}
+}
+
+// promote replaces a type variable or the head of an operator application with its bound.
+// it returns nil if the type cannot be promoted.
+func (te *typeEnvironment) promote(ty *Node) *Node {
+	switch ty.NodeType {
+	case TypeVariable:
+		bound := te.LookupTypeVariable(ty.Name)
+		if bound == nil {
+			return nil
+		}
+		return normalizeType(bound)
+	case OperatorApplication:
+		f := te.promote(ty.Children[0])
+		if f == nil {
+			return nil
+		}
+		return normalizeType(&Node{NodeType: OperatorApplication, Children: []*Node{f, ty.Children[1]}})
+	}
+	return nil
+}
+
+// join returns the least common supertype of a and b which can be found easily.
+func (te *typeEnvironment) join(a, b *Node) *Node {
+	if te.subtype(a, b) == nil {
+		return b
+	}
+	if te.subtype(b, a) == nil {
+		return a
+	}
+	a, b = te.expose(a), te.expose(b)
+	switch {
+	case a.NodeType == TypeList && b.NodeType == TypeList:
+		return &Node{NodeType: TypeList, Children: []*Node{te.join(a.Children[0], b.Children[0])}}
+	case a.NodeType == TypeTuple && b.NodeType == TypeTuple && len(a.Children) == len(b.Children):
+		ret := &Node{NodeType: TypeTuple, Children: make([]*Node, len(a.Children))}
+		for i := range a.Children {
+			ret.Children[i] = te.join(a.Children[i], b.Children[i])
+		}
+		return ret
+	case a.NodeType == TypeVariant && b.NodeType == TypeVariant: // labels of both
+		ret := &Node{NodeType: TypeVariant}
+		for _, f := range a.Children {
+			ty := f.Children[0]
+			if other := variantField(b, f.Name); other != nil {
+				ty = te.join(ty, other)
+			}
+			ret.Children = append(ret.Children, &Node{NodeType: VariantField, Name: f.Name, Children: []*Node{ty}})
+		}
+		for _, f := range b.Children {
+			if variantField(a, f.Name) == nil {
+				ret.Children = append(ret.Children, f)
+			}
+		}
+		return ret
+	}
+	return &Node{NodeType: TypeTop}
+}
+
+// joinBranches is join for the branches of if and match. it reports false instead of joining unrelated
+// types into Top, because a branch of a different type is usually a mistake like "if b then 0 else false".
+func (te *typeEnvironment) joinBranches(a, b *Node) (*Node, bool) {
+	ty := te.join(a, b)
+	if ty.NodeType == TypeTop && normalizeType(a).NodeType != TypeTop && normalizeType(b).NodeType != TypeTop {
+		return nil, false
+	}
+	return ty, true
+}
+
+// subtype returns nil if s is a subtype of t, otherwise it returns an error which describes why.
+func (te *typeEnvironment) subtype(s, t *Node) error {
+	if te.rule == FullSubtyping {
+		te.depth++
+		defer func() { te.depth-- }()
+		if te.depth > maxSubtypeDepth {
+			return ErrSubtypeDepth
+		}
+	}
+	s, t = normalizeType(s), normalizeType(t)
+	if t.NodeType == TypeTop || s.NodeType == TypeBot || typeEqual(s, t) {
+		return nil
+	}
+	switch {
+	case s.NodeType == TypeVariable, s.NodeType == OperatorApplication:
+		bound := te.promote(s)
+		if bound == nil {
+			return fmt.Errorf("%s is not a subtype of %s", s, t)
+		}
+		if err := te.subtype(bound, t); err != nil {
+			return wrapSubtypeError(err, "%s is not a subtype of %s because of its bound %s <: %s", s, t, s, bound)
+		}
+		return nil
+	case s.NodeType == TypeArrow && t.NodeType == TypeArrow:
+		if err := te.subtype(t.Children[0], s.Children[0]); err != nil {
+			return wrapSubtypeError(err, "%s is not a subtype of %s", s, t)
+		}
+		if err := te.subtype(s.Children[1], t.Children[1]); err != nil {
+			return wrapSubtypeError(err, "%s is not a subtype of %s", s, t)
+		}
+		return nil
+	case s.NodeType == TypeNat && t.NodeType == TypeInt: // natural numbers are integers
+		return nil
+	case s.NodeType == TypeList && t.NodeType == TypeList: // lists are covariant
+		if err := te.subtype(s.Children[0], t.Children[0]); err != nil {
+			return wrapSubtypeError(err, "%s is not a subtype of %s", s, t)
+		}
+		return nil
+	case s.NodeType == TypeTuple && t.NodeType == TypeTuple && len(s.Children) == len(t.Children): // tuples are covariant
+		for i := range s.Children {
+			if err := te.subtype(s.Children[i], t.Children[i]); err != nil {
+				return wrapSubtypeError(err, "%s is not a subtype of %s", s, t)
+			}
+		}
+		return nil
+	case s.NodeType == TypeVariant && t.NodeType == TypeVariant: // t may have more labels
+		for _, f := range s.Children {
+			want := variantField(t, f.Name)
+			if want == nil {
+				return fmt.Errorf("%s is not a subtype of %s: label %s is missing", s, t, f.Name)
+			}
+			if err := te.subtype(f.Children[0], want); err != nil {
+				return wrapSubtypeError(err, "%s is not a subtype of %s", s, t)
+			}
+		}
+		return nil
+	case s.NodeType == TypeAll && t.NodeType == TypeAll:
+		return te.subtypeQuantified(s, t, te.rule)
+	case s.NodeType == TypeSome && t.NodeType == TypeSome:
+		return te.subtypeQuantified(s, t, KernelSubtyping)
+	}
+	return fmt.Errorf("%s is not a subtype of %s", s, t)
+}
+
+// subtypeQuantified compares types which have bounded type variables, i.e. TypeAll or TypeSome.
+func (te *typeEnvironment) subtypeQuantified(s, t *Node, rule SubtypingRule) error {
+	sb, tb := s.Children[1], t.Children[1]
+	switch rule {
+	case KernelSubtyping:
+		if !typeEqual(sb, tb) {
+			return fmt.Errorf("%s is not a subtype of %s: bounds %s <: %s and %s <: %s are different", s, t, s.Name, sb, t.Name, tb)
+		}
+	case FullSubtyping:
+		if err := te.subtype(tb, sb); err != nil {
+			return wrapSubtypeError(err, "%s is not a subtype of %s: bound %s <: %s does not satisfy %s <: %s", s, t, t.Name, tb, s.Name, sb)
+		}
+	}
+	used := freeTypeVariables(s)
+	for k := range freeTypeVariables(t) {
+		used[k] = true
+	}
+	for _, v := range te.typeVariables {
+		used[v.name] = true
+	}
+	name := freshName(s.Name, used)
+	v := &Node{NodeType: TypeVariable, Name: name}
+	te.AddTypeVariable(name, tb)
+	err := te.subtype(substType(s.Children[0], s.Name, v), substType(t.Children[0], t.Name, v))
+	te.RemoveTypeVariable(name)
+	if err != nil {
+		return wrapSubtypeError(err, "%s is not a subtype of %s", s, t)
+	}
+	return nil
+}
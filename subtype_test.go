@@ -0,0 +1,111 @@
+package gtl
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// NOTE: this function may cause panic
+func buildTypeFromString(str string) *Node {
+	ast := buildASTFromString(fmt.Sprintf(".x:(%s) -> x", str))
+	return ast.Child.Children[0].Children[0].Children[0]
+}
+
+func Test_subtype(t *testing.T) {
+	testcases := []struct {
+		s, t string
+		rule SubtypingRule
+		want bool
+	}{
+		{"Nat", "Top", KernelSubtyping, true},
+		{"Top", "Nat", KernelSubtyping, false},
+		{"Top -> Nat", "Nat -> Top", KernelSubtyping, true},
+		{"Nat -> Top", "Top -> Nat", KernelSubtyping, false},
+		{"All X <: Top. X -> X", "All Y. Y -> Y", KernelSubtyping, true},
+		{"All X. X -> Nat", "All X <: Nat. X -> Top", KernelSubtyping, false},
+		{"All X. X -> Nat", "All X <: Nat. X -> Top", FullSubtyping, true},
+		{"All X <: Nat. X -> Top", "All X. X -> Nat", FullSubtyping, false},
+		{"All X <: Nat. X", "All X <: Nat. Nat", KernelSubtyping, true},
+		{"{Some X <: Nat, X}", "{Some X <: Nat, Nat}", KernelSubtyping, true},
+		{"Bot", "Nat", KernelSubtyping, true},
+		{"Nat", "Bot", KernelSubtyping, false},
+		{"Nat -> Bot", "Nat -> All X. X", KernelSubtyping, true},
+	}
+	for i, v := range testcases {
+		env := typeEnvironment{rule: v.rule}
+		err := env.subtype(buildTypeFromString(v.s), buildTypeFromString(v.t))
+		if got := err == nil; got != v.want {
+			t.Errorf("case %d: %s <: %s should be %v but got %v", i, v.s, v.t, v.want, err)
+		}
+	}
+}
+
+func Test_subtype_variable(t *testing.T) {
+	env := typeEnvironment{}
+	env.AddTypeVariable("X", buildTypeFromString("Nat -> Nat"))
+	x := &Node{NodeType: TypeVariable, Name: "X"}
+	if err := env.subtype(x, buildTypeFromString("Nat -> Top")); err != nil {
+		t.Error(err)
+	}
+	err := env.subtype(x, buildTypeFromString("Bool"))
+	if err == nil {
+		t.Fatal("X <: Nat -> Nat should not be a subtype of Bool")
+	}
+	if want, got := "bound X <: Nat -> Nat", err.Error(); !strings.Contains(got, want) {
+		t.Errorf("%q should contain %q", got, want)
+	}
+}
+
+func TestTypecheck_bounded(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{`\X <: Nat -> .x:X -> succ x`, "All X <: Nat. X -> Nat"},
+		{`(\X <: Nat -> .x:X -> x) [Nat] 0`, "Nat"},
+		{`(\X <: (Nat -> Nat) -> .f:X -> f 0) [Top -> Nat] (.x:Top -> 0)`, "Nat"},
+		{`.f:(All X <: Nat. X -> X) -> f [Nat]`, "(All X <: Nat. X -> X) -> Nat -> Nat"},
+		{`{*Nat, 0} as {Some X <: Nat, X}`, "{Some X <: Nat, X}"},
+		{`let {X, x} = {*Nat, 0} as {Some X <: Nat, X} in iszero x`, "Bool"},
+	}
+	for i, v := range testcases {
+		ty, err := Typecheck(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := ty.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+func TestTypecheck_boundError(t *testing.T) {
+	_, err := Typecheck(buildASTFromString(`(\X <: Nat -> .x:X -> x) [Bool]`))
+	if err == nil {
+		t.Fatal("Bool should not satisfy the bound")
+	}
+	if want, got := "does not satisfy the bound X <: Nat", err.Error(); !strings.Contains(got, want) {
+		t.Errorf("%q should contain %q", got, want)
+	}
+	_, err = Typecheck(buildASTFromString(`{*Bool, true} as {Some X <: Nat, X}`))
+	if err == nil {
+		t.Fatal("Bool should not satisfy the bound")
+	}
+	if want, got := "does not satisfy the bound X <: Nat", err.Error(); !strings.Contains(got, want) {
+		t.Errorf("%q should contain %q", got, want)
+	}
+}
+
+// TaPL 28.5.4: full F<: subtyping does not terminate on this program
+func TestTypecheckWith_undecidable(t *testing.T) {
+	src := `\X0 <: (All X. All Z <: (All Y <: X. All W <: Y. W). Z) -> .x:X0 -> (.y:(All X1 <: X0. All Z <: X1. Z) -> y) x`
+	ast := buildASTFromString(src)
+	if _, err := TypecheckWith(ast, KernelSubtyping); err == nil {
+		t.Error("kernel subtyping should reject different bounds")
+	}
+	if _, err := TypecheckWith(ast, FullSubtyping); err != ErrSubtypeDepth {
+		t.Errorf("full subtyping should give up but got %v", err)
+	}
+}
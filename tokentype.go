@@ -20,7 +20,7 @@ const (
 	Arrow
 	// Dot is "."
 	Dot
-	// Number is "0"
+	// Number is a decimal number such as "0", "42" or "0i"
 	Number
 	// KeywordTrue is "true"
 	KeywordTrue
@@ -34,4 +34,82 @@ const (
 	KeywordElse
 	// KeywordIsZero is "iszero"
 	KeywordIsZero
+	// Backslash is "\"
+	Backslash
+	// LBracket is "["
+	LBracket
+	// RBracket is "]"
+	RBracket
+	// Colon is ":"
+	Colon
+	// KeywordAll is "All"
+	KeywordAll
+	// Star is "*"
+	Star
+	// Comma is ","
+	Comma
+	// Equal is "="
+	Equal
+	// KeywordSome is "Some"
+	KeywordSome
+	// KeywordAs is "as"
+	KeywordAs
+	// KeywordLet is "let"
+	KeywordLet
+	// KeywordIn is "in"
+	KeywordIn
+	// KeywordSucc is "succ"
+	KeywordSucc
+	// KeywordPred is "pred"
+	KeywordPred
+	// Subtype is "<:"
+	Subtype
+	// FatArrow is "=>"
+	FatArrow
+	// Semicolon is ";"
+	Semicolon
+	// KeywordDef is "def"
+	KeywordDef
+	// KeywordType is "type"
+	KeywordType
+	// String is a string literal such as "foo". its Text does not contain quotes
+	String
+	// KeywordImport is "import"
+	KeywordImport
+	// KeywordExport is "export"
+	KeywordExport
+	// KeywordNil is "nil"
+	KeywordNil
+	// KeywordCons is "cons"
+	KeywordCons
+	// KeywordIsNil is "isnil"
+	KeywordIsNil
+	// KeywordHead is "head"
+	KeywordHead
+	// KeywordTail is "tail"
+	KeywordTail
+	// KeywordConcat is "concat"
+	KeywordConcat
+	// KeywordStrLen is "strlen"
+	KeywordStrLen
+	// KeywordStrEq is "streq"
+	KeywordStrEq
+	// Operator is an infix operator such as "+" or "++"
+	Operator
+	// KeywordInfixl is "infixl"
+	KeywordInfixl
+	// KeywordInfixr is "infixr"
+	KeywordInfixr
+	// KeywordInfix is "infix"
+	KeywordInfix
+	// KeywordMatch is "match"
+	KeywordMatch
+	// KeywordWith is "with"
+	KeywordWith
+	// Bar is "|", which separates cases of match
+	Bar
+	// KeywordCallCC is "callcc"
+	KeywordCallCC
+	// KeywordAbort is "abort"
+	KeywordAbort
 )
@@ -4,9 +4,9 @@ package gtl
 
 import "strconv"
 
-const _TokenType_name = "EOFWordLParenRParenLBlaceRBlaceArrowDotNumberKeywordTrueKeywordFalseKeywordIfKeywordThenKeywordElseKeywordIsZero"
+const _TokenType_name = "EOFWordLParenRParenLBlaceRBlaceArrowDotNumberKeywordTrueKeywordFalseKeywordIfKeywordThenKeywordElseKeywordIsZeroBackslashLBracketRBracketColonKeywordAllStarCommaEqualKeywordSomeKeywordAsKeywordLetKeywordInKeywordSuccKeywordPredSubtypeFatArrowSemicolonKeywordDefKeywordTypeStringKeywordImportKeywordExportKeywordNilKeywordConsKeywordIsNilKeywordHeadKeywordTailKeywordConcatKeywordStrLenKeywordStrEqOperatorKeywordInfixlKeywordInfixrKeywordInfixKeywordMatchKeywordWithBarKeywordCallCCKeywordAbort"
 
-var _TokenType_index = [...]uint8{0, 3, 7, 13, 19, 25, 31, 36, 39, 45, 56, 68, 77, 88, 99, 112}
+var _TokenType_index = [...]uint16{0, 3, 7, 13, 19, 25, 31, 36, 39, 45, 56, 68, 77, 88, 99, 112, 121, 129, 137, 142, 152, 156, 161, 166, 177, 186, 196, 205, 216, 227, 234, 242, 251, 261, 272, 278, 291, 304, 314, 325, 337, 348, 359, 372, 385, 397, 405, 418, 431, 443, 455, 466, 469, 482, 494}
 
 func (i TokenType) String() string {
 	if i >= TokenType(len(_TokenType_index)-1) {
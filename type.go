@@ -0,0 +1,154 @@
+package gtl
+
+// freeTypeVariables returns names of type variables which are not bound in a type or a term.
+func freeTypeVariables(n *Node) map[string]bool {
+	ret := make(map[string]bool)
+	collectFreeTypeVariables(n, nil, ret)
+	return ret
+}
+
+func collectFreeTypeVariables(n *Node, bound []string, acc map[string]bool) {
+	switch n.NodeType {
+	case TypeVariable:
+		for _, b := range bound {
+			if b == n.Name {
+				return
+			}
+		}
+		acc[n.Name] = true
+		return
+	case TypeAll, TypeAbstraction, TypeSome, OperatorAbstraction: // the type variable is not bound in its bound
+		collectFreeTypeVariables(n.Children[0], append(bound, n.Name), acc)
+		collectFreeTypeVariables(n.Children[1], bound, acc)
+		return
+	case Unpack: // the type variable is bound only in the body
+		collectFreeTypeVariables(n.Children[1], bound, acc)
+		collectFreeTypeVariables(n.Children[2], append(bound, n.Name), acc)
+		return
+	}
+	for _, c := range n.Children {
+		collectFreeTypeVariables(c, bound, acc)
+	}
+}
+
+// freshName returns a name based on name which is not contained in used.
+func freshName(name string, used map[string]bool) string {
+	for used[name] {
+		name += "'"
+	}
+	return name
+}
+
+// substType replaces free occurrences of the type variable name in n with s.
+// n may be a type or a term which contains types.
+// bound variables are renamed if they would capture free variables of s.
+func substType(n *Node, name string, s *Node) *Node {
+	switch n.NodeType {
+	case TypeVariable:
+		if n.Name == name {
+			return s
+		}
+		return n
+	case TypeAll, TypeAbstraction, TypeSome, OperatorAbstraction:
+		bound := substType(n.Children[1], name, s)
+		if n.Name == name {
+			return &Node{NodeType: n.NodeType, Name: n.Name, Children: []*Node{n.Children[0], bound}}
+		}
+		binder, body := renameBinder(n.Name, n.Children[0], s)
+		return &Node{NodeType: n.NodeType, Name: binder, Children: []*Node{substType(body, name, s), bound}}
+	case Unpack:
+		bound := substType(n.Children[1], name, s)
+		if n.Name == name {
+			return &Node{NodeType: Unpack, Name: n.Name, Children: []*Node{n.Children[0], bound, n.Children[2]}}
+		}
+		binder, body := renameBinder(n.Name, n.Children[2], s)
+		return &Node{NodeType: Unpack, Name: binder, Children: []*Node{n.Children[0], bound, substType(body, name, s)}}
+	}
+	if len(n.Children) == 0 {
+		return n
+	}
+	ret := &Node{NodeType: n.NodeType, Name: n.Name, Children: make([]*Node, len(n.Children))}
+	for i, c := range n.Children {
+		ret.Children[i] = substType(c, name, s)
+	}
+	return ret
+}
+
+// renameBinder renames a type variable bound in body if it would capture free variables of s.
+func renameBinder(binder string, body *Node, s *Node) (string, *Node) {
+	fv := freeTypeVariables(s)
+	if !fv[binder] {
+		return binder, body
+	}
+	for k := range freeTypeVariables(body) {
+		fv[k] = true
+	}
+	fresh := freshName(binder, fv)
+	return fresh, substType(body, binder, &Node{NodeType: TypeVariable, Name: fresh})
+}
+
+// typeEqual returns whether two types are the same up to renaming of bound variables.
+func typeEqual(a, b *Node) bool {
+	if a.NodeType != b.NodeType || len(a.Children) != len(b.Children) {
+		return false
+	}
+	switch a.NodeType {
+	case TypeVariable:
+		return a.Name == b.Name
+	case VariantField:
+		return a.Name == b.Name && typeEqual(a.Children[0], b.Children[0])
+	case TypeAll, TypeSome, OperatorAbstraction:
+		if !typeEqual(a.Children[1], b.Children[1]) {
+			return false
+		}
+		if a.Name == b.Name {
+			return typeEqual(a.Children[0], b.Children[0])
+		}
+		used := freeTypeVariables(a)
+		for k := range freeTypeVariables(b) {
+			used[k] = true
+		}
+		used[a.Name] = true
+		used[b.Name] = true
+		v := &Node{NodeType: TypeVariable, Name: freshName(a.Name, used)}
+		return typeEqual(substType(a.Children[0], a.Name, v), substType(b.Children[0], b.Name, v))
+	}
+	for i := range a.Children {
+		if !typeEqual(a.Children[i], b.Children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// topOfKind returns Top[K], the maximum type of kind K.
+func topOfKind(kind *Node) *Node {
+	if kind.NodeType == KindStar {
+		return &Node{NodeType: TypeTop}
+	}
+	return &Node{NodeType: TypeTop, Children: []*Node{kind}}
+}
+
+// normalizeType reduces all applications of type operators in a type.
+// the type should be well-kinded, otherwise it may not terminate.
+func normalizeType(ty *Node) *Node {
+	if ty.NodeType == OperatorApplication {
+		f := normalizeType(ty.Children[0])
+		arg := normalizeType(ty.Children[1])
+		switch {
+		case f.NodeType == OperatorAbstraction:
+			return normalizeType(substType(f.Children[0], f.Name, arg))
+		case f.NodeType == TypeTop && len(f.Children) == 1: // Top[K => K'] T is Top[K']
+			return topOfKind(f.Children[0].Children[1])
+		}
+		return &Node{NodeType: OperatorApplication, Children: []*Node{f, arg}}
+	}
+	if len(ty.Children) == 0 {
+		return ty
+	}
+	ret := &Node{NodeType: ty.NodeType, Name: ty.Name, Children: make([]*Node, len(ty.Children))}
+	for i, c := range ty.Children {
+		ret.Children[i] = normalizeType(c)
+	}
+	return ret
+}
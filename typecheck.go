@@ -0,0 +1,657 @@
+package gtl
+
+import (
+	"fmt"
+)
+
+// SubtypingRule is a rule to compare bounded universal types
+type SubtypingRule uint8
+
+const (
+	// KernelSubtyping requires bounds of universal types to be the same. Checking always terminates.
+	KernelSubtyping SubtypingRule = iota
+	// FullSubtyping compares bounds of universal types contravariantly.
+	// It is undecidable, so checking gives up after maxSubtypeDepth nested comparisons.
+	FullSubtyping
+)
+
+const maxSubtypeDepth = 1000
+
+type typeEnvironment struct {
+	assginments   []assginment
+	typeVariables []assginment // value is the bound of the variable
+
+	rule  SubtypingRule
+	depth int
+
+	warnings []string
+}
+
+func (te *typeEnvironment) Assign(name string, ty *Node) {
+	te.assginments = append(te.assginments, assginment{name, ty})
+}
+
+func (te *typeEnvironment) Lookup(name string) *Node {
+	for i := len(te.assginments) - 1; i >= 0; i-- {
+		if te.assginments[i].name == name {
+			return te.assginments[i].value
+		}
+	}
+	return nil
+}
+
+func (te *typeEnvironment) Unassign(name string) error {
+	for i := len(te.assginments) - 1; i >= 0; i-- {
+		if te.assginments[i].name == name {
+			te.assginments = append(te.assginments[:i], te.assginments[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("missing unassignment target %s", name)
+}
+
+func (te *typeEnvironment) AddTypeVariable(name string, bound *Node) {
+	te.typeVariables = append(te.typeVariables, assginment{name, bound})
+}
+
+func (te *typeEnvironment) RemoveTypeVariable(name string) error {
+	for i := len(te.typeVariables) - 1; i >= 0; i-- {
+		if te.typeVariables[i].name == name {
+			te.typeVariables = append(te.typeVariables[:i], te.typeVariables[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown type variable %s", name)
+}
+
+// LookupTypeVariable returns the bound of a type variable, or nil if it is not bound.
+func (te *typeEnvironment) LookupTypeVariable(name string) *Node {
+	for i := len(te.typeVariables) - 1; i >= 0; i-- {
+		if te.typeVariables[i].name == name {
+			return te.typeVariables[i].value
+		}
+	}
+	return nil
+}
+
+func (te *typeEnvironment) IsTypeVariableBound(name string) bool {
+	return te.LookupTypeVariable(name) != nil
+}
+
+// checkWellFormed returns an error if a type is not a proper type, i.e. it does not have kind *.
+func (te *typeEnvironment) checkWellFormed(ty *Node) error {
+	return te.checkKind(ty, &Node{NodeType: KindStar})
+}
+
+// Typecheck returns the type of the program, or an error if the program is ill-typed.
+// Every lambda parameter must have a type annotation.
+// Bounded universal types are compared with KernelSubtyping.
+func Typecheck(ast *AST) (*Node, error) {
+	return TypecheckWith(ast, KernelSubtyping)
+}
+
+// TypecheckWith is Typecheck with a specified subtyping rule.
+func TypecheckWith(ast *AST, rule SubtypingRule) (*Node, error) {
+	ty, _, err := TypecheckWarnings(ast, rule)
+	return ty, err
+}
+
+// TypecheckWarnings is TypecheckWith which also returns warnings of a well-typed program,
+// such as a match which is not exhaustive or has a redundant case.
+// Definitions are checked in order, but a definition which is referred before it is defined is checked
+// first, or its type annotation is used, so it can be referred like the parser allows.
+// Definitions which refer to each other need type annotations.
+func TypecheckWarnings(ast *AST, rule SubtypingRule) (*Node, []string, error) {
+	env := typeEnvironment{rule: rule}
+	var synonyms []*Node
+	defs := make(map[string]*Node) // the first definition of each name
+	for _, d := range ast.Declarations {
+		switch d.NodeType {
+		case TypeDefinition:
+			ty := expandTypeSynonyms(d.Children[0], synonyms)
+			if _, err := env.kindOf(ty); err != nil {
+				return nil, nil, fmt.Errorf("type %s: %v", d.Name, err)
+			}
+			synonyms = append(synonyms, &Node{NodeType: TypeDefinition, Name: d.Name, Children: []*Node{ty}})
+		case Definition:
+			if _, ok := defs[d.Name]; !ok {
+				defs[d.Name] = d
+			}
+		}
+	}
+	checked := make(map[*Node]bool)
+	var check func(d *Node) error
+	check = func(d *Node) error {
+		checked[d] = true
+		d = expandTypeSynonyms(d, synonyms)
+		for _, name := range freeVariables(d.Children[0]) {
+			f, ok := defs[name]
+			if !ok || checked[f] || env.Lookup(name) != nil {
+				continue
+			}
+			if len(f.Children) == 1 {
+				if err := check(f); err != nil {
+					return err
+				}
+				continue
+			}
+			if want := expandTypeSynonyms(f.Children[1], synonyms); env.checkWellFormed(want) == nil {
+				env.Assign(name, want) // checked later with its body
+			}
+		}
+		ty, err := typeOfDefinition(d, &env)
+		if err != nil {
+			return fmt.Errorf("def %s: %v", d.Name, err)
+		}
+		env.Assign(d.Name, ty)
+		return nil
+	}
+	for _, d := range ast.Declarations {
+		if d.NodeType == Definition && !checked[d] {
+			if err := check(d); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	ty, err := typeOf(expandTypeSynonyms(ast.Child, synonyms), &env)
+	if err != nil {
+		return nil, nil, err
+	}
+	return normalizeType(ty), env.warnings, nil
+}
+
+// expandTypeSynonyms replaces names of type synonyms in n with their definitions.
+func expandTypeSynonyms(n *Node, synonyms []*Node) *Node {
+	for _, s := range synonyms {
+		n = substType(n, s.Name, s.Children[0])
+	}
+	return n
+}
+
+// a definition with a type annotation may be recursive
+func typeOfDefinition(d *Node, env *typeEnvironment) (*Node, error) {
+	if len(d.Children) == 1 {
+		return typeOf(d.Children[0], env)
+	}
+	want := d.Children[1]
+	if err := env.checkWellFormed(want); err != nil {
+		return nil, err
+	}
+	env.Assign(d.Name, want)
+	ty, err := typeOf(d.Children[0], env)
+	env.Unassign(d.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.subtype(ty, want); err != nil {
+		return nil, wrapSubtypeError(err, "type annotation mismatch")
+	}
+	return want, nil
+}
+
+func typeOf(n *Node, env *typeEnvironment) (*Node, error) {
+	switch n.NodeType {
+	case True, False:
+		return &Node{NodeType: TypeBool}, nil
+	case Zero:
+		return &Node{NodeType: TypeNat}, nil
+	case NodeNumber:
+		return &Node{NodeType: TypeInt}, nil
+	case BinaryOperator:
+		return typeOfBinaryOperator(n), nil
+	case Succ, Pred:
+		if n.IsNumericalValue() {
+			return &Node{NodeType: TypeNat}, nil
+		}
+		return arrowType(&Node{NodeType: TypeNat}, &Node{NodeType: TypeNat}), nil
+	case IsZero:
+		return arrowType(&Node{NodeType: TypeNat}, &Node{NodeType: TypeBool}), nil
+	case Nil, Cons, IsNil, Head, Tail:
+		return typeOfListPrimitive(n)
+	case CallCC, Abort:
+		return typeOfControlPrimitive(n), nil
+	case StringLiteral:
+		return &Node{NodeType: TypeString}, nil
+	case Concat, StrLen, StrEq:
+		return typeOfStringPrimitive(n), nil
+	case IF:
+		return typeOfIf(n, env)
+	case Variable, FreeVariable:
+		ty := env.Lookup(n.Name)
+		if ty == nil {
+			return nil, fmt.Errorf("unbound variable %s", n.Name)
+		}
+		return ty, nil
+	case Lambda:
+		return typeOfLambda(n, env)
+	case Apply:
+		return typeOfApply(n, env)
+	case TypeAbstraction:
+		return typeOfTypeAbstraction(n, env)
+	case TypeApplication:
+		return typeOfTypeApplication(n, env)
+	case Pack:
+		return typeOfPack(n, env)
+	case Unpack:
+		return typeOfUnpack(n, env)
+	case Tuple:
+		return typeOfTuple(n, env)
+	case Variant:
+		return typeOfVariant(n, env)
+	case Match:
+		return typeOfMatch(n, env)
+	default:
+		return nil, fmt.Errorf("cannot typecheck: %s", n.NodeType)
+	}
+}
+
+func arrowType(param, result *Node) *Node {
+	return &Node{NodeType: TypeArrow, Children: []*Node{param, result}}
+}
+
+func typeOfIf(n *Node, env *typeEnvironment) (*Node, error) {
+	cond, err := typeOf(n.Children[0], env)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.subtype(cond, &Node{NodeType: TypeBool}); err != nil {
+		return nil, wrapSubtypeError(err, "condition of if should be Bool")
+	}
+	truePart, err := typeOf(n.Children[1], env)
+	if err != nil {
+		return nil, err
+	}
+	falsePart, err := typeOf(n.Children[2], env)
+	if err != nil {
+		return nil, err
+	}
+	ty, ok := env.joinBranches(truePart, falsePart)
+	if !ok {
+		return nil, fmt.Errorf("branches of if have different types: %s and %s", truePart, falsePart)
+	}
+	return ty, nil
+}
+
+func typeOfLambda(n *Node, env *typeEnvironment) (*Node, error) {
+	params := n.Children[0].Children
+	for _, p := range params {
+		if len(p.Children) == 0 {
+			return nil, fmt.Errorf("parameter %s should have a type annotation", p.Name)
+		}
+		if err := env.checkWellFormed(p.Children[0]); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range params {
+		env.Assign(p.Name, p.Children[0])
+	}
+	ret, err := typeOf(n.Children[1].Children[0], env)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range params {
+		env.Unassign(p.Name)
+	}
+	for i := len(params) - 1; i >= 0; i-- {
+		ret = arrowType(params[i].Children[0], ret)
+	}
+	return ret, nil
+}
+
+// types of list primitives are polymorphic, e.g. nil[Nat] : List Nat
+func typeOfListPrimitive(n *Node) (*Node, error) {
+	if len(n.Children) != 0 {
+		return nil, fmt.Errorf("cannot typecheck: %s", n)
+	}
+	x := &Node{NodeType: TypeVariable, Name: "X"}
+	list := &Node{NodeType: TypeList, Children: []*Node{x}}
+	var ty *Node
+	switch n.NodeType {
+	case Nil:
+		ty = list
+	case Cons:
+		ty = arrowType(x, arrowType(list, list))
+	case IsNil:
+		ty = arrowType(list, &Node{NodeType: TypeBool})
+	case Head:
+		ty = arrowType(list, x)
+	case Tail:
+		ty = arrowType(list, list)
+	}
+	return &Node{NodeType: TypeAll, Name: "X", Children: []*Node{ty, &Node{NodeType: TypeTop}}}, nil
+}
+
+// types of control primitives are the classical laws, where Bot is the answer type which never returns.
+// callcc : All X. ((X -> Bot) -> X) -> X, and abort : All X. Bot -> X
+func typeOfControlPrimitive(n *Node) *Node {
+	x := &Node{NodeType: TypeVariable, Name: "X"}
+	bot := &Node{NodeType: TypeBot}
+	ty := arrowType(bot, x)
+	if n.NodeType == CallCC {
+		ty = arrowType(arrowType(arrowType(x, bot), x), x)
+	}
+	return &Node{NodeType: TypeAll, Name: "X", Children: []*Node{ty, &Node{NodeType: TypeTop}}}
+}
+
+// arithmetic operators take Int, and comparisons return Bool
+func typeOfBinaryOperator(n *Node) *Node {
+	result := &Node{NodeType: TypeInt}
+	if n.Name == "<" || n.Name == "==" {
+		result = &Node{NodeType: TypeBool}
+	}
+	ty := arrowType(&Node{NodeType: TypeInt}, result)
+	if len(n.Children) == 1 {
+		return ty
+	}
+	return arrowType(&Node{NodeType: TypeInt}, ty)
+}
+
+func typeOfStringPrimitive(n *Node) *Node {
+	str := &Node{NodeType: TypeString}
+	switch n.NodeType {
+	case StrLen:
+		return arrowType(str, &Node{NodeType: TypeNat})
+	case StrEq:
+		if len(n.Children) == 1 {
+			return arrowType(str, &Node{NodeType: TypeBool})
+		}
+		return arrowType(str, arrowType(str, &Node{NodeType: TypeBool}))
+	}
+	if len(n.Children) == 1 {
+		return arrowType(str, str)
+	}
+	return arrowType(str, arrowType(str, str))
+}
+
+// typeOfListLiteral returns the type of cons t t' whose type argument is omitted as in [t1, t2].
+// the type of elements is the join of their types, and elements of unrelated types are rejected like
+// branches of if, because [0, true] is usually a mistake. a list of Top is made with a type annotation.
+func typeOfListLiteral(n *Node, env *typeEnvironment) (*Node, error) {
+	elem, err := typeOf(n.Children[0].Children[1], env)
+	if err != nil {
+		return nil, err
+	}
+	if rest := n.Children[1]; rest.NodeType != Nil {
+		ty, err := typeOf(rest, env)
+		if err != nil {
+			return nil, err
+		}
+		if ty = env.expose(ty); ty.NodeType != TypeList {
+			return nil, fmt.Errorf("%s is not a list but %s", rest, ty)
+		}
+		joined, ok := env.joinBranches(elem, ty.Children[0])
+		if !ok {
+			return nil, fmt.Errorf("elements of list have different types: %s and %s", elem, ty.Children[0])
+		}
+		elem = joined
+	}
+	return &Node{NodeType: TypeList, Children: []*Node{elem}}, nil
+}
+
+func typeOfApply(n *Node, env *typeEnvironment) (*Node, error) {
+	if f := n.Children[0]; f.NodeType == Apply && f.Children[0].NodeType == Cons && len(f.Children[0].Children) == 0 {
+		return typeOfListLiteral(n, env)
+	}
+	l, err := typeOf(n.Children[0], env)
+	if err != nil {
+		return nil, err
+	}
+	r, err := typeOf(n.Children[1], env)
+	if err != nil {
+		return nil, err
+	}
+	if l = env.expose(l); l.NodeType != TypeArrow {
+		return nil, fmt.Errorf("%s is not a function but %s", n.Children[0], l)
+	}
+	if err := env.subtype(r, l.Children[0]); err != nil {
+		return nil, wrapSubtypeError(err, "parameter type mismatch")
+	}
+	return l.Children[1], nil
+}
+
+// renameShadowingTypeVariable renames a type variable bound in body if the outer scope already has the same name,
+// not to confuse the types of outer variables.
+func (te *typeEnvironment) renameShadowingTypeVariable(name string, body *Node) (string, *Node) {
+	if !te.IsTypeVariableBound(name) {
+		return name, body
+	}
+	used := freeTypeVariables(body)
+	for _, v := range te.typeVariables {
+		used[v.name] = true
+	}
+	fresh := freshName(name, used)
+	return fresh, substType(body, name, &Node{NodeType: TypeVariable, Name: fresh})
+}
+
+func typeOfTypeAbstraction(n *Node, env *typeEnvironment) (*Node, error) {
+	bound := n.Children[1]
+	if _, err := env.kindOf(bound); err != nil {
+		return nil, err
+	}
+	name, body := env.renameShadowingTypeVariable(n.Name, n.Children[0])
+	env.AddTypeVariable(name, bound)
+	ty, err := typeOf(body, env)
+	if err != nil {
+		return nil, err
+	}
+	env.RemoveTypeVariable(name)
+	return &Node{NodeType: TypeAll, Name: name, Children: []*Node{ty, bound}}, nil
+}
+
+func typeOfTypeApplication(n *Node, env *typeEnvironment) (*Node, error) {
+	l, err := typeOf(n.Children[0], env)
+	if err != nil {
+		return nil, err
+	}
+	if l = env.expose(l); l.NodeType != TypeAll {
+		return nil, fmt.Errorf("%s is not a polymorphic function but %s", n.Children[0], l)
+	}
+	arg := n.Children[1]
+	kind, err := env.kindOf(l.Children[1])
+	if err != nil {
+		return nil, err
+	}
+	if err := env.checkKind(arg, kind); err != nil {
+		return nil, err
+	}
+	if err := env.subtype(arg, l.Children[1]); err != nil {
+		return nil, wrapSubtypeError(err, "type argument %s does not satisfy the bound %s <: %s", arg, l.Name, l.Children[1])
+	}
+	return substType(l.Children[0], l.Name, arg), nil
+}
+
+func typeOfPack(n *Node, env *typeEnvironment) (*Node, error) {
+	hidden, ty := n.Children[0], n.Children[2]
+	if ty.NodeType != TypeSome {
+		return nil, fmt.Errorf("package should be annotated with an existential type but got %s", ty)
+	}
+	if err := env.checkWellFormed(ty); err != nil {
+		return nil, err
+	}
+	kind, err := env.kindOf(ty.Children[1])
+	if err != nil {
+		return nil, err
+	}
+	if err := env.checkKind(hidden, kind); err != nil {
+		return nil, err
+	}
+	if err := env.subtype(hidden, ty.Children[1]); err != nil {
+		return nil, wrapSubtypeError(err, "hidden type %s does not satisfy the bound %s <: %s", hidden, ty.Name, ty.Children[1])
+	}
+	term, err := typeOf(n.Children[1], env)
+	if err != nil {
+		return nil, err
+	}
+	want := substType(ty.Children[0], ty.Name, hidden)
+	if err := env.subtype(term, want); err != nil {
+		return nil, wrapSubtypeError(err, "package of %s should have %s", ty, want)
+	}
+	return ty, nil
+}
+
+func typeOfUnpack(n *Node, env *typeEnvironment) (*Node, error) {
+	param := n.Children[0]
+	bound, err := typeOf(n.Children[1], env)
+	if err != nil {
+		return nil, err
+	}
+	if bound = env.expose(bound); bound.NodeType != TypeSome {
+		return nil, fmt.Errorf("%s is not a package but %s", n.Children[1], bound)
+	}
+	name, body := env.renameShadowingTypeVariable(n.Name, n.Children[2])
+	env.AddTypeVariable(name, bound.Children[1])
+	env.Assign(param.Name, substType(bound.Children[0], bound.Name, &Node{NodeType: TypeVariable, Name: name}))
+	ty, err := typeOf(body, env)
+	if err != nil {
+		return nil, err
+	}
+	env.Unassign(param.Name)
+	env.RemoveTypeVariable(name)
+	if freeTypeVariables(ty)[name] {
+		return nil, fmt.Errorf("abstract type %s escapes its scope in %s", name, ty)
+	}
+	return ty, nil
+}
+
+func typeOfTuple(n *Node, env *typeEnvironment) (*Node, error) {
+	ret := &Node{NodeType: TypeTuple, Children: make([]*Node, len(n.Children))}
+	for i, c := range n.Children {
+		ty, err := typeOf(c, env)
+		if err != nil {
+			return nil, err
+		}
+		ret.Children[i] = ty
+	}
+	return ret, nil
+}
+
+// variantField returns the type of a label in a variant type, or nil if the label does not exist.
+func variantField(ty *Node, label string) *Node {
+	for _, f := range ty.Children {
+		if f.Name == label {
+			return f.Children[0]
+		}
+	}
+	return nil
+}
+
+func typeOfVariant(n *Node, env *typeEnvironment) (*Node, error) {
+	ty := n.Children[1]
+	if err := env.checkWellFormed(ty); err != nil {
+		return nil, err
+	}
+	variant := env.expose(ty)
+	if variant.NodeType != TypeVariant {
+		return nil, fmt.Errorf("variant should be annotated with a variant type but got %s", ty)
+	}
+	want := variantField(variant, n.Name)
+	if want == nil {
+		return nil, fmt.Errorf("label %s is not in %s", n.Name, ty)
+	}
+	term, err := typeOf(n.Children[0], env)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.subtype(term, want); err != nil {
+		return nil, wrapSubtypeError(err, "label %s of %s should have %s", n.Name, ty, want)
+	}
+	return ty, nil
+}
+
+// the type of a match is the join of the types of its cases, like if.
+func typeOfMatch(n *Node, env *typeEnvironment) (*Node, error) {
+	ty, err := typeOf(n.Children[0], env)
+	if err != nil {
+		return nil, err
+	}
+	var ret *Node
+	for _, c := range n.Children[1:] {
+		var bindings []assginment
+		if err := env.typeOfPattern(c.Children[0], ty, &bindings); err != nil {
+			return nil, err
+		}
+		for _, b := range bindings {
+			env.Assign(b.name, b.value)
+		}
+		body, err := typeOf(c.Children[1], env)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range bindings {
+			env.Unassign(b.name)
+		}
+		if ret == nil {
+			ret = body
+		} else if joined, ok := env.joinBranches(ret, body); ok {
+			ret = joined
+		} else {
+			return nil, fmt.Errorf("cases of match have different types: %s and %s", ret, body)
+		}
+	}
+	env.warnings = append(env.warnings, matchWarnings(n, ty, env)...)
+	return ret, nil
+}
+
+// typeOfPattern checks that a pattern can match values of type ty, and appends types of its variables to bindings.
+func (te *typeEnvironment) typeOfPattern(p *Node, ty *Node, bindings *[]assginment) error {
+	if p.NodeType == PatternVariable {
+		if p.Name != "_" {
+			*bindings = append(*bindings, assginment{p.Name, ty})
+		}
+		return nil
+	}
+	exposed := te.expose(ty)
+	mismatch := fmt.Errorf("pattern %s cannot match %s", p, ty)
+	switch p.NodeType {
+	case True, False:
+		if exposed.NodeType != TypeBool {
+			return mismatch
+		}
+	case Zero:
+		if exposed.NodeType != TypeNat && exposed.NodeType != TypeInt {
+			return mismatch
+		}
+	case NodeNumber:
+		if exposed.NodeType != TypeInt {
+			return mismatch
+		}
+	case Succ:
+		if exposed.NodeType != TypeNat {
+			return mismatch
+		}
+		return te.typeOfPattern(p.Children[0], exposed, bindings)
+	case Nil:
+		if exposed.NodeType != TypeList {
+			return mismatch
+		}
+	case Cons:
+		if exposed.NodeType != TypeList {
+			return mismatch
+		}
+		if err := te.typeOfPattern(p.Children[0], exposed.Children[0], bindings); err != nil {
+			return err
+		}
+		return te.typeOfPattern(p.Children[1], exposed, bindings)
+	case Tuple:
+		if exposed.NodeType != TypeTuple || len(exposed.Children) != len(p.Children) {
+			return mismatch
+		}
+		for i, c := range p.Children {
+			if err := te.typeOfPattern(c, exposed.Children[i], bindings); err != nil {
+				return err
+			}
+		}
+	case Variant:
+		if exposed.NodeType != TypeVariant {
+			return mismatch
+		}
+		field := variantField(exposed, p.Name)
+		if field == nil {
+			return fmt.Errorf("pattern %s cannot match %s: label %s is not in it", p, ty, p.Name)
+		}
+		return te.typeOfPattern(p.Children[0], field, bindings)
+	default:
+		return fmt.Errorf("unknown pattern %s", p)
+	}
+	return nil
+}
@@ -0,0 +1,404 @@
+package gtl
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestTypecheck(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"true", "Bool"},
+		{"iszero 0", "Bool"},
+		{"if true then 0 else 0", "Nat"},
+		{".x:Nat -> iszero x", "Nat -> Bool"},
+		{".f:(Nat -> Bool) .x:Nat -> f x", "(Nat -> Bool) -> Nat -> Bool"},
+		{`\X -> .x:X -> x`, "All X. X -> X"},
+		{`(\X -> .x:X -> x) [Nat]`, "Nat -> Nat"},
+		{`(\X -> .x:X -> x) [All Y. Y -> Y] (\Y -> .y:Y -> y)`, "All Y. Y -> Y"},
+		{`\X -> \Y -> .x:X -> x`, "All X. All Y. X -> X"},
+		{`(\X -> \Y -> .x:X .y:Y -> x) [Nat] [Bool]`, "Nat -> Bool -> Nat"},
+		{`\X -> .x:X -> (\X -> .y:X -> x)`, "All X. X -> All X'. X' -> X"},
+		{"succ (pred 0)", "Nat"},
+		{"0i", "Int"},
+		{"{*Nat, .x:Nat -> iszero x} as {Some X, X -> Bool}", "{Some X, X -> Bool}"},
+		{"let {X, f} = {*Nat, .x:Nat -> iszero x} as {Some X, X -> Bool} in true", "Bool"},
+		{"let {X, f} = {*Nat, .x:Nat -> iszero x} as {Some X, X -> Bool} in \\Y -> .y:Y -> y", "All Y. Y -> Y"},
+	}
+	for i, v := range testcases {
+		ty, err := Typecheck(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := ty.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+func TestTypecheck_error(t *testing.T) {
+	testcases := []string{
+		"x",
+		".x -> x",
+		"if 0 then true else false",
+		"if true then 0 else false",
+		"iszero true",
+		"iszero (1 - 1)",
+		"pred 0i",
+		"true 0",
+		".x:X -> x",
+		"(.x:Nat -> x) [Nat]",
+		`(\X -> .x:X -> x) [Y]`,
+		`(\X -> .x:X -> x) [Nat] true`,
+		"{*Nat, true} as {Some X, X}",
+		"{*Nat, 0} as Nat",
+		"let {X, x} = 0 in x",
+		"let {X, f} = {*Nat, .x:Nat -> iszero x} as {Some X, X -> Bool} in f",
+		"let {X, x} = {*Nat, 0} as {Some X, X} in iszero x",
+		"cons [Nat] true (nil [Nat])",
+		"head [Nat] (nil [Bool])",
+		"iszero (head [Bool] [true])",
+		"[0, x]",
+		"[0, true]",
+		"[0, succ 0, false]",
+		"cons 0 (succ 0)",
+	}
+	for i, v := range testcases {
+		if _, err := Typecheck(buildASTFromString(v)); err == nil {
+			t.Errorf("case %d: %s should be ill-typed", i, v)
+		}
+	}
+}
+
+func TestTypecheck_list(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"nil", "All X. List X"},
+		{"nil [Nat]", "List Nat"},
+		{"cons [Nat]", "Nat -> List Nat -> List Nat"},
+		{"cons [Nat] 0 (nil [Nat])", "List Nat"},
+		{"isnil [Bool] (nil [Bool])", "Bool"},
+		{"head [Nat] (nil [Nat])", "Nat"},
+		{"tail [Nat]", "List Nat -> List Nat"},
+		{"[0, succ 0]", "List Nat"},
+		{"[[true], nil [Bool]]", "List (List Bool)"},
+		{"[.x:Top -> x, .x:Top -> 0]", "List (Top -> Top)"},
+		{"[.x:Nat -> x, .x:Top -> 0]", "List (Nat -> Nat)"},
+		{".l:(List Nat) -> head [Nat] l", "List Nat -> Nat"},
+		{"(.l:(List Top) -> l) [0]", "List Top"},
+	}
+	for i, v := range testcases {
+		ty, err := Typecheck(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := ty.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+func TestTypecheck_string(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{`"foo"`, "String"},
+		{`concat "foo"`, "String -> String"},
+		{`strlen (concat "foo" "bar")`, "Nat"},
+		{`streq "a"`, "String -> Bool"},
+		{`.s:String -> if streq s "" then "empty" else s`, "String -> String"},
+		{`["a", "b"]`, "List String"},
+	}
+	for i, v := range testcases {
+		ty, err := Typecheck(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := ty.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+	for i, src := range []string{`concat "a" 0`, `strlen 0`, `iszero (strlen "a") "a"`, `streq true`} {
+		if _, err := Typecheck(buildASTFromString(src)); err == nil {
+			t.Errorf("case %d: %s should be ill-typed", i, src)
+		}
+	}
+}
+
+func TestTypecheck_int(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"42", "Int"},
+		{"1 + 2 * 3", "Int"},
+		{"1 < 2", "Bool"},
+		{".x:Int -> x == 0", "Int -> Bool"},
+		{"succ 0 + 1", "Int"},
+		{"(.x:Int -> x) (succ 0)", "Int"},
+		{"if true then 0 else 1", "Int"},
+		{"[0, 1]", "List Int"},
+	}
+	for i, v := range testcases {
+		ty, err := Typecheck(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := ty.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+	for i, src := range []string{"1 + true", "iszero 1", "(.x:Nat -> x) 1", "1 < 2 + true", `"a" == "a"`} {
+		if _, err := Typecheck(buildASTFromString(src)); err == nil {
+			t.Errorf("case %d: %s should be ill-typed", i, src)
+		}
+	}
+}
+
+func TestTypecheck_currying(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{".x:Nat .y:Bool -> x", "Nat -> Bool -> Nat"},
+		{"(.x:Nat .y:Bool -> x) 0", "Bool -> Nat"},
+		{"(.x:Nat .y:Bool -> x) 0 true", "Nat"},
+		{"(.x:Nat -> .y:Bool -> x) 0 true", "Nat"},
+		{"(.f:(Nat -> Nat) .x:Nat -> f) succ 0 0", "Nat"},
+	}
+	for i, v := range testcases {
+		ty, err := Typecheck(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := ty.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+	for i, src := range []string{"(.x:Nat .y:Nat -> x) 0 0 0", "(.x:Nat .y:Bool -> x) 0 0"} {
+		if _, err := Typecheck(buildASTFromString(src)); err == nil {
+			t.Errorf("case %d: %s should be ill-typed", i, src)
+		}
+	}
+}
+
+func TestTypecheck_counter(t *testing.T) {
+	b, err := ioutil.ReadFile("sample/counter.tl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ast := buildASTFromString(string(b))
+	ty, err := Typecheck(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "Nat", ty.String(); got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+	n, err := Eval(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "succ (succ (succ (0)))", n.String(); got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+}
+
+func TestTypecheck_escape(t *testing.T) {
+	_, err := Typecheck(buildASTFromString("let {X, x} = {*Nat, 0} as {Some X, X} in x"))
+	if err == nil {
+		t.Fatal("abstract type should not escape")
+	}
+	if want, got := "abstract type X escapes its scope in X", err.Error(); got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+}
+
+func Test_typeEqual(t *testing.T) {
+	x := &Node{NodeType: TypeVariable, Name: "X"}
+	y := &Node{NodeType: TypeVariable, Name: "Y"}
+	top := &Node{NodeType: TypeTop}
+	allX := &Node{NodeType: TypeAll, Name: "X", Children: []*Node{arrowType(x, x), top}}
+	allY := &Node{NodeType: TypeAll, Name: "Y", Children: []*Node{arrowType(y, y), top}}
+	if !typeEqual(allX, allY) {
+		t.Errorf("%s and %s should be equal", allX, allY)
+	}
+	allXY := &Node{NodeType: TypeAll, Name: "X", Children: []*Node{arrowType(x, y), top}}
+	if typeEqual(allX, allXY) {
+		t.Errorf("%s and %s should not be equal", allX, allXY)
+	}
+}
+
+func TestTypecheck_operator(t *testing.T) {
+	pair := `(\X -> \Y -> All R. (X -> Y -> R) -> R)`
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{`.p:(` + pair + ` Nat Bool) -> p [Nat] (.x:Nat .y:Bool -> x)`, "(All R. (Nat -> Bool -> R) -> R) -> Nat"},
+		{`(.p:(` + pair + ` Nat Bool) -> p [Bool] (.x:Nat .y:Bool -> y)) (\R -> .k:(Nat -> Bool -> R) -> k 0 true)`, "Bool"},
+		{`\F:* => * -> .x:(F Nat) -> x`, "All F:* => *. F Nat -> F Nat"},
+		{`(\F:* => * -> .x:(F Nat) -> x) [\X -> X -> X]`, "(Nat -> Nat) -> Nat -> Nat"},
+		{`\F <: (\X -> X -> Top) -> .f:(F Nat) -> f 0`, "All F <: \\X -> X -> Top. F Nat -> Top"},
+	}
+	for i, v := range testcases {
+		ty, err := Typecheck(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := ty.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+func TestTypecheck_kindError(t *testing.T) {
+	testcases := []string{
+		`.x:(\X -> X) -> x`,
+		`(\F:* => * -> .x:(F Nat) -> x) [Nat]`,
+		`(\X -> .x:X -> x) [\X -> X]`,
+		`{*\X -> X, 0} as {Some X, Nat}`,
+	}
+	for i, v := range testcases {
+		if _, err := Typecheck(buildASTFromString(v)); err == nil {
+			t.Errorf("case %d: %s should be ill-kinded", i, v)
+		}
+	}
+}
+
+func TestTypecheck_declarations(t *testing.T) {
+	ast := buildASTFromString(`
+type Pair = \X -> \Y -> All R. (X -> Y -> R) -> R;
+def pair = \X -> \Y -> .x:X .y:Y -> (\R -> .k:(X -> Y -> R) -> k x y);
+def fst = \X -> \Y -> .p:(Pair X Y) -> p [X] (.x:X .y:Y -> x);
+def iseven : Nat -> Bool = .n:Nat -> if iszero n then true else if iseven (pred n) then false else true;
+iseven (fst [Nat] [Bool] (pair [Nat] [Bool] 0 true))
+`)
+	ty, err := Typecheck(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "Bool", ty.String(); got != want {
+		t.Errorf("want %v but got %v\n", want, got)
+	}
+}
+
+// definitions can refer to later ones as the parser allows
+func TestTypecheck_forwardReference(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"def a = .n:Nat -> b n; def b = .n:Nat -> succ n; a", "Nat -> Nat"},
+		{"def a = b; def b = c; def c = true; a", "Bool"},
+		{"def even : Nat -> Bool = .n:Nat -> if iszero n then true else odd (pred n); def odd : Nat -> Bool = .n:Nat -> if iszero n then false else even (pred n); odd", "Nat -> Bool"},
+		{"def even = .n:Nat -> if iszero n then true else odd (pred n); def odd : Nat -> Bool = .n:Nat -> if iszero n then false else even (pred n); even", "Nat -> Bool"},
+	}
+	for i, v := range testcases {
+		ty, err := Typecheck(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := ty.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+func TestTypecheck_declarationError(t *testing.T) {
+	testcases := []string{
+		"def f = .n:Nat -> f n; f 0",
+		"def f : Nat = true; f",
+		"def a = .n:Nat -> b n; def b = .n:Nat -> a n; a",
+		"def a = b; def b : Nat = true; a",
+		"type F = Nat Nat; 0",
+		"type F = Nat; def x = .y:G -> y; 0",
+	}
+	for i, v := range testcases {
+		if _, err := Typecheck(buildASTFromString(v)); err == nil {
+			t.Errorf("case %d: %s should be ill-typed", i, v)
+		}
+	}
+}
+
+func TestTypecheck_tupleAndVariant(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"{0, true}", "{Nat, Bool}"},
+		{"if true then {0, 1} else {1, 0}", "{Int, Int}"},
+		{"<some = 0> as <none: Top, some: Nat>", "<none: Top, some: Nat>"},
+		{"(.x:<a: Int, b: Bool> -> x) (<a = 0> as <a: Nat>)", "<a: Int, b: Bool>"},
+		{"if true then <a = 0> as <a: Nat> else <b = true> as <b: Bool>", "<a: Nat, b: Bool>"},
+		{"type Opt = <none: Top, some: Nat>; .x:Opt -> x", "<none: Top, some: Nat> -> <none: Top, some: Nat>"},
+	}
+	for i, v := range testcases {
+		ty, err := Typecheck(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := ty.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+	for i, src := range []string{
+		"<c = 0> as <a: Nat>",
+		"<a = true> as <a: Nat>",
+		"<a = 0> as Nat",
+		"(.x:<a: Nat> -> x) (<a = 0> as <a: Nat, b: Bool>)",
+		"(.x:{Nat, Nat} -> x) {0, 0, 0}",
+	} {
+		if _, err := Typecheck(buildASTFromString(src)); err == nil {
+			t.Errorf("case %d: %s should be ill-typed", i, src)
+		}
+	}
+}
+
+func TestTypecheck_control(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"callcc", "All X. ((X -> Bot) -> X) -> X"},
+		{"abort [Nat]", "Bot -> Nat"},
+		{"callcc [Nat] (.k:(Nat -> Bot) -> succ (k 0))", "Nat"},
+		{"callcc [Nat] (.k:(Nat -> Bot) -> if true then k 0 else succ 0)", "Nat"},
+		{"callcc [Nat] (.k:(Nat -> Bool) -> 0)", "Nat"},
+		{".k:(Nat -> Bot) -> abort [Bool] (k 0)", "(Nat -> Bot) -> Bool"},
+		{`\A -> \B -> .f:((A -> B) -> A) -> callcc [A] f`, "All A. All B. ((A -> B) -> A) -> A"},
+	}
+	for i, v := range testcases {
+		ty, err := Typecheck(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := ty.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+	for i, src := range []string{
+		"callcc [Nat] (.k:(Bool -> Bot) -> 0)",
+		"callcc [Nat] (.k:(Nat -> Bot) -> true)",
+		"abort [Nat] 0",
+		"callcc 0",
+	} {
+		if _, err := Typecheck(buildASTFromString(src)); err == nil {
+			t.Errorf("case %d: %s should be ill-typed", i, src)
+		}
+	}
+}
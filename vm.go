@@ -0,0 +1,504 @@
+package gtl
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// EvalVM is an alternative to Eval, which compiles the AST into bytecode and runs it on a stack-based virtual machine.
+// Values of the machine are not terms. Nat values and booleans are machine integers, and a lambda is evaluated to
+// a closure which holds the values of its free variables. Types are erased.
+// The result is read back into a term. An application which is stuck, such as an application of a free variable
+// or head nil, is a value of the machine which is read back like Eval, and so is an if whose condition is stuck.
+// But unlike Eval, a match or a let whose value is stuck is an error.
+func EvalVM(ast *AST) (*Node, error) {
+	p, err := compileProgram(ast)
+	if err != nil {
+		return nil, err
+	}
+	v, err := p.run()
+	if err != nil {
+		return nil, err
+	}
+	return v.readback(), nil
+}
+
+type vmKind uint8
+
+const (
+	vmUndefined vmKind = iota // a global variable which is not defined yet
+	vmBool
+	vmNat
+	vmInt
+	vmString
+	vmNil
+	vmCons
+	vmTuple
+	vmVariant
+	vmPack
+	vmClosure
+	vmPartial
+	vmStuck
+)
+
+// vmValue is a value of the virtual machine. values which fit in n are not allocated.
+type vmValue struct {
+	kind vmKind
+	n    int64       // Bool (0 or 1), Nat or Int
+	ref  interface{} // string, *consCell, []vmValue of Tuple, *taggedValue, *compiledClosure, *partialPrimitive or *Node of a stuck term
+}
+
+type consCell struct {
+	head, tail vmValue
+}
+
+// taggedValue is a variant or a package. node has its label and types.
+type taggedValue struct {
+	node  *Node
+	value vmValue
+}
+
+// compiledClosure is a function with the values of its captured variables, and arguments given so far.
+type compiledClosure struct {
+	fn       *function
+	captured []vmValue
+	args     []vmValue
+}
+
+// partialPrimitive is a built-in function with arguments given so far.
+type partialPrimitive struct {
+	primitive int
+	args      []vmValue
+}
+
+func vmBoolOf(b bool) vmValue {
+	if b {
+		return vmValue{kind: vmBool, n: 1}
+	}
+	return vmValue{kind: vmBool}
+}
+
+// readback returns a term of the value.
+func (v vmValue) readback() *Node {
+	switch v.kind {
+	case vmBool:
+		return boolOf(v.n != 0)
+	case vmNat:
+		return natOf(int(v.n))
+	case vmInt:
+		return &Node{NodeType: NodeNumber, Name: strconv.FormatInt(v.n, 10)}
+	case vmString:
+		return &Node{NodeType: StringLiteral, Name: v.ref.(string)}
+	case vmNil:
+		return &Node{NodeType: Nil}
+	case vmCons:
+		c := v.ref.(*consCell)
+		return &Node{NodeType: Cons, Children: []*Node{c.head.readback(), c.tail.readback()}}
+	case vmTuple:
+		fields := v.ref.([]vmValue)
+		ret := &Node{NodeType: Tuple, Children: make([]*Node, len(fields))}
+		for i, f := range fields {
+			ret.Children[i] = f.readback()
+		}
+		return ret
+	case vmVariant:
+		t := v.ref.(*taggedValue)
+		return &Node{NodeType: Variant, Name: t.node.Name, Children: []*Node{t.value.readback(), t.node.Children[1]}}
+	case vmPack:
+		t := v.ref.(*taggedValue)
+		return &Node{NodeType: Pack, Children: []*Node{t.node.Children[0], t.value.readback(), t.node.Children[2]}}
+	case vmClosure:
+		c := v.ref.(*compiledClosure)
+		n := c.fn.source
+		for i, name := range c.fn.captures {
+			n = substTerm(n, name, c.captured[i].readback())
+		}
+		for _, a := range c.args {
+			n, _ = applyLambda(n, a.readback())
+		}
+		return n
+	case vmPartial:
+		p := v.ref.(*partialPrimitive)
+		ret := &Node{NodeType: primitives[p.primitive].nodeType, Name: primitives[p.primitive].name}
+		for _, a := range p.args {
+			ret.Children = append(ret.Children, a.readback())
+		}
+		return ret
+	case vmStuck:
+		return v.ref.(*Node)
+	}
+	panic("assert!")
+}
+
+// isConstructorValue returns whether the value can be compared with constructors.
+func (v vmValue) isConstructorValue() bool {
+	switch v.kind {
+	case vmBool, vmNat, vmInt, vmNil, vmCons, vmTuple, vmVariant:
+		return true
+	}
+	return false
+}
+
+// matches returns whether a value which satisfies isConstructorValue is built with c, as constructor.matches.
+func (v vmValue) matches(c constructor) bool {
+	switch c.nodeType {
+	case Zero:
+		return (v.kind == vmNat || v.kind == vmInt) && v.n == 0
+	case NodeNumber:
+		return (v.kind == vmNat || v.kind == vmInt) && strconv.FormatInt(v.n, 10) == c.name
+	case Succ:
+		return v.kind == vmNat && v.n > 0
+	case True, False:
+		return v.kind == vmBool && (v.n != 0) == (c.nodeType == True)
+	case Nil:
+		return v.kind == vmNil
+	case Cons:
+		return v.kind == vmCons
+	case Tuple:
+		return v.kind == vmTuple && len(v.ref.([]vmValue)) == c.arity
+	case Variant:
+		return v.kind == vmVariant && v.ref.(*taggedValue).node.Name == c.name
+	}
+	return false
+}
+
+// field returns the part i of a value built with a constructor, as the child i of a term.
+func (v vmValue) field(i int) vmValue {
+	switch v.kind {
+	case vmNat:
+		return vmValue{kind: vmNat, n: v.n - 1}
+	case vmCons:
+		if i == 0 {
+			return v.ref.(*consCell).head
+		}
+		return v.ref.(*consCell).tail
+	case vmTuple:
+		return v.ref.([]vmValue)[i]
+	}
+	return v.ref.(*taggedValue).value
+}
+
+// applyVMPrimitive applies a built-in function to as many arguments as its arity.
+func applyVMPrimitive(p int, args []vmValue) (vmValue, error) {
+	a := args[0]
+	switch prim := primitives[p]; prim.nodeType {
+	case IsZero:
+		switch a.kind {
+		case vmNat:
+			return vmBoolOf(a.n == 0), nil
+		case vmBool, vmString, vmNil, vmCons:
+			return vmBoolOf(false), nil
+		}
+	case Succ:
+		if a.kind == vmNat {
+			return vmValue{kind: vmNat, n: a.n + 1}, nil
+		}
+	case Pred:
+		if a.kind == vmNat && a.n == 0 {
+			return a, nil
+		}
+		if a.kind == vmNat {
+			return vmValue{kind: vmNat, n: a.n - 1}, nil
+		}
+	case Cons:
+		return vmValue{kind: vmCons, ref: &consCell{a, args[1]}}, nil
+	case IsNil:
+		if a.kind == vmNil || a.kind == vmCons {
+			return vmBoolOf(a.kind == vmNil), nil
+		}
+	case Head:
+		if a.kind == vmCons {
+			return a.field(0), nil
+		}
+	case Tail:
+		if a.kind == vmCons {
+			return a.field(1), nil
+		}
+	case Concat, StrEq:
+		b := args[1]
+		if a.kind == vmString && b.kind == vmString && prim.nodeType == Concat {
+			return vmValue{kind: vmString, ref: a.ref.(string) + b.ref.(string)}, nil
+		}
+		if a.kind == vmString && b.kind == vmString {
+			return vmBoolOf(a.ref.(string) == b.ref.(string)), nil
+		}
+	case StrLen:
+		if a.kind == vmString {
+			return vmValue{kind: vmNat, n: int64(utf8.RuneCountInString(a.ref.(string)))}, nil
+		}
+	case BinaryOperator:
+		b := args[1]
+		if (a.kind == vmNat || a.kind == vmInt) && (b.kind == vmNat || b.kind == vmInt) {
+			ret, comparison, err := calculate(prim.name, a.n, b.n)
+			if err != nil {
+				return vmValue{}, err
+			}
+			if comparison {
+				return vmBoolOf(ret != 0), nil
+			}
+			return vmValue{kind: vmInt, n: ret}, nil
+		}
+	}
+	// the application is stuck. it is residualized by applying the arguments one by one like Eval does
+	ret := &Node{NodeType: primitives[p].nodeType, Name: primitives[p].name}
+	for _, a := range args {
+		if !ret.IsApplyable() {
+			ret = &Node{NodeType: Apply, Children: []*Node{ret, a.readback()}}
+			continue
+		}
+		var err error
+		if ret, err = applyPrimitive(ret, a.readback()); err != nil {
+			return vmValue{}, err
+		}
+	}
+	return vmValue{kind: vmStuck, ref: ret}, nil
+}
+
+// vm runs a program. its stack has the local variables of each frame followed by operands.
+type vm struct {
+	program *program
+	globals []vmValue
+	stack   []vmValue
+	frames  []vmFrame
+}
+
+type vmFrame struct {
+	closure *compiledClosure
+	pc      int
+	base    int       // the index of the first local variable in the stack
+	extra   []vmValue // arguments which the result is applied to
+	end     int       // for a branch of a stuck if, the pc where the branch returns. -1 for others
+}
+
+func (p *program) run() (vmValue, error) {
+	m := &vm{program: p, globals: make([]vmValue, len(p.globals))}
+	for _, d := range p.definitions {
+		v, err := m.run(d.fn)
+		if err != nil {
+			return vmValue{}, err
+		}
+		m.globals[d.global] = v
+	}
+	return m.run(p.main)
+}
+
+func (m *vm) push(v vmValue) {
+	m.stack = append(m.stack, v)
+}
+
+func (m *vm) pop() vmValue {
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return v
+}
+
+// enter pushes a frame of a closure whose arguments are at base in the stack.
+func (m *vm) enter(c *compiledClosure, base int, extra []vmValue) {
+	for i := c.fn.arity; i < c.fn.locals; i++ {
+		m.push(vmValue{})
+	}
+	m.frames = append(m.frames, vmFrame{closure: c, base: base, extra: extra, end: -1})
+}
+
+// call applies a function to arguments. if a closure is given all of its arguments, it enters the closure,
+// and the rest of the arguments are applied to the result when it returns. otherwise it pushes the result.
+func (m *vm) call(f vmValue, args []vmValue) error {
+	for len(args) != 0 {
+		switch f.kind {
+		case vmClosure:
+			c := f.ref.(*compiledClosure)
+			if c.fn.arity == 0 { // a type abstraction
+				break
+			}
+			need := c.fn.arity - len(c.args)
+			if len(args) < need {
+				f = vmValue{kind: vmClosure, ref: &compiledClosure{fn: c.fn, captured: c.captured, args: append(append([]vmValue{}, c.args...), args...)}}
+				args = nil
+				continue
+			}
+			base := len(m.stack)
+			m.stack = append(append(m.stack, c.args...), args[:need]...)
+			m.enter(c, base, args[need:])
+			return nil
+		case vmPartial:
+			p := f.ref.(*partialPrimitive)
+			need := primitives[p.primitive].arity - len(p.args)
+			all := append(append([]vmValue{}, p.args...), args...)
+			if len(args) < need {
+				f = vmValue{kind: vmPartial, ref: &partialPrimitive{primitive: p.primitive, args: all}}
+				args = nil
+				continue
+			}
+			v, err := applyVMPrimitive(p.primitive, all[:len(p.args)+need])
+			if err != nil {
+				return err
+			}
+			f, args = v, args[need:]
+			continue
+		}
+		// the application is stuck
+		f = vmValue{kind: vmStuck, ref: &Node{NodeType: Apply, Children: []*Node{f.readback(), args[0].readback()}}}
+		args = args[1:]
+	}
+	m.push(f)
+	return nil
+}
+
+// run runs a function without arguments until it returns.
+func (m *vm) run(fn *function) (vmValue, error) {
+	m.stack = m.stack[:0]
+	m.frames = m.frames[:0]
+	m.enter(&compiledClosure{fn: fn}, 0, nil)
+	return m.exec(0)
+}
+
+// exec runs the frames above the innermost depth frames until they return, and returns the value.
+func (m *vm) exec(depth int) (vmValue, error) {
+	for len(m.frames) != depth {
+		f := &m.frames[len(m.frames)-1]
+		ins := instruction{op: opReturn}
+		if f.pc != f.end {
+			ins = f.closure.fn.code[f.pc]
+		}
+		f.pc++
+		arg := int(ins.arg)
+		switch ins.op {
+		case opConst:
+			m.push(m.program.constants[arg])
+		case opLoadLocal:
+			m.push(m.stack[f.base+arg])
+		case opStoreLocal:
+			m.stack[f.base+arg] = m.pop()
+		case opLoadCaptured:
+			m.push(f.closure.captured[arg])
+		case opLoadGlobal:
+			v := m.globals[arg]
+			if v.kind == vmUndefined {
+				return vmValue{}, fmt.Errorf("%s is used before its definition", m.program.globals[arg])
+			}
+			m.push(v)
+		case opClosure:
+			fn := m.program.functions[arg]
+			top := len(m.stack) - len(fn.captures)
+			captured := append([]vmValue{}, m.stack[top:]...)
+			m.stack = m.stack[:top]
+			m.push(vmValue{kind: vmClosure, ref: &compiledClosure{fn: fn, captured: captured}})
+		case opCall, opTailCall:
+			top := len(m.stack) - arg
+			fv := m.stack[top-1]
+			if c, ok := fv.ref.(*compiledClosure); ok && len(c.args) == 0 && c.fn.arity == arg {
+				// the arguments become the first local variables without allocation
+				base, extra := top-1, []vmValue(nil)
+				if ins.op == opTailCall {
+					base, extra = f.base, f.extra
+					m.frames = m.frames[:len(m.frames)-1]
+				}
+				copy(m.stack[base:], m.stack[top:])
+				m.stack = m.stack[:base+arg]
+				m.enter(c, base, extra)
+				continue
+			}
+			args := append([]vmValue{}, m.stack[top:]...)
+			m.stack = m.stack[:top-1]
+			if ins.op == opTailCall {
+				args = append(args, f.extra...)
+				m.stack = m.stack[:f.base]
+				m.frames = m.frames[:len(m.frames)-1]
+			}
+			if err := m.call(fv, args); err != nil {
+				return vmValue{}, err
+			}
+		case opReturn:
+			v := m.pop()
+			m.stack = m.stack[:f.base]
+			extra := f.extra
+			m.frames = m.frames[:len(m.frames)-1]
+			if err := m.call(v, extra); err != nil {
+				return vmValue{}, err
+			}
+		case opJump:
+			f.pc = arg
+		case opJumpIfFalse:
+			v := m.pop()
+			if v.kind != vmBool {
+				ret, err := m.residualIf(v, f.pc, arg)
+				if err != nil {
+					return vmValue{}, err
+				}
+				m.push(ret)
+				f = &m.frames[len(m.frames)-1]
+				f.pc = int(f.closure.fn.code[arg-1].arg)
+				continue
+			}
+			if v.n == 0 {
+				f.pc = arg
+			}
+		case opPrimitive:
+			top := len(m.stack) - primitives[arg].arity
+			v, err := applyVMPrimitive(arg, m.stack[top:])
+			if err != nil {
+				return vmValue{}, err
+			}
+			m.stack = append(m.stack[:top], v)
+		case opInstantiate:
+			v := m.pop()
+			if c, ok := v.ref.(*compiledClosure); ok && c.fn.arity == 0 {
+				m.enter(c, len(m.stack), nil)
+			} else if (v.kind == vmNil || v.kind == vmPartial) && isListPrimitive(v.readback()) {
+				m.push(v) // nil[T] is nil at runtime
+			} else {
+				ty := m.program.nodes[arg].Children[1]
+				m.push(vmValue{kind: vmStuck, ref: &Node{NodeType: TypeApplication, Children: []*Node{v.readback(), ty}}})
+			}
+		case opTuple:
+			top := len(m.stack) - arg
+			fields := append([]vmValue{}, m.stack[top:]...)
+			m.stack = append(m.stack[:top], vmValue{kind: vmTuple, ref: fields})
+		case opVariant:
+			m.push(vmValue{kind: vmVariant, ref: &taggedValue{node: m.program.nodes[arg], value: m.pop()}})
+		case opPack:
+			m.push(vmValue{kind: vmPack, ref: &taggedValue{node: m.program.nodes[arg], value: m.pop()}})
+		case opUnpack:
+			v := m.pop()
+			if v.kind != vmPack {
+				return vmValue{}, fmt.Errorf("cannot unpack %s", v.readback())
+			}
+			m.push(v.ref.(*taggedValue).value)
+		case opField:
+			m.push(m.pop().field(arg))
+		case opTest:
+			v := m.pop()
+			if !v.isConstructorValue() {
+				return vmValue{}, fmt.Errorf("cannot match %s with patterns", v.readback())
+			}
+			m.push(vmBoolOf(v.matches(m.program.constructors[arg])))
+		case opFail:
+			return vmValue{}, fmt.Errorf("no case matches %s", m.stack[f.base+arg].readback())
+		default:
+			return vmValue{}, fmt.Errorf("unknown opcode %d", ins.op)
+		}
+	}
+	return m.pop(), nil
+}
+
+// residualIf evaluates both branches of an if whose condition is stuck, and returns the stuck if like Eval.
+// the branches start at then and els of the current function, and the then branch jumps to the end of the if.
+// each branch runs in a copy of the current frame, which returns when it reaches the end.
+func (m *vm) residualIf(cond vmValue, then, els int) (vmValue, error) {
+	ret := &Node{NodeType: IF, Children: []*Node{cond.readback(), nil, nil}}
+	depth := len(m.frames)
+	for i, pc := range []int{then, els} {
+		f := m.frames[depth-1]
+		base := len(m.stack)
+		m.stack = append(m.stack, m.stack[f.base:f.base+f.closure.fn.locals]...)
+		end := int(f.closure.fn.code[els-1].arg)
+		m.frames = append(m.frames, vmFrame{closure: f.closure, pc: pc, base: base, end: end})
+		v, err := m.exec(depth)
+		if err != nil {
+			return vmValue{}, err
+		}
+		ret.Children[i+1] = v.readback()
+	}
+	return vmValue{kind: vmStuck, ref: ret}, nil
+}
@@ -0,0 +1,219 @@
+package gtl
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// isStuck returns whether a result of Eval has a redex which cannot be reduced, outside of lambdas.
+func isStuck(n *Node) bool {
+	switch n.NodeType {
+	case Lambda, TypeAbstraction:
+		return false
+	case Apply, IF, Variable, FreeVariable, TypeApplication, Unpack, Match:
+		return true
+	}
+	for _, c := range n.Children {
+		if isStuck(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasStuckBranch returns whether a result of Eval has a match or a let which is stuck, outside of lambdas.
+func hasStuckBranch(n *Node) bool {
+	switch n.NodeType {
+	case Lambda, TypeAbstraction:
+		return false
+	case Match, Unpack:
+		return true
+	}
+	for _, c := range n.Children {
+		if hasStuckBranch(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEvalVM(t *testing.T) {
+	for i, src := range evalSources {
+		want, err := Eval(buildASTFromString(src))
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		got, err := EvalVM(buildASTFromString(src))
+		if err != nil {
+			if !hasStuckBranch(want) {
+				t.Errorf("case %d: %v", i, err)
+			}
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+	}
+}
+
+func TestEvalVM_prelude(t *testing.T) {
+	for i, src := range []string{
+		"times (succ (succ 0)) (succ (succ (succ 0)))",
+		"equal (succ 0) (succ 0)",
+		"map (plus (succ 0)) [0, succ 0]",
+		"foldr plus 0 [succ 0, succ 0]",
+		"length (append [0] [0, 0])",
+		"compose not not",
+	} {
+		ast := buildASTWithPrelude(Prelude, src)
+		want, err := Eval(ast)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		got, err := EvalVM(ast)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("case %d: want %v but got %v\n", i, want, got)
+		}
+	}
+}
+
+func TestEvalVM_files(t *testing.T) {
+	files, err := filepath.Glob("sample/*.tl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := Eval(buildASTFromString(string(b)))
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		got, err := EvalVM(buildASTFromString(string(b)))
+		if err != nil {
+			t.Errorf("%s: %v", f, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("%s: want %v but got %v\n", f, want, got)
+		}
+	}
+}
+
+// recursion does not consume the stack of Go, and tail calls do not consume frames.
+func TestEvalVM_deepRecursion(t *testing.T) {
+	testcases := []struct {
+		src  string
+		want string
+	}{
+		{"def count = .n -> if n == 0 then true else count (n - 1); count 1000000", "true"},
+		{"def sum = .n -> if n == 0 then 0 else n + sum (n - 1); sum 100000", "5000050000"},
+		{"def even = .n -> match n with | 0 -> true | succ m -> odd m; def odd = .n -> match n with | 0 -> false | succ m -> even m; even (strlen \"abcdefgh\")", "true"},
+	}
+	for i, v := range testcases {
+		n, err := EvalVM(buildASTFromString(v.src))
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := n.String(); got != v.want {
+			t.Errorf("case %d: want %v but got %v\n", i, v.want, got)
+		}
+	}
+}
+
+func TestEvalVM_error(t *testing.T) {
+	for i, src := range []string{
+		"1 / (1 - 1)",
+		"match succ 0 with | 0 -> 0",
+		"def f = g; def g = 0; f",
+		"let {X, x} = p in x",
+	} {
+		if _, err := EvalVM(buildASTFromString(src)); err == nil {
+			t.Errorf("case %d: %q should be an error", i, src)
+		}
+	}
+}
+
+// peanoSources are workloads of Peano arithmetic, where every number is built with succ.
+var peanoSources = []struct {
+	name string
+	src  string
+}{
+	{"plus", "plus (nat 500) (nat 500)"},
+	{"times", "times (nat 30) (nat 30)"},
+	{"fib", "fib (nat 15)"},
+}
+
+const peanoDefinitions = `
+def nat = .i -> if i == 0 then 0 else succ (nat (i - 1));
+def plus = .m .n -> match m with | 0 -> n | succ k -> succ (plus k n);
+def times = .m .n -> match m with | 0 -> 0 | succ k -> plus n (times k n);
+def fib = .n -> match n with | 0 -> 0 | succ 0 -> succ 0 | succ (succ k) -> plus (fib (succ k)) (fib k);
+`
+
+func TestEvalVM_peano(t *testing.T) {
+	for _, v := range peanoSources {
+		want, err := Eval(buildASTFromString(peanoDefinitions + v.src))
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		got, err := EvalVM(buildASTFromString(peanoDefinitions + v.src))
+		if err != nil {
+			t.Errorf("%s: %v", v.name, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("%s: want %v but got %v\n", v.name, want, got)
+		}
+	}
+}
+
+func benchmarkPeano(b *testing.B, eval func(*AST) (*Node, error)) {
+	for _, v := range peanoSources {
+		ast := buildASTFromString(peanoDefinitions + v.src)
+		b.Run(v.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := eval(ast); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEval_peano(b *testing.B) {
+	benchmarkPeano(b, Eval)
+}
+
+func BenchmarkEvalCEK_peano(b *testing.B) {
+	benchmarkPeano(b, EvalCEK)
+}
+
+func BenchmarkEvalVM_peano(b *testing.B) {
+	benchmarkPeano(b, EvalVM)
+}
+
+// the bytecode is compiled once, and only the machine runs in the loop.
+func BenchmarkVM_peano(b *testing.B) {
+	for _, v := range peanoSources {
+		p, err := compileProgram(buildASTFromString(peanoDefinitions + v.src))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(v.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := p.run(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
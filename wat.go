@@ -0,0 +1,333 @@
+package gtl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GenerateWAT translates a program of the simply-typed fragment into a module of the WebAssembly text format.
+// The fragment has booleans, Nat, Int, lambdas and definitions. Every value is an i64: booleans are 0 or 1,
+// and a lambda is a pointer to a closure in the linear memory.
+// Lambdas are closure-converted. Each lambda becomes a function in the table, which takes its closure and
+// its argument, and a closure holds the index of the function followed by the values of its free variables.
+// The module exports its memory and a function "main", which evaluates definitions in order and returns the result.
+func GenerateWAT(ast *AST) (string, error) {
+	g := &watGenerator{globals: make(map[string]bool)}
+	var globals []string
+	for _, d := range ast.Declarations {
+		if d.NodeType == Definition && !g.globals[d.Name] {
+			g.globals[d.Name] = true
+			globals = append(globals, d.Name)
+		}
+	}
+	var main []*watExpr
+	for _, d := range ast.Declarations {
+		if d.NodeType != Definition { // types have no runtime meaning
+			continue
+		}
+		e, err := g.expr(d.Children[0], nil)
+		if err != nil {
+			return "", fmt.Errorf("def %s: %v", d.Name, err)
+		}
+		main = append(main, &watExpr{"global.set " + watIdentifier("d_", d.Name), []*watExpr{e}})
+	}
+	e, err := g.expr(ast.Child, nil)
+	if err != nil {
+		return "", err
+	}
+	main = append(main, e)
+
+	var b strings.Builder
+	b.WriteString("(module\n")
+	b.WriteString(watRuntime)
+	for _, name := range globals {
+		fmt.Fprintf(&b, "  (global %s (mut i64) (i64.const 0))\n", watIdentifier("d_", name))
+	}
+	var elems []string
+	for _, fn := range g.functions {
+		elems = append(elems, fmt.Sprintf(" $lambda%d", fn.index))
+	}
+	fmt.Fprintf(&b, "  (table %d funcref)\n  (elem (i32.const 0)%s)\n", len(g.functions), strings.Join(elems, ""))
+	for _, fn := range g.functions {
+		b.WriteString(fn.String())
+	}
+	b.WriteString((&watExpr{`func $main (export "main") (result i64)`, main}).format("  "))
+	b.WriteString(")\n")
+	return b.String(), nil
+}
+
+// watIdentifier returns an identifier of WAT for a name with a prefix. characters which cannot be in identifiers,
+// and _ which is the escape character, are replaced with their code points.
+func watIdentifier(prefix, name string) string {
+	var b strings.Builder
+	b.WriteString("$" + prefix)
+	for _, r := range name {
+		if r < 0x80 && r != '_' && (isWordPart(string(r)) || isOperatorPart(string(r)) || r == ':') {
+			b.WriteRune(r)
+		} else {
+			fmt.Fprintf(&b, "_%x_", r)
+		}
+	}
+	return b.String()
+}
+
+// watExpr is a folded instruction, whose head has the opcode and immediates.
+type watExpr struct {
+	head string
+	args []*watExpr
+}
+
+func watConst(i int64) *watExpr {
+	return &watExpr{"i64.const " + strconv.FormatInt(i, 10), nil}
+}
+
+func watBool(e *watExpr) *watExpr {
+	return &watExpr{"i64.extend_i32_u", []*watExpr{e}}
+}
+
+// format returns the expression in a line if it is short, or its arguments in lines otherwise.
+func (e *watExpr) format(indent string) string {
+	if s := e.String(); len(indent)+len(s) <= 100 {
+		return indent + s + "\n"
+	}
+	var b strings.Builder
+	b.WriteString(indent + "(" + e.head + "\n")
+	for _, a := range e.args {
+		b.WriteString(a.format(indent + "  "))
+	}
+	return strings.TrimSuffix(b.String(), "\n") + ")\n"
+}
+
+func (e *watExpr) String() string {
+	s := "(" + e.head
+	for _, a := range e.args {
+		s += " " + a.String()
+	}
+	return s + ")"
+}
+
+// watFunction is a closure-converted lambda with a parameter.
+type watFunction struct {
+	index    int
+	param    string
+	captures []string // free variables, which are stored in the closure after the index of the function
+	body     *watExpr
+}
+
+func (fn *watFunction) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  ;; %s\n", strings.Join(append([]string{"." + fn.param}, fn.captures...), " "))
+	lambda := fmt.Sprintf("func $lambda%d (type $lambda) (param $env i32) (param $arg i64) (result i64)", fn.index)
+	b.WriteString((&watExpr{lambda, []*watExpr{fn.body}}).format("  "))
+
+	// the constructor of closures
+	closure := fmt.Sprintf("func $closure%d", fn.index)
+	for i := range fn.captures {
+		closure += fmt.Sprintf(" (param $c%d i64)", i)
+	}
+	closure += " (result i64) (local $p i32)"
+	body := []*watExpr{
+		{"local.set $p", []*watExpr{{fmt.Sprintf("call $alloc (i32.const %d)", 8*(len(fn.captures)+1)), nil}}},
+		{"i64.store (local.get $p)", []*watExpr{watConst(int64(fn.index))}},
+	}
+	for i := range fn.captures {
+		body = append(body, &watExpr{fmt.Sprintf("i64.store offset=%d (local.get $p) (local.get $c%d)", 8*(i+1), i), nil})
+	}
+	body = append(body, &watExpr{"i64.extend_i32_u (local.get $p)", nil})
+	b.WriteString((&watExpr{closure, body}).format("  "))
+	return b.String()
+}
+
+// watGenerator translates terms into instructions which push an i64.
+type watGenerator struct {
+	globals   map[string]bool
+	functions []*watFunction // in the order of the table
+}
+
+// watScope is a lambda being translated, whose enclosing lambdas are also being translated.
+type watScope struct {
+	fn     *watFunction
+	parent *watScope
+}
+
+// resolve returns an instruction which loads a variable bound in s or its enclosing lambdas.
+// a variable of an enclosing lambda is captured by every lambda between them.
+func (s *watScope) resolve(name string) (*watExpr, bool) {
+	if s == nil {
+		return nil, false
+	}
+	if s.fn.param == name {
+		return &watExpr{"local.get $arg", nil}, true
+	}
+	i := 0
+	for ; i < len(s.fn.captures) && s.fn.captures[i] != name; i++ {
+	}
+	if i == len(s.fn.captures) {
+		if _, ok := s.parent.resolve(name); !ok {
+			return nil, false
+		}
+		s.fn.captures = append(s.fn.captures, name)
+	}
+	return &watExpr{fmt.Sprintf("i64.load offset=%d (local.get $env)", 8*(i+1)), nil}, true
+}
+
+func (g *watGenerator) expr(n *Node, s *watScope) (*watExpr, error) {
+	switch n.NodeType {
+	case True:
+		return watConst(1), nil
+	case False, Zero:
+		return watConst(0), nil
+	case NodeNumber:
+		i, err := strconv.ParseInt(n.Name, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return watConst(i), nil
+	case IsZero, Succ, Pred, BinaryOperator:
+		return g.call(&Node{NodeType: n.NodeType, Name: n.Name}, n.Children, s)
+	case Variable, FreeVariable:
+		if e, ok := s.resolve(n.Name); ok {
+			return e, nil
+		}
+		if g.globals[n.Name] {
+			return &watExpr{"global.get " + watIdentifier("d_", n.Name), nil}, nil
+		}
+		return nil, fmt.Errorf("unbound variable %s", n.Name)
+	case Lambda:
+		var params []string
+		for _, p := range n.Children[0].Children {
+			params = append(params, p.Name)
+		}
+		return g.lambda(params, n.Children[1].Children[0], s)
+	case Apply:
+		var args []*Node
+		f := n
+		for ; f.NodeType == Apply; f = f.Children[0] {
+			args = append([]*Node{f.Children[1]}, args...)
+		}
+		return g.call(f, args, s)
+	case IF:
+		var es []*watExpr
+		for _, c := range n.Children {
+			e, err := g.expr(c, s)
+			if err != nil {
+				return nil, err
+			}
+			es = append(es, e)
+		}
+		return &watExpr{"if (result i64)", []*watExpr{
+			{"i32.wrap_i64", es[:1]},
+			{"then", es[1:2]},
+			{"else", es[2:]},
+		}}, nil
+	}
+	return nil, fmt.Errorf("%s is not supported by the WebAssembly backend", n.NodeType)
+}
+
+// lambda returns an instruction which makes a closure of a lambda, which is curried.
+func (g *watGenerator) lambda(params []string, body *Node, s *watScope) (*watExpr, error) {
+	fn := &watFunction{index: len(g.functions), param: params[0]}
+	g.functions = append(g.functions, fn)
+	inner := &watScope{fn: fn, parent: s}
+	var err error
+	if len(params) > 1 {
+		fn.body, err = g.lambda(params[1:], body, inner)
+	} else {
+		fn.body, err = g.expr(body, inner)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret := &watExpr{head: fmt.Sprintf("call $closure%d", fn.index)}
+	for _, c := range fn.captures {
+		e, _ := s.resolve(c)
+		ret.args = append(ret.args, e)
+	}
+	return ret, nil
+}
+
+// call applies f to args. a built-in function which is given enough arguments is inlined,
+// and otherwise it is a lambda whose parameters cannot be written in programs.
+func (g *watGenerator) call(f *Node, args []*Node, s *watScope) (*watExpr, error) {
+	var es []*watExpr
+	for _, a := range args {
+		e, err := g.expr(a, s)
+		if err != nil {
+			return nil, err
+		}
+		es = append(es, e)
+	}
+	var ret *watExpr
+	var err error
+	switch p, ok := primitiveOf(f); {
+	case ok && len(es) >= primitives[p].arity:
+		ret, err = watPrimitive(f, es)
+		es = es[primitives[p].arity:]
+	case ok:
+		var params []string
+		eta := f
+		for i := 0; i < primitives[p].arity; i++ {
+			params = append(params, "$"+strconv.Itoa(i))
+			eta = &Node{NodeType: Apply, Children: []*Node{eta, &Node{NodeType: Variable, Name: params[i]}}}
+		}
+		ret, err = g.lambda(params, eta, s)
+	default:
+		ret, err = g.expr(f, s)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range es {
+		ret = &watExpr{"call $apply", []*watExpr{ret, e}}
+	}
+	return ret, nil
+}
+
+func watPrimitive(f *Node, args []*watExpr) (*watExpr, error) {
+	switch f.NodeType {
+	case IsZero:
+		return watBool(&watExpr{"i64.eqz", args[:1]}), nil
+	case Succ:
+		return &watExpr{"i64.add", []*watExpr{args[0], watConst(1)}}, nil
+	case Pred:
+		return &watExpr{"call $pred", args[:1]}, nil
+	}
+	switch f.Name {
+	case "+":
+		return &watExpr{"i64.add", args[:2]}, nil
+	case "-":
+		return &watExpr{"i64.sub", args[:2]}, nil
+	case "*":
+		return &watExpr{"i64.mul", args[:2]}, nil
+	case "/":
+		return &watExpr{"i64.div_s", args[:2]}, nil
+	case "<":
+		return watBool(&watExpr{"i64.lt_s", args[:2]}), nil
+	case "==":
+		return watBool(&watExpr{"i64.eq", args[:2]}), nil
+	}
+	return nil, fmt.Errorf("%s is not supported by the WebAssembly backend", f)
+}
+
+// watRuntime is the part of modules which does not depend on programs.
+const watRuntime = `  (type $lambda (func (param $env i32) (param $arg i64) (result i64)))
+  (memory (export "memory") 1)
+  (global $heap (mut i32) (i32.const 8))
+  ;; alloc returns a pointer to size bytes of the linear memory, which grows if it is full.
+  (func $alloc (param $size i32) (result i32) (local $p i32)
+    (local.set $p (global.get $heap))
+    (global.set $heap (i32.add (global.get $heap) (local.get $size)))
+    (if (i32.gt_u (global.get $heap) (i32.mul (memory.size) (i32.const 65536)))
+      (then
+        (drop (memory.grow (i32.add (i32.div_u (i32.sub (global.get $heap) (i32.mul (memory.size) (i32.const 65536))) (i32.const 65536)) (i32.const 1))))))
+    (local.get $p))
+  ;; apply calls the function of a closure with the closure and an argument.
+  (func $apply (param $f i64) (param $arg i64) (result i64)
+    (call_indirect (type $lambda)
+      (i32.wrap_i64 (local.get $f))
+      (local.get $arg)
+      (i32.wrap_i64 (i64.load (i32.wrap_i64 (local.get $f))))))
+  (func $pred (param $n i64) (result i64)
+    (select (i64.sub (local.get $n) (i64.const 1)) (i64.const 0) (i64.gt_s (local.get $n) (i64.const 0))))
+`
@@ -0,0 +1,375 @@
+package gtl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sexp is an S-expression of the text format. an atom has no list.
+type sexp struct {
+	atom string
+	list []*sexp
+}
+
+func parseSexps(src string) ([]*sexp, error) {
+	var stack [][]*sexp
+	var cur []*sexp
+	for i := 0; i < len(src); {
+		switch c := src[i]; {
+		case c == ' ' || c == '\n' || c == '\t':
+			i++
+		case strings.HasPrefix(src[i:], ";;"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '(':
+			stack = append(stack, cur)
+			cur = nil
+			i++
+		case c == ')':
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("unbalanced ) at %d", i)
+			}
+			list := &sexp{list: cur}
+			cur = append(stack[len(stack)-1], list)
+			stack = stack[:len(stack)-1]
+			i++
+		default:
+			j := i
+			if c == '"' {
+				for j++; src[j] != '"'; j++ {
+				}
+				j++
+			} else {
+				for j < len(src) && !strings.ContainsRune(" \n\t()", rune(src[j])) {
+					j++
+				}
+			}
+			cur = append(cur, &sexp{atom: src[i:j]})
+			i = j
+		}
+	}
+	if len(stack) != 0 {
+		return nil, fmt.Errorf("unbalanced (")
+	}
+	return cur, nil
+}
+
+func (s *sexp) head() string {
+	if len(s.list) == 0 {
+		return ""
+	}
+	return s.list[0].atom
+}
+
+// watModule interprets the instructions which GenerateWAT emits.
+type watModule struct {
+	funcs   map[string]*sexp
+	exports map[string]string
+	globals map[string]int64
+	table   []string
+	memory  []byte
+}
+
+func loadWATModule(src string) (*watModule, error) {
+	sexps, err := parseSexps(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(sexps) != 1 || sexps[0].head() != "module" {
+		return nil, fmt.Errorf("not a module")
+	}
+	m := &watModule{funcs: make(map[string]*sexp), exports: make(map[string]string), globals: make(map[string]int64)}
+	for _, f := range sexps[0].list[1:] {
+		switch f.head() {
+		case "func":
+			name := f.list[1].atom
+			if m.funcs[name] != nil {
+				return nil, fmt.Errorf("func %s is defined twice", name)
+			}
+			m.funcs[name] = f
+			for _, c := range f.list[2:] {
+				if c.head() == "export" {
+					m.exports[strings.Trim(c.list[1].atom, `"`)] = name
+				}
+			}
+		case "global":
+			m.globals[f.list[1].atom] = 0
+		case "memory":
+			m.memory = make([]byte, 65536)
+			if f.list[1].head() == "export" {
+				m.exports[strings.Trim(f.list[1].list[1].atom, `"`)] = "memory"
+			}
+		case "elem":
+			for _, a := range f.list[2:] {
+				m.table = append(m.table, a.atom)
+			}
+		}
+	}
+	return m, nil
+}
+
+// check returns an error if an instruction refers to something which is not defined.
+func (m *watModule) check() error {
+	for _, name := range m.table {
+		if m.funcs[name] == nil {
+			return fmt.Errorf("elem refers to undefined func %s", name)
+		}
+	}
+	for name, f := range m.funcs {
+		locals := make(map[string]bool)
+		var walk func(e *sexp) error
+		walk = func(e *sexp) error {
+			switch e.head() {
+			case "param", "local":
+				locals[e.list[1].atom] = true
+			case "call":
+				if m.funcs[e.list[1].atom] == nil {
+					return fmt.Errorf("%s calls undefined func %s", name, e.list[1].atom)
+				}
+			case "global.get", "global.set":
+				if _, ok := m.globals[e.list[1].atom]; !ok && e.list[1].atom != "$heap" {
+					return fmt.Errorf("%s refers to undefined global %s", name, e.list[1].atom)
+				}
+			case "local.get", "local.set":
+				if !locals[e.list[1].atom] {
+					return fmt.Errorf("%s refers to undefined local %s", name, e.list[1].atom)
+				}
+			}
+			for _, c := range e.list {
+				if err := walk(c); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err := walk(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *watModule) call(name string, args []int64) int64 {
+	f := m.funcs[name]
+	locals := make(map[string]int64)
+	var ret int64
+	i := 0
+	for _, c := range f.list[2:] {
+		switch c.head() {
+		case "param":
+			locals[c.list[1].atom] = args[i]
+			i++
+		case "local":
+			locals[c.list[1].atom] = 0
+		case "type", "export", "result":
+		default:
+			ret = m.eval(c, locals)
+		}
+	}
+	return ret
+}
+
+func (m *watModule) eval(e *sexp, locals map[string]int64) int64 {
+	op := e.head()
+	var imm []string
+	var operands []int64
+	var then, els *sexp
+	for _, c := range e.list[1:] {
+		switch {
+		case c.atom != "":
+			imm = append(imm, c.atom)
+		case c.head() == "type" || c.head() == "result":
+		case c.head() == "then":
+			then = c
+		case c.head() == "else":
+			els = c
+		case op == "if" && then == nil:
+			operands = append(operands, m.eval(c, locals))
+		case op != "if" && op != "then" && op != "else":
+			operands = append(operands, m.eval(c, locals))
+		}
+	}
+	u32 := func(i int64) uint32 { return uint32(i) }
+	b := func(c bool) int64 {
+		if c {
+			return 1
+		}
+		return 0
+	}
+	offset := func() int64 {
+		for _, s := range imm {
+			if strings.HasPrefix(s, "offset=") {
+				i, _ := strconv.ParseInt(s[len("offset="):], 10, 64)
+				return i
+			}
+		}
+		return 0
+	}
+	switch op {
+	case "i64.const", "i32.const":
+		i, _ := strconv.ParseInt(imm[0], 10, 64)
+		return i
+	case "local.get":
+		return locals[imm[0]]
+	case "local.set":
+		locals[imm[0]] = operands[0]
+	case "global.get":
+		return m.globals[imm[0]]
+	case "global.set":
+		m.globals[imm[0]] = operands[0]
+	case "i64.add":
+		return operands[0] + operands[1]
+	case "i64.sub":
+		return operands[0] - operands[1]
+	case "i64.mul":
+		return operands[0] * operands[1]
+	case "i64.div_s":
+		return operands[0] / operands[1]
+	case "i64.lt_s":
+		return b(operands[0] < operands[1])
+	case "i64.gt_s":
+		return b(operands[0] > operands[1])
+	case "i64.eq":
+		return b(operands[0] == operands[1])
+	case "i64.eqz":
+		return b(operands[0] == 0)
+	case "i64.extend_i32_u", "i32.wrap_i64":
+		return int64(u32(operands[0]))
+	case "i32.add":
+		return int64(u32(operands[0]) + u32(operands[1]))
+	case "i32.sub":
+		return int64(u32(operands[0]) - u32(operands[1]))
+	case "i32.mul":
+		return int64(u32(operands[0]) * u32(operands[1]))
+	case "i32.div_u":
+		return int64(u32(operands[0]) / u32(operands[1]))
+	case "i32.gt_u":
+		return b(u32(operands[0]) > u32(operands[1]))
+	case "select":
+		if operands[2] != 0 {
+			return operands[0]
+		}
+		return operands[1]
+	case "if":
+		if operands[0] != 0 {
+			return m.eval(then, locals)
+		}
+		if els != nil {
+			return m.eval(els, locals)
+		}
+	case "then", "else":
+		var ret int64
+		for _, c := range e.list[1:] {
+			ret = m.eval(c, locals)
+		}
+		return ret
+	case "call":
+		return m.call(imm[0], operands)
+	case "call_indirect":
+		return m.call(m.table[operands[len(operands)-1]], operands[:len(operands)-1])
+	case "i64.load":
+		return int64(binary.LittleEndian.Uint64(m.memory[operands[0]+offset():]))
+	case "i64.store":
+		binary.LittleEndian.PutUint64(m.memory[operands[0]+offset():], uint64(operands[1]))
+	case "memory.size":
+		return int64(len(m.memory) / 65536)
+	case "memory.grow":
+		pages := int64(len(m.memory) / 65536)
+		m.memory = append(m.memory, make([]byte, operands[0]*65536)...)
+		return pages
+	case "drop":
+	default:
+		panic("unknown instruction " + op)
+	}
+	return 0
+}
+
+func TestGenerateWAT(t *testing.T) {
+	for i, src := range []string{
+		"true",
+		"if iszero (succ 0) then 0 else succ 0",
+		"(.x:Nat .y:Nat -> x) (succ 0) 0",
+		"(.f:(Nat -> Nat) .x:Nat -> f (f x)) succ 0",
+		"pred (pred (succ 0))",
+		"1 + 2 * 3 - 10 / 3",
+		"succ 0 + 1 < 3",
+		"(+) 1 2",
+		"def fact : Int -> Int = .n:Int -> if n < 2 then 1 else n * fact (n - 1); fact 20",
+		"def twice = .f:(Nat -> Nat) .x:Nat -> f (f x); twice (twice succ) 0",
+		"def plus : Nat -> Nat -> Nat = .m:Nat .n:Nat -> if iszero m then n else succ (plus (pred m) n); plus (succ (succ 0)) (succ 0)",
+		"def k = .x:Nat .y:Bool -> x; def k1 = k (succ 0); k1 true",
+		"(.x:Int -> .y:Int -> .z:Int -> x - y * z) 10 2 3",
+		"def f = .x:Nat -> (.y:Nat -> .z:Nat -> x) 0; f (succ 0) 0",
+		"def sum : Int -> Int = .n:Int -> if n == 0 then 0 else n + sum (n - 1); sum 10000",
+	} {
+		ast := buildASTFromString(src)
+		if _, err := Typecheck(ast); err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		n, err := Eval(ast)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		want, ok := intOf(n)
+		if !ok {
+			want = 0
+			if n.NodeType == True {
+				want = 1
+			}
+		}
+		wat, err := GenerateWAT(ast)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		m, err := loadWATModule(wat)
+		if err != nil {
+			t.Errorf("case %d: %v\n%s", i, err, wat)
+			continue
+		}
+		if err := m.check(); err != nil {
+			t.Errorf("case %d: %v\n%s", i, err, wat)
+			continue
+		}
+		if m.exports["main"] == "" || m.exports["memory"] == "" {
+			t.Errorf("case %d: main and memory should be exported\n%s", i, wat)
+			continue
+		}
+		if got := m.call(m.exports["main"], nil); got != want {
+			t.Errorf("case %d: want %v but got %v\n%s", i, want, got, wat)
+		}
+	}
+}
+
+// a lambda captures free variables which are bound in enclosing lambdas, even if it only passes them to inner lambdas.
+func TestGenerateWAT_closureConversion(t *testing.T) {
+	wat, err := GenerateWAT(buildASTFromString("def g = 0; .x:Nat -> .y:Nat -> .z:Nat -> {x, g}"))
+	if err == nil {
+		t.Errorf("tuples should not be supported:\n%s", wat)
+	}
+	wat, err = GenerateWAT(buildASTFromString("def g = 0; .x:Nat -> .y:Nat -> .z:Nat -> plus x g"))
+	if err == nil {
+		t.Errorf("unbound variables should not be supported:\n%s", wat)
+	}
+	wat, err = GenerateWAT(buildASTFromString("def g = 0; .x:Nat -> .y:Nat -> .z:Nat -> x + g"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{";; .x\n", ";; .y x\n", ";; .z x\n", "(table 3 funcref)", "(elem (i32.const 0) $lambda0 $lambda1 $lambda2)"} {
+		if !strings.Contains(wat, want) {
+			t.Errorf("want %q in\n%s", want, wat)
+		}
+	}
+}
+
+func TestGenerateWAT_error(t *testing.T) {
+	for i, src := range []string{`"s"`, "[0]", `\X -> 0`, "match 0 with | _ -> 0", "x"} {
+		if _, err := GenerateWAT(buildASTFromString(src)); err == nil {
+			t.Errorf("case %d: %q should not be translated", i, src)
+		}
+	}
+}